@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/thedevsaddam/renderer"
+	"golang.org/x/crypto/bcrypt"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// accountCleanup is the data of a deleted account left for the cleanup
+// worker to remove.
+type accountCleanup struct {
+	UserID			bson.ObjectId
+	TenantID		bson.ObjectId
+	// TodoIDs are the ids of the user's todos, whose audit entries and
+	// comments are removed whoever wrote them.
+	TodoIDs			[]bson.ObjectId
+	Logger			*zerolog.Logger
+}
+
+// accountCleanups queues the deleted accounts for runAccountCleanupWorker.
+var accountCleanups = make(chan accountCleanup, 100)
+
+// getAccount responds with the authenticated user's profile.
+//
+// @Summary Get the user's account
+// @Tags user
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=object{id=string,email=string,username=string,createdAt=string,unreadCount=int}}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/me [get]
+func getAccount(w http.ResponseWriter, r *http.Request) {
+	var user UserModel
+
+	if err := timedOp(r.Context(), userCollectionName + ".find", func() error {
+		return dbFor(r).C(userCollectionName).Find(inTenant(r, bson.M{
+			"_id": currentUserID(r), "deletedAt": nil,
+		})).One(&user)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "User not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch user")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch user", ""))
+		return
+	}
+
+	unread, err := unreadNotificationCount(r)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch user")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch user", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": renderer.M{
+			"id": user.ID.Hex(),
+			"email": user.Email,
+			"username": user.Username,
+			"createdAt": user.CreatedAt,
+			"unreadCount": unread,
+		},
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// deleteAccount deletes the authenticated user's account once they confirm
+// their password. Their todos, lists, audit log, comments on their todos and
+// idempotency keys are removed and their tokens, API keys and pending webhook
+// deliveries revoked straight away; everything else is left to the cleanup
+// worker. The user is soft-deleted last, so that the request can be retried
+// when a step fails.
+//
+// @Summary Delete the user's account
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param body body object{password=string} true "The user's password"
+// @Success 200 {object} MessageResponse
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/me [delete]
+func deleteAccount(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Password string `json:"password" validate:"required"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	var user UserModel
+
+	if err := timedOp(r.Context(), userCollectionName + ".find", func() error {
+		return dbFor(r).C(userCollectionName).Find(inTenant(r, bson.M{
+			"_id": currentUserID(r), "deletedAt": nil,
+		})).One(&user)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "User not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to delete account")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete account", ""))
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.Password)) != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "The password is incorrect", ""))
+		return
+	}
+
+	todoIDs := []bson.ObjectId{}
+
+	steps := []struct {
+		name	string
+		run		func() error
+	}{
+		// The entries about the todos go before the todos, so a retry still
+		// finds them.
+		{"find todos", func() error {
+			var todos []TodoModel
+			if err := dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{})).Select(bson.M{"_id": 1}).All(&todos); err != nil {
+				return err
+			}
+			for _, t := range todos {
+				todoIDs = append(todoIDs, t.ID)
+			}
+			return nil
+		}},
+		{"delete audit log", func() error {
+			_, err := dbFor(r).C(auditCollectionName).RemoveAll(bson.M{"$or": []bson.M{
+				ownedBy(r, bson.M{}),
+				{"todoID": bson.M{"$in": todoIDs}},
+			}})
+			return err
+		}},
+		{"delete comments", func() error {
+			_, err := dbFor(r).C(commentCollectionName).RemoveAll(bson.M{"todoID": bson.M{"$in": todoIDs}})
+			return err
+		}},
+		{"delete todos", func() error {
+			_, err := dbFor(r).C(cfg.CollectionName).RemoveAll(ownedBy(r, bson.M{}))
+			return err
+		}},
+		{"delete lists", func() error {
+			_, err := dbFor(r).C(listCollectionName).RemoveAll(ownedBy(r, bson.M{}))
+			return err
+		}},
+		{"revoke refresh tokens", func() error {
+			_, err := dbFor(r).C(refreshTokenCollectionName).UpdateAll(ownedBy(r, bson.M{"revoked": false}), bson.M{
+				"$set": bson.M{"revoked": true},
+			})
+			return err
+		}},
+		{"delete API keys", func() error {
+			_, err := dbFor(r).C(apiKeyCollectionName).RemoveAll(ownedBy(r, bson.M{}))
+			return err
+		}},
+		{"cancel webhook deliveries", func() error {
+			_, err := dbFor(r).C(deliveryCollectionName).UpdateAll(ownedBy(r, bson.M{"status": deliveryPending}), bson.M{
+				"$set": bson.M{"status": deliveryCancelled},
+			})
+			return err
+		}},
+		{"delete idempotency keys", func() error {
+			_, err := dbFor(r).C(idempotencyCollectionName).RemoveAll(ownedBy(r, bson.M{}))
+			return err
+		}},
+		{"soft-delete user", func() error {
+			return dbFor(r).C(userCollectionName).UpdateId(user.ID, bson.M{"$set": bson.M{"deletedAt": time.Now()}})
+		}},
+	}
+
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			logFor(r).Error().Err(err).Str("step", step.name).Msg("failed to delete account")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete account", ""))
+			return
+		}
+	}
+
+	invalidateTodoCache(r)
+
+	cleanup := accountCleanup{UserID: user.ID, TenantID: user.TenantID, TodoIDs: todoIDs, Logger: logFor(r)}
+	go func() { accountCleanups <- cleanup }()
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Account deleted successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// runAccountCleanupWorker removes the remaining data of the deleted accounts
+// queued in accountCleanups until ctx is done. Failures are only logged.
+func runAccountCleanupWorker(ctx context.Context) {
+	for {
+		select {
+		case cleanup := <-accountCleanups:
+			cleanUpAccount(cleanup)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func cleanUpAccount(cleanup accountCleanup) {
+	s := sess.Copy()
+	defer s.Close()
+
+	database := s.DB(cfg.DBName)
+	owned := bson.M{"userID": cleanup.UserID}
+	if cleanup.TenantID != bson.NilObjectId {
+		owned["tenantID"] = cleanup.TenantID
+	}
+
+	var exports []DataExportModel
+	if err := database.C(dataExportCollectionName).Find(owned).Select(bson.M{"_id": 1}).All(&exports); err != nil {
+		cleanup.Logger.Error().Err(err).Msg("failed to clean up data exports")
+	}
+	for _, e := range exports {
+		if err := database.GridFS(dataExportCollectionName).Remove(archiveName(e.ID)); err != nil {
+			cleanup.Logger.Error().Err(err).Str("exportId", e.ID.Hex()).Msg("failed to clean up data export")
+		}
+	}
+
+	aboutTodos := bson.M{"todoID": bson.M{"$in": cleanup.TodoIDs}}
+
+	removals := map[string]bson.M{
+		dataExportCollectionName: owned,
+		templateCollectionName: owned,
+		webhookCollectionName: owned,
+		viewCollectionName: owned,
+		commentCollectionName: {"$or": []bson.M{owned, aboutTodos}},
+		preferencesCollectionName: owned,
+		// The entries written by requests still in flight at deletion.
+		auditCollectionName: {"$or": []bson.M{owned, aboutTodos}},
+		idempotencyCollectionName: owned,
+		notificationCollectionName: {"recipientID": cleanup.UserID},
+		shareCollectionName: {"$or": []bson.M{{"ownerID": cleanup.UserID}, {"recipientID": cleanup.UserID}}},
+	}
+
+	for collection, filter := range removals {
+		if _, err := database.C(collection).RemoveAll(filter); err != nil {
+			cleanup.Logger.Error().Err(err).Str("collection", collection).Msg("failed to clean up deleted account")
+		}
+	}
+
+	cleanup.Logger.Info().Str("userId", cleanup.UserID.Hex()).Msg("cleaned up deleted account")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const roleAdmin string = "admin"
+
+const adminKey contextKey = "admin"
+
+// adminMiddleware only lets through users with the admin role in their access
+// token, and logs every request they make. Todo changes made through it are
+// recorded in the audit log with the owner the admin acted as.
+func adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if currentRole(r) != roleAdmin {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, "Admin access is required", ""))
+			return
+		}
+
+		logFor(r).Info().
+			Str("adminId", currentUserID(r).Hex()).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("query", r.URL.RawQuery).
+			Msg("admin action")
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminKey, true)))
+	})
+}
+
+// actingAsAdmin reports whether the request goes through adminMiddleware.
+func actingAsAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminKey).(bool)
+	return admin
+}
+
+// fetchAdminTodos lists the todos of every user of every tenant, optionally
+// filtered by the userId, tenantId, createdAfter and status query parameters.
+//
+// @Summary List the todos of every user
+// @Tags admin
+// @Produce json
+// @Param page query int false "The page, from 1"
+// @Param limit query int false "The page size, at most 100"
+// @Success 200 {object} PageEnvelope{data=[]Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/todos [get]
+func fetchAdminTodos(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	query := r.URL.Query()
+
+	if v := query.Get("userId"); v != "" {
+		if !bson.IsObjectIdHex(v) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The userId filter is invalid", ""))
+			return
+		}
+		filter["userID"] = bson.ObjectIdHex(v)
+	}
+
+	if v := query.Get("tenantId"); v != "" {
+		if !bson.IsObjectIdHex(v) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The tenantId filter is invalid", ""))
+			return
+		}
+		filter["tenantID"] = bson.ObjectIdHex(v)
+	}
+
+	if v := query.Get("createdAfter"); v != "" {
+		after, err := parseDateParam(v, time.UTC)
+		if err != nil {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The createdAfter filter must be a date", ""))
+			return
+		}
+		filter["createdAt"] = bson.M{"$gte": after}
+	}
+
+	if v := query.Get("status"); v != "" {
+		if !validStatus(v) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The status filter must be one of " + strings.Join(statuses, ", "), ""))
+			return
+		}
+		filter["status"] = v
+	}
+
+	page, ok := loadTodoPage(w, r, filter, "-createdAt")
+	if !ok {
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, page)
+}
+
+// deleteAdminTodo permanently removes any todo, archived or not.
+//
+// @Summary Delete any todo
+// @Tags admin
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/todos/{id} [delete]
+func deleteAdminTodo(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id is invalid", ""))
+		return
+	}
+
+	var todo TodoModel
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(cfg.CollectionName).FindId(bson.ObjectIdHex(id)).Apply(store.Change{
+			Remove: true,
+		}, &todo)
+		return err
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to delete todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete todo", ""))
+		return
+	}
+
+	invalidateTodoCache(r, todo.UserID)
+	publishTodoEvent(r, "deleted", todo, nil)
+	recordAudit(r, "hard_deleted", &todo, nil)
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Todo deleted successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+func adminHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(authMiddleware)
+	rg.Use(adminMiddleware)
+
+	rg.Group(func(r chi.Router) {
+		r.Get("/todos", fetchAdminTodos)
+		r.Delete("/todos/{id}", deleteAdminTodo)
+		r.Get("/cache/flush", flushTodoCache)
+		r.Get("/flags", fetchFeatureFlags)
+		r.Post("/flags", createFeatureFlag)
+		r.Put("/flags/{name}", updateFeatureFlag)
+	})
+
+	return rg
+}
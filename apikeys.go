@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	apiKeyCollectionName	string = "APIKey"
+	maxAPIKeysPerUser		int = 10
+)
+
+type APIKeyModel struct {
+	Key				string `bson:"key"`
+	UserID			bson.ObjectId `bson:"userID"`
+	TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+	Name			string `bson:"name"`
+	CreatedAt		time.Time `bson:"createdAt"`
+	LastUsed		*time.Time `bson:"lastUsed,omitempty"`
+}
+
+// @Summary Create an API key
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param body body object{name=string} true "The name of the key"
+// @Success 201 {object} object{key=string,name=string,createdAt=string}
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/api-keys [post]
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name" validate:"required,max=100"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	var n int
+
+	if err := timedOp(r.Context(), apiKeyCollectionName + ".count", func() (err error) {
+		n, err = dbFor(r).C(apiKeyCollectionName).Find(ownedBy(r, bson.M{})).Count()
+		return err
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to create API key")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to create API key", ""))
+		return
+	}
+
+	if n >= maxAPIKeysPerUser {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "A user can have at most " + strconv.Itoa(maxAPIKeysPerUser) + " API keys", ""))
+		return
+	}
+
+	apiKey := APIKeyModel{
+		Key: randomToken(),
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		Name: body.Name,
+		CreatedAt: time.Now(),
+	}
+
+	if err := timedOp(r.Context(), apiKeyCollectionName + ".insert", func() error {
+		return dbFor(r).C(apiKeyCollectionName).Insert(&apiKey)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to create API key")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to create API key", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"key": apiKey.Key,
+		"name": apiKey.Name,
+		"createdAt": apiKey.CreatedAt,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Revoke an API key
+// @Tags user
+// @Produce json
+// @Param key path string true "The API key"
+// @Success 200 {object} MessageResponse
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/api-keys/{key} [delete]
+func deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := timedOp(r.Context(), apiKeyCollectionName + ".remove", func() error {
+		return dbFor(r).C(apiKeyCollectionName).Remove(ownedBy(r, bson.M{
+			"key": chi.URLParam(r, "key"),
+		}))
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "API key not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to revoke API key")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to revoke API key", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "API key revoked successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// touchAPIKey records that the API key has just been used.
+func touchAPIKey(key string) {
+	if err := db.C(apiKeyCollectionName).Update(bson.M{"key": key}, bson.M{
+		"$set": bson.M{"lastUsed": time.Now()},
+	}); err != nil {
+		log.Error().Err(err).Msg("failed to update API key usage")
+	}
+}
+
+func userHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(jwtMiddleware)
+
+	rg.Group(func(r chi.Router) {
+		r.Post("/api-keys", createAPIKey)
+		r.Delete("/api-keys/{key}", deleteAPIKey)
+		r.Get("/data-export", startDataExport)
+		r.Get("/data-export/{jobId}", getDataExport)
+		r.Get("/me", getAccount)
+		r.Delete("/me", deleteAccount)
+		r.Get("/preferences", getPreferences)
+		r.Put("/preferences", updatePreferences)
+	})
+
+	return rg
+}
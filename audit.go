@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	auditCollectionName	string = "AuditLog"
+	maxAuditEntries		int = 100
+)
+
+type(
+	// AuditLogModel records one change to a todo. Before is nil for created
+	// todos.
+	AuditLogModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		TodoID			bson.ObjectId `bson:"todoID"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Action			string `bson:"action"`
+		Before			*TodoModel `bson:"before,omitempty"`
+		After			*TodoModel `bson:"after,omitempty"`
+		Timestamp		time.Time `bson:"timestamp"`
+		RequestID		string `bson:"requestID,omitempty"`
+		// AdminActingAs is set to the todo's owner when an admin made the
+		// change on their behalf.
+		AdminActingAs	bson.ObjectId `bson:"adminActingAs,omitempty"`
+	}
+
+	AuditEntry struct {
+		Action			string `json:"action"`
+		Timestamp		time.Time `json:"timestamp"`
+		ChangedFields	[]string `json:"changedFields"`
+	}
+)
+
+// recordAudit stores the change to a todo in the background, so the request
+// is not held up, and drops the todo's entries beyond the maxAuditEntries
+// most recent. Failures are only logged.
+func recordAudit(r *http.Request, action string, before, after *TodoModel) {
+	entry := AuditLogModel{
+		ID: bson.NewObjectId(),
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		Action: action,
+		Before: before,
+		After: after,
+		Timestamp: time.Now(),
+		RequestID: utils.GetRequestID(r.Context()),
+	}
+	if after != nil {
+		entry.TodoID = after.ID
+	} else {
+		entry.TodoID = before.ID
+	}
+	if actingAsAdmin(r) {
+		if after != nil {
+			entry.AdminActingAs = after.UserID
+		} else {
+			entry.AdminActingAs = before.UserID
+		}
+	}
+
+	logger := logFor(r)
+
+	go func() {
+		s := sess.Copy()
+		defer s.Close()
+
+		c := s.DB(cfg.DBName).C(auditCollectionName)
+
+		if err := c.Insert(&entry); err != nil {
+			logger.Error().Err(err).Str("todoId", entry.TodoID.Hex()).Msg("failed to record audit entry")
+			return
+		}
+
+		// Object ids grow with time, so everything from the first entry past
+		// the cap is older than the entries kept.
+		var oldest AuditLogModel
+		if err := c.Find(bson.M{"todoID": entry.TodoID}).Sort("-_id").Skip(maxAuditEntries).Select(bson.M{"_id": 1}).One(&oldest); err != nil {
+			return
+		}
+
+		if _, err := c.RemoveAll(bson.M{"todoID": entry.TodoID, "_id": bson.M{"$lte": oldest.ID}}); err != nil {
+			logger.Warn().Err(err).Str("todoId", entry.TodoID.Hex()).Msg("failed to trim audit log")
+		}
+	}()
+}
+
+// recordCreated records and publishes the creation of each of the todos.
+func recordCreated(r *http.Request, todos []TodoModel) {
+	for i := range todos {
+		publishTodoEvent(r, "created", todos[i], toTodo(todos[i]))
+		recordAudit(r, "created", nil, &todos[i])
+	}
+}
+
+// changedFields lists the stored fields that differ between before and
+// after. updatedAt changes with every write, so it is left out.
+func changedFields(before, after *TodoModel) []string {
+	b, a := bsonFields(before), bsonFields(after)
+
+	fields := []string{}
+	for key, v := range a {
+		if key != "updatedAt" && !reflect.DeepEqual(b[key], v) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok && key != "updatedAt" {
+			fields = append(fields, key)
+		}
+	}
+
+	sort.Strings(fields)
+	return fields
+}
+
+func bsonFields(t *TodoModel) bson.M {
+	fields := bson.M{}
+	if t == nil {
+		return fields
+	}
+
+	data, err := bson.Marshal(t)
+	utils.CheckErr(err)
+	utils.CheckErr(bson.Unmarshal(data, &fields))
+
+	return fields
+}
+
+// @Summary List the changes made to a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=[]AuditEntry}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/history [get]
+func fetchTodoHistory(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	var entries []AuditLogModel
+
+	if err := timedOp(r.Context(), auditCollectionName + ".find", func() error {
+		return dbFor(r).C(auditCollectionName).Find(bson.M{"todoID": todo.ID}).Sort("-_id").Limit(maxAuditEntries).All(&entries)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch todo history")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todo history", err.Error()))
+		return
+	}
+
+	history := []AuditEntry{}
+	for _, e := range entries {
+		history = append(history, AuditEntry{
+			Action: e.Action,
+			Timestamp: e.Timestamp,
+			ChangedFields: changedFields(e.Before, e.After),
+		})
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": history,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// updateAllTodos applies update to the user's todos matching filter and
+// records and publishes the change to each of them, returning how many were
+// updated. The user's cached lists are dropped.
+func updateAllTodos(r *http.Request, filter, update bson.M, action string) (int, error) {
+	c := dbFor(r).C(cfg.CollectionName)
+
+	var before []TodoModel
+	if err := c.Find(ownedBy(r, filter)).All(&before); err != nil || len(before) == 0 {
+		return 0, err
+	}
+
+	ids := make([]bson.ObjectId, len(before))
+	for i, t := range before {
+		ids[i] = t.ID
+	}
+
+	info, err := c.UpdateAll(bson.M{"_id": bson.M{"$in": ids}}, update)
+	if err != nil {
+		return 0, err
+	}
+
+	invalidateTodoCache(r)
+
+	var after []TodoModel
+	if err := c.Find(bson.M{"_id": bson.M{"$in": ids}}).Sort("_id").All(&after); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to record and publish the changes")
+		return info.Updated, nil
+	}
+
+	previous := map[bson.ObjectId]TodoModel{}
+	for _, t := range before {
+		previous[t.ID] = t
+	}
+	for i := range after {
+		b := previous[after[i].ID]
+		if action == "deleted" {
+			publishTodoEvent(r, "deleted", after[i], nil)
+		} else {
+			publishTodoEvent(r, "updated", after[i], toTodo(after[i]))
+		}
+		recordAudit(r, action, &b, &after[i])
+	}
+
+	return info.Updated, nil
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/golang-jwt/jwt"
+	"github.com/rs/zerolog/log"
+	"github.com/thedevsaddam/renderer"
+	"golang.org/x/crypto/bcrypt"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/config"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	userCollectionName			string = "User"
+	refreshTokenCollectionName	string = "RefreshToken"
+	accessTokenTTL				time.Duration = 15 * time.Minute
+	refreshTokenTTL				time.Duration = 30 * 24 * time.Hour
+	minPasswordLength			int = 8
+)
+
+type contextKey string
+
+const (
+	userIDKey	contextKey = "userID"
+	roleKey		contextKey = "role"
+)
+
+var jwtSecret []byte
+
+var usernamePattern = regexp.MustCompile(`^[\w.-]+$`)
+
+type(
+	UserModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Email			string `bson:"email"`
+		Username		string `bson:"username,omitempty"`
+		PasswordHash	string `bson:"passwordHash"`
+		Role			string `bson:"role,omitempty"`
+		CreatedAt		time.Time `bson:"createdAt"`
+		DeletedAt		*time.Time `bson:"deletedAt,omitempty"`
+	}
+
+	Credentials struct {
+		Email			string `json:"email" validate:"required,email"`
+		Password		string `json:"password" validate:"required"`
+		// Username is only read on registration, and lets other users
+		// @mention the user in comments.
+		Username		string `json:"username,omitempty" validate:"omitempty,max=30"`
+	}
+
+	// accessClaims are the claims of access tokens. Role is only set for
+	// admins.
+	accessClaims struct {
+		Role			string `json:"role,omitempty"`
+		jwt.StandardClaims
+	}
+
+	RefreshTokenModel struct {
+		Token			string `bson:"token"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		ExpiresAt		time.Time `bson:"expiresAt"`
+		Revoked			bool `bson:"revoked"`
+	}
+)
+
+// setupAuth sets the secret access tokens are signed with.
+func setupAuth(secret string) {
+	if secret == config.Defaults().JWTSecret {
+		log.Warn().Msg("JWT_SECRET is not set, access tokens are signed with the default secret")
+	}
+
+	jwtSecret = []byte(secret)
+}
+
+// @Summary Register a user
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body Credentials true "The email, password and optional username"
+// @Success 201 {object} object{message=string,user_id=string}
+// @Failure 400 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Router /auth/register [post]
+func register(w http.ResponseWriter, r *http.Request) {
+	var c Credentials
+
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	c.Email = strings.ToLower(strings.TrimSpace(c.Email))
+	c.Username = strings.ToLower(strings.TrimSpace(c.Username))
+
+	if !checkInput(w, r, c) {
+		return
+	}
+
+	if c.Username != "" && !usernamePattern.MatchString(c.Username) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The username must only contain letters, digits, dots, hyphens and underscores", ""))
+		return
+	}
+
+	if len(c.Password) < minPasswordLength {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The password is too short", ""))
+		return
+	}
+
+	var n int
+
+	if err := timedOp(r.Context(), userCollectionName + ".count", func() (err error) {
+		n, err = dbFor(r).C(userCollectionName).Find(inTenant(r, bson.M{"email": c.Email, "deletedAt": nil})).Count()
+		return err
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to register user")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to register user", ""))
+		return
+	}
+	if n > 0 {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The email is already registered", ""))
+		return
+	}
+
+	if c.Username != "" {
+		if err := timedOp(r.Context(), userCollectionName + ".count", func() (err error) {
+			n, err = dbFor(r).C(userCollectionName).Find(inTenant(r, bson.M{"username": c.Username, "deletedAt": nil})).Count()
+			return err
+		}); err != nil {
+			logFor(r).Error().Err(err).Msg("failed to register user")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to register user", ""))
+			return
+		}
+		if n > 0 {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The username is already taken", ""))
+			return
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcrypt.DefaultCost)
+	utils.CheckErr(err)
+
+	user := UserModel{
+		ID: bson.NewObjectId(),
+		TenantID: currentTenantID(r),
+		Email: c.Email,
+		Username: c.Username,
+		PasswordHash: string(hash),
+		CreatedAt: time.Now(),
+	}
+
+	if err := timedOp(r.Context(), userCollectionName + ".insert", func() error {
+		return dbFor(r).C(userCollectionName).Insert(&user)
+	}); err != nil {
+		// Another registration took the email since the check above.
+		if store.IsDup(err) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The email is already registered", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to register user")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to register user", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": "user registered successfully",
+		"user_id": user.ID.Hex(),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Log in
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body Credentials true "The email and password"
+// @Success 200 {object} object{token=string,refreshToken=string}
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Router /auth/login [post]
+func login(w http.ResponseWriter, r *http.Request) {
+	var c Credentials
+
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, c) {
+		return
+	}
+
+	var user UserModel
+
+	err := timedOp(r.Context(), userCollectionName + ".find", func() error {
+		return dbFor(r).C(userCollectionName).Find(inTenant(r, bson.M{
+			"email": strings.ToLower(strings.TrimSpace(c.Email)),
+			"deletedAt": nil,
+		})).One(&user)
+	})
+	if err != nil && err != store.ErrNotFound {
+		logFor(r).Error().Err(err).Msg("failed to log in")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to log in", ""))
+		return
+	}
+
+	if err == store.ErrNotFound || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(c.Password)) != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "The email or password is incorrect", ""))
+		return
+	}
+
+	issueTokens(w, r, user)
+}
+
+// @Summary Exchange a refresh token for new tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body object{refreshToken=string} true "The refresh token"
+// @Success 200 {object} object{token=string,refreshToken=string}
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Router /auth/refresh [post]
+func refresh(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refreshToken" validate:"required"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	var rt RefreshTokenModel
+
+	// Revoking the token as it is read makes each refresh token usable once.
+	err := timedOp(r.Context(), refreshTokenCollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(refreshTokenCollectionName).Find(inTenant(r, bson.M{
+			"token": body.RefreshToken,
+			"revoked": false,
+			"expiresAt": bson.M{"$gt": time.Now()},
+		})).Apply(store.Change{
+			Update: bson.M{"$set": bson.M{"revoked": true}},
+		}, &rt)
+		return err
+	})
+	if err == store.ErrNotFound {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "The refresh token is invalid", ""))
+		return
+	}
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to refresh token")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to refresh token", ""))
+		return
+	}
+
+	var user UserModel
+
+	if err := timedOp(r.Context(), userCollectionName + ".find", func() error {
+		return dbFor(r).C(userCollectionName).Find(bson.M{"_id": rt.UserID, "deletedAt": nil}).One(&user)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "The refresh token is invalid", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to refresh token")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to refresh token", ""))
+		return
+	}
+
+	issueTokens(w, r, user)
+}
+
+// @Summary Revoke a refresh token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body object{refreshToken=string} true "The refresh token"
+// @Success 200 {object} MessageResponse
+// @Failure 422 {object} ProblemDetails
+// @Router /auth/logout [post]
+func logout(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refreshToken" validate:"required"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	if err := timedOp(r.Context(), refreshTokenCollectionName + ".update", func() error {
+		return dbFor(r).C(refreshTokenCollectionName).Update(inTenant(r, bson.M{
+			"token": body.RefreshToken,
+		}), bson.M{
+			"$set": bson.M{"revoked": true},
+		})
+	}); err != nil && err != store.ErrNotFound {
+		logFor(r).Error().Err(err).Msg("failed to log out")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to log out", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "logged out successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// issueTokens responds with a new access token and refresh token for the
+// given user of the request's tenant.
+func issueTokens(w http.ResponseWriter, r *http.Request, user UserModel) {
+	token, err := newAccessToken(user, currentTenantID(r))
+	utils.CheckErr(err)
+
+	rt := RefreshTokenModel{
+		Token: randomToken(),
+		UserID: user.ID,
+		TenantID: currentTenantID(r),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+
+	if err := timedOp(r.Context(), refreshTokenCollectionName + ".insert", func() error {
+		return dbFor(r).C(refreshTokenCollectionName).Insert(&rt)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to issue refresh token")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to issue refresh token", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"token": token,
+		"refreshToken": rt.Token,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// randomToken returns a random 256-bit hex encoded token.
+func randomToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	utils.CheckErr(err)
+
+	return hex.EncodeToString(b)
+}
+
+// newAccessToken signs a JWT identifying the given user and their role. The
+// token is only accepted for requests to the user's tenant, its audience.
+func newAccessToken(user UserModel, tenantID bson.ObjectId) (string, error) {
+	now := time.Now()
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims{
+		Role: user.Role,
+		StandardClaims: jwt.StandardClaims{
+			Subject: user.ID.Hex(),
+			Audience: tenantID.Hex(),
+			IssuedAt: now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+		},
+	}).SignedString(jwtSecret)
+}
+
+// jwtMiddleware rejects requests without a valid bearer token, or with the
+// token of a deleted account, and stores the authenticated user's id in the
+// request context.
+func jwtMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+
+		if !strings.HasPrefix(header, "Bearer ") {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "Authentication is required", ""))
+			return
+		}
+
+		claims, ok := parseAccessToken(strings.TrimPrefix(header, "Bearer "))
+		if !ok || claims.Audience != currentTenantID(r).Hex() {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "The token is invalid", ""))
+			return
+		}
+
+		userID := bson.ObjectIdHex(claims.Subject)
+
+		// Tokens stay valid until they expire, so the account must still
+		// exist.
+		var n int
+		if err := timedOp(r.Context(), userCollectionName + ".count", func() (err error) {
+			n, err = dbFor(r).C(userCollectionName).Find(bson.M{"_id": userID, "deletedAt": nil}).Count()
+			return err
+		}); err != nil {
+			logFor(r).Error().Err(err).Msg("failed to authenticate")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to authenticate", ""))
+			return
+		}
+		if n == 0 {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "The token is invalid", ""))
+			return
+		}
+
+		r = withUserID(r, userID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey, claims.Role)))
+	})
+}
+
+// authMiddleware authenticates requests with a bearer token or, when no
+// bearer token is sent, with an X-API-Key header.
+func authMiddleware(next http.Handler) http.Handler {
+	withJWT := jwtMiddleware(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+
+		if key == "" || strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			withJWT.ServeHTTP(w, r)
+			return
+		}
+
+		var apiKey APIKeyModel
+
+		if err := timedOp(r.Context(), apiKeyCollectionName + ".find", func() error {
+			return dbFor(r).C(apiKeyCollectionName).Find(inTenant(r, bson.M{"key": key})).One(&apiKey)
+		}); err != nil {
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "The API key is invalid", ""))
+				return
+			}
+
+			logFor(r).Error().Err(err).Msg("failed to authenticate")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to authenticate", ""))
+			return
+		}
+
+		go touchAPIKey(apiKey.Key)
+
+		next.ServeHTTP(w, withUserID(r, apiKey.UserID))
+	})
+}
+
+// parseAccessToken validates a signed access token and returns its claims.
+// The subject is the user's id, and the audience the hex id of their tenant,
+// empty without multi-tenancy.
+func parseAccessToken(raw string) (accessClaims, bool) {
+	var claims accessClaims
+
+	token, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid || !bson.IsObjectIdHex(claims.Subject) {
+		return claims, false
+	}
+
+	return claims, true
+}
+
+// withUserID returns a copy of r carrying the authenticated user's id.
+func withUserID(r *http.Request, userID bson.ObjectId) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userIDKey, userID))
+}
+
+// currentUserID returns the id of the authenticated user.
+func currentUserID(r *http.Request) bson.ObjectId {
+	id, _ := r.Context().Value(userIDKey).(bson.ObjectId)
+	return id
+}
+
+// currentRole returns the role of the authenticated user, empty for regular
+// users and for requests authenticated with an API key.
+func currentRole(r *http.Request) string {
+	role, _ := r.Context().Value(roleKey).(string)
+	return role
+}
+
+// ownedBy restricts filter to documents belonging to the authenticated user,
+// in the request's tenant.
+func ownedBy(r *http.Request, filter bson.M) bson.M {
+	filter["userID"] = currentUserID(r)
+	return inTenant(r, filter)
+}
+
+func authHandlers() http.Handler {
+	rg := chi.NewRouter()
+
+	rg.Group(func(r chi.Router) {
+		r.Post("/register", register)
+		r.Post("/login", login)
+		r.Post("/refresh", refresh)
+		r.Post("/logout", logout)
+	})
+
+	return rg
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// bodyLimitMiddleware rejects request bodies larger than maxBytes with HTTP
+// 413. The body is read up front so that handlers only ever see bodies within
+// the limit. Paths listed in except, such as uploads, are left to enforce
+// their own limit, under every API version prefix.
+func bodyLimitMiddleware(maxBytes int64, except ...string) func(http.Handler) http.Handler {
+	exempt := map[string]bool{}
+	for _, p := range except {
+		exempt[p] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || exempt[unversionedPath(r.URL.Path)] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > maxBytes {
+				writeBodyTooLarge(w, r, maxBytes)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					writeBodyTooLarge(w, r, maxBytes)
+					return
+				}
+
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "Failed to read the body", ""))
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, r *http.Request, maxBytes int64) {
+	utils.WriteProblem(w, r, utils.NewProblem(http.StatusRequestEntityTooLarge, "The body must not exceed " + strconv.FormatInt(maxBytes, 10) + " bytes", ""))
+}
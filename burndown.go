@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// maxBurndownDays is the longest range a burndown covers.
+const maxBurndownDays int = 90
+
+// BurndownPoint counts the todos of a list completed on a day, and those
+// still left at its end.
+type BurndownPoint struct {
+	Date			string `json:"date"`
+	Completed		int `json:"completed"`
+	Remaining		int `json:"remaining"`
+}
+
+// fetchBurndown returns the burndown of the list from the start to the end
+// date, both included, one point per day in UTC. Todos completed before the
+// start are not remaining at it. When none were completed in the range, the
+// burndown is empty.
+//
+// @Summary Get the burndown of a list
+// @Tags lists
+// @Produce json
+// @Param listId path string true "The list id"
+// @Param start query string true "The first day, as YYYY-MM-DD"
+// @Param end query string true "The last day, as YYYY-MM-DD"
+// @Success 200 {object} DataEnvelope{data=[]BurndownPoint}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /lists/{listId}/burndown [get]
+func fetchBurndown(w http.ResponseWriter, r *http.Request) {
+	list, ok := findList(w, r, chi.URLParam(r, "listId"))
+	if !ok {
+		return
+	}
+
+	start, err := time.Parse("2006-01-02", r.URL.Query().Get("start"))
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The start must be a date such as 2024-01-01", ""))
+		return
+	}
+
+	end, err := time.Parse("2006-01-02", r.URL.Query().Get("end"))
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The end must be a date such as 2024-01-14", ""))
+		return
+	}
+
+	if end.Before(start) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The end must not be earlier than the start", ""))
+		return
+	}
+
+	days := int(end.Sub(start).Hours() / 24) + 1
+	if days > maxBurndownDays {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The range must not exceed 90 days", ""))
+		return
+	}
+
+	var result struct {
+		Total			statsCount `bson:"total"`
+		CompletedBefore	statsCount `bson:"completedBefore"`
+		ByDay			[]struct {
+			Date		string `bson:"_id"`
+			Count		int `bson:"count"`
+		} `bson:"byDay"`
+	}
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".aggregate", func() error {
+		return dbFor(r).C(cfg.CollectionName).Pipe([]bson.M{
+			{"$match": ownedBy(r, bson.M{"listID": list.ID, "archived": bson.M{"$ne": true}})},
+			{"$facet": bson.M{
+				"total": []bson.M{{"$count": "n"}},
+				"completedBefore": []bson.M{
+					{"$match": bson.M{"completed": true, "completedAt": bson.M{"$lt": start}}},
+					{"$count": "n"},
+				},
+				"byDay": []bson.M{
+					{"$match": bson.M{"completed": true, "completedAt": bson.M{"$gte": start, "$lt": end.AddDate(0, 0, 1)}}},
+					{"$group": bson.M{
+						"_id": bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$completedAt"}},
+						"count": bson.M{"$sum": 1},
+					}},
+				},
+			}},
+		}).One(&result)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to compute burndown")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch burndown", err.Error()))
+		return
+	}
+
+	points := []BurndownPoint{}
+
+	if len(result.ByDay) > 0 {
+		completed := map[string]int{}
+		for _, d := range result.ByDay {
+			completed[d.Date] = d.Count
+		}
+
+		remaining := result.Total.value() - result.CompletedBefore.value()
+		for i := 0; i < days; i++ {
+			date := start.AddDate(0, 0, i).Format("2006-01-02")
+			remaining -= completed[date]
+			points = append(points, BurndownPoint{Date: date, Completed: completed[date], Remaining: remaining})
+		}
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": points,
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// todoCacheTTL bounds how long a cached list can miss changes made by
+// others, such as to shared todos.
+const todoCacheTTL time.Duration = 30 * time.Second
+
+// redisClient caches todo lists when REDIS_URL is set, and is nil otherwise.
+var redisClient *redis.Client
+
+// connectRedis opens the Redis client described by cfg, if any. Redis being
+// unreachable later only disables the cache until it is back.
+func connectRedis() {
+	if cfg.RedisURL == "" {
+		return
+	}
+
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid REDIS_URL")
+	}
+
+	redisClient = redis.NewClient(opts)
+	log.Info().Str("addr", opts.Addr).Msg("caching todo lists in Redis")
+}
+
+// todoCacheKey is the key caching the todo list the request asks for, in the
+// version of the API it is for. The encoded query sorts its parameters, so
+// their order does not matter.
+func todoCacheKey(r *http.Request) string {
+	return "user:" + currentUserID(r).Hex() + ":todos:v" + strconv.Itoa(apiVersion(r)) + ":" + r.URL.Query().Encode()
+}
+
+// cachedTodoPage returns the page cached at key, if there is one.
+func cachedTodoPage(r *http.Request, key string) (json.RawMessage, bool) {
+	if redisClient == nil {
+		return nil, false
+	}
+
+	data, err := redisClient.Get(r.Context(), key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logFor(r).Warn().Err(err).Msg("failed to read todo cache")
+		}
+		return nil, false
+	}
+
+	return data, true
+}
+
+// cacheTodoPage caches the page at key for todoCacheTTL.
+func cacheTodoPage(r *http.Request, key string, page renderer.M) {
+	if redisClient == nil {
+		return
+	}
+
+	data, err := json.Marshal(page)
+	if err == nil {
+		err = redisClient.Set(r.Context(), key, data, todoCacheTTL).Err()
+	}
+	if err != nil {
+		logFor(r).Warn().Err(err).Msg("failed to write todo cache")
+	}
+}
+
+// invalidateTodoCache drops the cached todo lists of the owners of the todos
+// changed, and of the user who changed them, who lists the todos shared with
+// them too. Other recipients see the change once their pages expire.
+func invalidateTodoCache(r *http.Request, ownerIDs ...bson.ObjectId) {
+	if redisClient == nil {
+		return
+	}
+
+	users := map[bson.ObjectId]bool{currentUserID(r): true}
+	for _, id := range ownerIDs {
+		users[id] = true
+	}
+
+	for id := range users {
+		if id == bson.NilObjectId {
+			continue
+		}
+		if _, err := deleteCacheKeys(r, "user:" + id.Hex() + ":todos:*"); err != nil {
+			logFor(r).Warn().Err(err).Msg("failed to invalidate todo cache")
+		}
+	}
+}
+
+// deleteCacheKeys deletes the keys matching pattern, scanning for them in
+// batches rather than blocking Redis with KEYS.
+func deleteCacheKeys(r *http.Request, pattern string) (int, error) {
+	deleted := 0
+
+	iter := redisClient.Scan(r.Context(), 0, pattern, 100).Iterator()
+	for iter.Next(r.Context()) {
+		if err := redisClient.Del(r.Context(), iter.Val()).Err(); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, iter.Err()
+}
+
+// flushTodoCache drops every cached todo list.
+//
+// @Summary Drop every cached todo list
+// @Tags admin
+// @Produce json
+// @Success 200 {object} object{message=string,deleted=int}
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 503 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/cache/flush [get]
+func flushTodoCache(w http.ResponseWriter, r *http.Request) {
+	if redisClient == nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "The cache is not enabled", ""))
+		return
+	}
+
+	deleted, err := deleteCacheKeys(r, "user:*:todos:*")
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to flush todo cache")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusServiceUnavailable, "Failed to flush the cache", err.Error()))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Cache flushed successfully",
+		"deleted": deleted,
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,206 @@
+//go:build mongo_driver
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/broadcast"
+)
+
+const (
+	changeStreamEnabled		bool = true
+	changeStreamRetryDelay	time.Duration = 5 * time.Second
+	// changeStreamHistoryLost is the error code of a resume token whose
+	// position is no longer in the oplog.
+	changeStreamHistoryLost	int = 286
+)
+
+// ChangeStreamWatcher publishes the changes made to the todo collection, by
+// this server or by anything else writing to the database, to the hub.
+type ChangeStreamWatcher struct {
+	collection	*mongo.Collection
+	hub			*broadcast.Hub
+	resumeToken	bson.Raw
+	// preImages is set once the collection keeps the todos as they were
+	// before each change, which tell who owned a deleted todo.
+	preImages	bool
+	// owners maps the todos seen in the stream to their owners, for the
+	// deletions that come without a pre-image.
+	owners		map[primitive.ObjectID]string
+}
+
+type todoChange struct {
+	OperationType				string `bson:"operationType"`
+	DocumentKey					struct {
+		ID						primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument				*TodoModel `bson:"fullDocument"`
+	FullDocumentBeforeChange	*TodoModel `bson:"fullDocumentBeforeChange"`
+	UpdateDescription			*struct {
+		UpdatedFields			bson.M `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+}
+
+// startChangeStreamWatcher watches the todo collection until ctx is done.
+func startChangeStreamWatcher(ctx context.Context) {
+	w := &ChangeStreamWatcher{
+		collection: db.Mongo().Collection(cfg.CollectionName),
+		hub: hub,
+		owners: map[primitive.ObjectID]string{},
+	}
+	w.Run(ctx)
+}
+
+// Run watches the collection, reopening the change stream from the last
+// resume token after an error, until ctx is done. When the stream cannot be
+// resumed from the token, it is reopened from now on.
+func (w *ChangeStreamWatcher) Run(ctx context.Context) {
+	w.preImages = w.enablePreImages(ctx)
+
+	for {
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Warn().Err(err).Dur("retryIn", changeStreamRetryDelay).Msg("the change stream stopped")
+
+		if !resumable(err) {
+			w.resumeToken = nil
+		}
+
+		select {
+		case <-time.After(changeStreamRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enablePreImages has the collection keep the todos as they were before each
+// change, which needs MongoDB 6.0, and reports whether it does.
+func (w *ChangeStreamWatcher) enablePreImages(ctx context.Context) bool {
+	err := w.collection.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: w.collection.Name()},
+		{Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}},
+	}).Err()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to enable change stream pre-images, deletions are only published for the todos seen since startup")
+		return false
+	}
+
+	return true
+}
+
+func (w *ChangeStreamWatcher) watch(ctx context.Context) error {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if w.preImages {
+		opts.SetFullDocumentBeforeChange(options.WhenAvailable)
+	}
+	if w.resumeToken != nil {
+		opts.SetResumeAfter(w.resumeToken)
+	}
+
+	stream, err := w.collection.Watch(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}},
+	}, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var change todoChange
+		if err := stream.Decode(&change); err != nil {
+			log.Error().Err(err).Msg("failed to decode a change event")
+		} else {
+			w.publish(change)
+		}
+
+		w.resumeToken = stream.ResumeToken()
+	}
+
+	return stream.Err()
+}
+
+// resumable reports whether the change stream can be reopened from the resume
+// token after err.
+func resumable(err error) bool {
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		return !se.HasErrorCode(changeStreamHistoryLost) && !se.HasErrorLabel("NonResumableChangeStreamError")
+	}
+
+	return true
+}
+
+// publish sends the change to the owner of the todo.
+func (w *ChangeStreamWatcher) publish(change todoChange) {
+	if change.OperationType == "delete" {
+		w.publishDelete(change)
+		return
+	}
+
+	if change.FullDocument == nil {
+		return
+	}
+
+	todo := *change.FullDocument
+	w.owners[todo.ID] = todo.UserID.Hex()
+
+	eventType := "updated"
+	switch {
+	case change.OperationType == "insert":
+		eventType = "created"
+	case todo.Archived && change.archived():
+		eventType = "deleted"
+	}
+
+	w.hub.Broadcast(todo.UserID.Hex(), broadcast.Event{
+		Type: eventType,
+		TodoID: todo.ID.Hex(),
+		Payload: toTodo(todo),
+	})
+}
+
+// publishDelete sends the deletion of the todo in the change's document key
+// to its owner, known from the pre-image or else from the earlier changes
+// seen. A deletion whose owner is unknown is dropped.
+func (w *ChangeStreamWatcher) publishDelete(change todoChange) {
+	id := change.DocumentKey.ID
+
+	owner, ok := w.owners[id]
+	if change.FullDocumentBeforeChange != nil {
+		owner, ok = change.FullDocumentBeforeChange.UserID.Hex(), true
+	}
+	delete(w.owners, id)
+
+	if !ok {
+		log.Debug().Str("todoId", id.Hex()).Msg("dropped the deletion of a todo whose owner is unknown")
+		return
+	}
+
+	w.hub.Broadcast(owner, broadcast.Event{
+		Type: "deleted",
+		TodoID: id.Hex(),
+	})
+}
+
+// archived reports whether the change is an update of archivedAt, which
+// archiving sets. Other updates to an archived todo are not deletions.
+func (c todoChange) archived() bool {
+	if c.UpdateDescription == nil {
+		return false
+	}
+
+	_, ok := c.UpdateDescription.UpdatedFields["archivedAt"]
+	return ok
+}
@@ -0,0 +1,60 @@
+//go:build mongo_driver
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+// deleteChange is the change event of deleting the todo with the id, with the
+// pre-image if one is given.
+func deleteChange(id bson.ObjectId, before *TodoModel) todoChange {
+	change := todoChange{OperationType: "delete", FullDocumentBeforeChange: before}
+	change.DocumentKey.ID = id
+	return change
+}
+
+func TestChangeStreamPublishesDeletions(t *testing.T) {
+	userID := bson.NewObjectId()
+	sub := hub.Subscribe(userID.Hex())
+	defer hub.Unsubscribe(sub)
+
+	w := &ChangeStreamWatcher{hub: hub, owners: map[primitive.ObjectID]string{}}
+
+	seen := TodoModel{ID: bson.NewObjectId(), UserID: userID, Title: "Buy milk"}
+	w.publish(todoChange{OperationType: "insert", FullDocument: &seen})
+	<-sub.Events
+
+	preImaged := TodoModel{ID: bson.NewObjectId(), UserID: userID, Title: "Walk the dog"}
+
+	// A deletion is published from the owner seen earlier, or from the
+	// pre-image, and dropped when the owner is unknown.
+	w.publish(deleteChange(bson.NewObjectId(), nil))
+	w.publish(deleteChange(seen.ID, nil))
+	w.publish(deleteChange(preImaged.ID, &preImaged))
+
+	for _, id := range []bson.ObjectId{seen.ID, preImaged.ID} {
+		select {
+		case event := <-sub.Events:
+			if event.Type != "deleted" || event.TodoID != id.Hex() {
+				t.Errorf("event = %s for %s, want deleted for %s", event.Type, event.TodoID, id.Hex())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the deletion of %s was not published", id.Hex())
+		}
+	}
+
+	if _, ok := w.owners[seen.ID]; ok {
+		t.Error("the owner of the deleted todo is still remembered")
+	}
+
+	select {
+	case event := <-sub.Events:
+		t.Errorf("unexpected event %s for %s", event.Type, event.TodoID)
+	default:
+	}
+}
@@ -0,0 +1,49 @@
+// Command migrate-status gives the todos saved before statuses were added a
+// status matching their completed flag: done for completed todos and backlog
+// for the others. It can safely be run more than once.
+package main
+
+import (
+	"flag"
+
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/config"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a YAML config file")
+	flag.Parse()
+
+	cfg := config.Load()
+	if *configPath != "" {
+		var err error
+		if cfg, err = config.LoadFromFile(*configPath); err != nil {
+			log.Fatal().Err(err).Str("path", *configPath).Msg("failed to load config")
+		}
+	}
+
+	sess, err := store.DialWithRetry(store.DialInfo{HostName: cfg.HostName, Timeout: cfg.MongoTimeout}, cfg.MongoMaxRetries)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to MongoDB")
+	}
+	defer sess.Close()
+
+	c := sess.DB(cfg.DBName).C(cfg.CollectionName)
+
+	for status, completed := range map[string]interface{}{
+		"done": true,
+		"backlog": bson.M{"$ne": true},
+	} {
+		info, err := c.UpdateAll(bson.M{
+			"status": bson.M{"$exists": false},
+			"completed": completed,
+		}, bson.M{"$set": bson.M{"status": status}})
+		if err != nil {
+			log.Fatal().Err(err).Str("status", status).Msg("failed to migrate todos")
+		}
+
+		log.Info().Str("status", status).Int("todos", info.Updated).Msg("migrated todos")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const commentCollectionName string = "Comment"
+
+type(
+	CommentModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		TodoID			bson.ObjectId `bson:"todoID"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Body			string `bson:"body"`
+		CreatedAt		time.Time `bson:"createdAt"`
+		UpdatedAt		time.Time `bson:"updatedAt"`
+		Edited			bool `bson:"edited"`
+	}
+
+	Comment struct {
+		ID				string `json:"id"`
+		TodoID			string `json:"todoId"`
+		UserID			string `json:"userId"`
+		Body			string `json:"body" validate:"required,max=4000"`
+		CreatedAt		time.Time `json:"createdAt"`
+		UpdatedAt		time.Time `json:"updatedAt"`
+		Edited			bool `json:"edited"`
+	}
+)
+
+func toComment(c CommentModel) Comment {
+	return Comment{
+		ID: c.ID.Hex(),
+		TodoID: c.TodoID.Hex(),
+		UserID: c.UserID.Hex(),
+		Body: c.Body,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Edited: c.Edited,
+	}
+}
+
+// fetchComments lists the comments of the todo, oldest first.
+//
+// @Summary List the comments of a todo
+// @Tags comments
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param page query int false "The page, from 1"
+// @Param limit query int false "The page size, at most 100"
+// @Success 200 {object} PageEnvelope{data=[]Comment}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/comments [get]
+func fetchComments(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, false)
+	if !ok {
+		return
+	}
+
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return
+	}
+
+	query := dbFor(r).C(commentCollectionName).Find(bson.M{"todoID": todo.ID})
+
+	var total int
+
+	if err := timedOp(r.Context(), commentCollectionName + ".count", func() (err error) {
+		total, err = query.Count()
+		return err
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch comments")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch comments", err.Error()))
+		return
+	}
+
+	var comments []CommentModel
+
+	if err := timedOp(r.Context(), commentCollectionName + ".find", func() error {
+		return query.Sort("createdAt", "_id").Skip((page - 1) * limit).Limit(limit).All(&comments)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch comments")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch comments", err.Error()))
+		return
+	}
+
+	commentList := []Comment{}
+	for _, c := range comments {
+		commentList = append(commentList, toComment(c))
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": commentList,
+		"total": total,
+		"page": page,
+		"limit": limit,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// decodeComment reads and validates the comment in the request body. When it
+// is invalid, the error response is written and ok is false.
+func decodeComment(w http.ResponseWriter, r *http.Request) (c Comment, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return c, false
+	}
+
+	c.Body = strings.TrimSpace(c.Body)
+	if !checkInput(w, r, c) {
+		return c, false
+	}
+
+	return c, true
+}
+
+// createComment adds a comment to the todo, which anyone it is shared with
+// can do, and bumps the todo's updatedAt.
+//
+// @Summary Comment on a todo
+// @Tags comments
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body Comment true "The comment"
+// @Success 201 {object} DataEnvelope{data=Comment}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/comments [post]
+func createComment(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, false)
+	if !ok {
+		return
+	}
+
+	c, ok := decodeComment(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	comment := CommentModel{
+		ID: bson.NewObjectId(),
+		TodoID: todo.ID,
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		Body: c.Body,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := timedOp(r.Context(), commentCollectionName + ".insert", func() error {
+		return dbFor(r).C(commentCollectionName).Insert(&comment)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save comment")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save comment", ""))
+		return
+	}
+
+	notifyMentions(r, comment)
+
+	// The comment is saved either way, so a failure is only logged.
+	if err := timedOp(r.Context(), cfg.CollectionName + ".update", func() error {
+		return dbFor(r).C(cfg.CollectionName).UpdateId(todo.ID, bson.M{"$set": bson.M{"updatedAt": now}})
+	}); err != nil {
+		logFor(r).Warn().Err(err).Msg("failed to bump todo updatedAt")
+	}
+	invalidateTodoCache(r, todo.UserID)
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": toComment(comment),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// updateComment edits the comment, which only its author can do.
+//
+// @Summary Edit a comment
+// @Tags comments
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param commentId path string true "The comment id"
+// @Param body body Comment true "The comment"
+// @Success 200 {object} DataEnvelope{data=Comment}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/comments/{commentId} [put]
+func updateComment(w http.ResponseWriter, r *http.Request) {
+	_, comment, ok := findComment(w, r)
+	if !ok {
+		return
+	}
+
+	if comment.UserID != currentUserID(r) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, "Only the author can edit a comment", ""))
+		return
+	}
+
+	c, ok := decodeComment(w, r)
+	if !ok {
+		return
+	}
+
+	comment.Body, comment.UpdatedAt, comment.Edited = c.Body, time.Now(), true
+
+	if err := timedOp(r.Context(), commentCollectionName + ".update", func() error {
+		return dbFor(r).C(commentCollectionName).UpdateId(comment.ID, bson.M{
+			"$set": bson.M{"body": comment.Body, "updatedAt": comment.UpdatedAt, "edited": true},
+		})
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to update comment")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update comment", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toComment(comment),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// deleteComment removes the comment, which its author and admins can do.
+//
+// @Summary Delete a comment
+// @Tags comments
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param commentId path string true "The comment id"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/comments/{commentId} [delete]
+func deleteComment(w http.ResponseWriter, r *http.Request) {
+	todo, comment, ok := findComment(w, r)
+	if !ok {
+		return
+	}
+
+	if comment.UserID != currentUserID(r) && currentRole(r) != roleAdmin {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, "Only the author can delete a comment", ""))
+		return
+	}
+
+	if err := timedOp(r.Context(), commentCollectionName + ".remove", func() error {
+		return dbFor(r).C(commentCollectionName).RemoveId(comment.ID)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to delete comment")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete comment", ""))
+		return
+	}
+
+	// The todo's comment count changed.
+	invalidateTodoCache(r, todo.UserID)
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Comment deleted successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// findComment loads the comment identified by {commentId} on the todo
+// identified by {id}, which must be one the user can see, along with the todo.
+// When either is missing, the error response is written and ok is false.
+func findComment(w http.ResponseWriter, r *http.Request) (todo TodoModel, comment CommentModel, ok bool) {
+	todo, ok = findAccessibleTodo(w, r, false)
+	if !ok {
+		return todo, comment, false
+	}
+
+	id := strings.TrimSpace(chi.URLParam(r, "commentId"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The comment id is invalid", ""))
+		return todo, comment, false
+	}
+
+	if err := timedOp(r.Context(), commentCollectionName + ".find", func() error {
+		return dbFor(r).C(commentCollectionName).Find(bson.M{"_id": bson.ObjectIdHex(id), "todoID": todo.ID}).One(&comment)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Comment not found", ""))
+			return todo, comment, false
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch comment")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch comment", err.Error()))
+		return todo, comment, false
+	}
+
+	return todo, comment, true
+}
+
+// setCommentCounts sets the number of comments of the todos in list,
+// converted from todos.
+func setCommentCounts(r *http.Request, todos []TodoModel, list []Todo) error {
+	if len(todos) == 0 {
+		return nil
+	}
+
+	ids := make([]bson.ObjectId, len(todos))
+	for i, t := range todos {
+		ids[i] = t.ID
+	}
+
+	var counts []struct {
+		TodoID	bson.ObjectId `bson:"_id"`
+		Count	int `bson:"count"`
+	}
+
+	if err := dbFor(r).C(commentCollectionName).Pipe([]bson.M{
+		{"$match": bson.M{"todoID": bson.M{"$in": ids}}},
+		{"$group": bson.M{"_id": "$todoID", "count": bson.M{"$sum": 1}}},
+	}).All(&counts); err != nil {
+		return err
+	}
+
+	byTodo := map[bson.ObjectId]int{}
+	for _, c := range counts {
+		byTodo[c.TodoID] = c.Count
+	}
+
+	for i, t := range todos {
+		list[i].CommentCount = byTodo[t.ID]
+	}
+
+	return nil
+}
+
+func commentHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(jsonAPIMiddleware("comments"))
+
+	rg.Group(func(r chi.Router) {
+		r.Get("/", fetchComments)
+		r.Post("/", createComment)
+		r.Put("/{commentId}", updateComment)
+		r.Delete("/{commentId}", deleteComment)
+	})
+
+	return rg
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const minCompressionSize int = 1024
+
+var compressibleTypes = []string{"application/json", "application/msgpack", "text/html"}
+
+// compressionMiddleware gzips JSON, MessagePack and HTML responses of at least
+// minCompressionSize bytes for clients that accept gzip.
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter holds back the start of a response until it knows
+// whether the response is worth compressing.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status		int
+	buf			[]byte
+	gz			*gzip.Writer
+	passthrough	bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.passthrough {
+		return g.ResponseWriter.Write(p)
+	}
+
+	if g.Header().Get("Content-Type") == "" {
+		g.Header().Set("Content-Type", http.DetectContentType(p))
+	}
+
+	if !g.compressible() {
+		if err := g.startPassthrough(); err != nil {
+			return 0, err
+		}
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= minCompressionSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// compressible reports whether the response may be compressed.
+func (g *gzipResponseWriter) compressible() bool {
+	if g.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := g.Header().Get("Content-Type")
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *gzipResponseWriter) startGzip() error {
+	g.Header().Set("Content-Encoding", "gzip")
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+
+	return err
+}
+
+func (g *gzipResponseWriter) startPassthrough() error {
+	g.passthrough = true
+	g.ResponseWriter.WriteHeader(g.status)
+
+	_, err := g.ResponseWriter.Write(g.buf)
+	g.buf = nil
+
+	return err
+}
+
+// Close sends whatever is still held back. Responses that stayed under
+// minCompressionSize are sent uncompressed.
+func (g *gzipResponseWriter) Close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if g.passthrough || g.status == 0 {
+		return nil
+	}
+
+	return g.startPassthrough()
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	} else if !g.passthrough {
+		if g.status == 0 {
+			g.status = http.StatusOK
+		}
+		g.startPassthrough()
+	}
+
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+
+	g.passthrough = true
+	return h.Hijack()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	corsAllowedMethods	string = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders	string = "Accept, Authorization, Content-Type, Idempotency-Key, X-API-Key, X-Request-ID, X-Tenant-ID"
+	corsMaxAge			string = "600"
+)
+
+// corsMiddleware allows cross-origin requests from the given origins, where
+// "*" allows any origin. Requests from other origins are rejected; same-origin
+// requests are always allowed.
+func corsMiddleware(origins []string, credentials bool) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || isSameOrigin(r, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+
+			if !allowed[origin] && !allowed["*"] {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, "The origin is not allowed", ""))
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", utils.RequestIDHeader)
+			if credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isSameOrigin reports whether origin is the host the request was sent to.
+func isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
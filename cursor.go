@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+var errInvalidCursor = errors.New("The cursor is invalid")
+
+// nullableFields are the sort fields todos can be missing, which MongoDB
+// sorts before any value.
+var nullableFields = map[string]bool{"pinnedAt": true}
+
+// todoCursor marks the last todo of a page: the sort it was listed with, the
+// values of its sort fields and its id. Clients get it encoded as an opaque,
+// URL-safe string and must only ever send back the nextCursor they were
+// given.
+type todoCursor struct {
+	Sort		string `json:"s,omitempty"`
+	Values		[]json.RawMessage `json:"v,omitempty"`
+	ID			bson.ObjectId `json:"id"`
+}
+
+// loadTodoCursorPage is loadTodoPage paginated by the cursor query parameter
+// rather than by page. Each page resumes right after the todo the cursor
+// marks, so later pages cost no more than the first. The id breaks ties
+// between todos with the same sort values.
+func loadTodoCursorPage(w http.ResponseWriter, r *http.Request, filter bson.M, sort ...string) (renderer.M, bool) {
+	_, limit, err := parsePagination(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return nil, false
+	}
+
+	order := "_id"
+	if len(sort) > 0 && strings.HasPrefix(sort[len(sort)-1], "-") {
+		order = "-_id"
+	}
+	sort = append(sort[:len(sort):len(sort)], order)
+
+	query := filter
+
+	raw := r.URL.Query().Get("cursor")
+	if raw != "" {
+		after, err := cursorFilter(raw, sort)
+		if err != nil {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+			return nil, false
+		}
+		query = bson.M{"$and": []bson.M{filter, after}}
+	}
+
+	var total int
+
+	span := startMongoSpan(r, "mongo.count", filter)
+	err = timedOp(r.Context(), cfg.CollectionName + ".count", func() (err error) {
+		total, err = dbFor(r).C(cfg.CollectionName).Find(filter).Count()
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	var todos []TodoModel
+
+	span = startMongoSpan(r, "mongo.find", query)
+	err = timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(query).Sort(sort...).Limit(limit).All(&todos)
+	})
+	endSpan(span, err)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	list := listedTodos(todos)
+	if err := decorateTodos(r, todos, list); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	page := renderer.M{
+		"data": projectTodos(r, list),
+		"total": total,
+		"limit": limit,
+	}
+
+	if raw == "" {
+		page["page"] = 1
+	}
+
+	// A short page is the last one.
+	if len(todos) == limit {
+		page["nextCursor"] = encodeCursor(sort, todos[len(todos)-1])
+	}
+
+	return page, true
+}
+
+func encodeCursor(sort []string, last TodoModel) string {
+	c := todoCursor{Sort: strings.Join(sort, ","), ID: last.ID}
+
+	for _, field := range sort[:len(sort)-1] {
+		value, err := json.Marshal(cursorValue(strings.TrimPrefix(field, "-"), last))
+		utils.CheckErr(err)
+		c.Values = append(c.Values, value)
+	}
+
+	data, err := json.Marshal(c)
+	utils.CheckErr(err)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// cursorValue returns the value of the sort field of t.
+func cursorValue(field string, t TodoModel) interface{} {
+	switch field {
+	case "createdAt":
+		return t.CreatedAt
+	case "updatedAt":
+		return t.UpdatedAt
+	case "priorityOrder":
+		return t.PriorityOrder
+	case "position":
+		return t.Position
+	case "pinnedAt":
+		return t.PinnedAt
+	default:
+		return t.Title
+	}
+}
+
+// cursorFilter decodes raw and returns the filter matching the todos after
+// the one it marks, in the order given by sort, whose last field is the id.
+// The cursor must come from a list with the same sort.
+func cursorFilter(raw string, sort []string) (bson.M, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var c todoCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == bson.NilObjectId ||
+		c.Sort != strings.Join(sort, ",") || len(c.Values) != len(sort)-1 {
+		return nil, errInvalidCursor
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, raw := range c.Values {
+		value, err := decodeCursorValue(strings.TrimPrefix(sort[i], "-"), raw)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		values[i] = value
+	}
+	values[len(sort)-1] = c.ID
+
+	// A todo comes after the cursor when it ties on the first sort fields
+	// and is past it on the next one.
+	var after []bson.M
+	for i, field := range sort {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[strings.TrimPrefix(sort[j], "-")] = values[j]
+		}
+
+		op := "$gt"
+		if strings.HasPrefix(field, "-") {
+			op, field = "$lt", field[1:]
+		}
+
+		switch {
+		case values[i] == nil && op == "$gt":
+			// Missing values sort first, so every value comes after them.
+			clause[field] = bson.M{"$ne": nil}
+		case values[i] == nil:
+			continue
+		case op == "$lt" && nullableFields[field]:
+			clause["$or"] = []bson.M{{field: bson.M{op: values[i]}}, {field: nil}}
+		default:
+			clause[field] = bson.M{op: values[i]}
+		}
+
+		after = append(after, clause)
+	}
+
+	return bson.M{"$or": after}, nil
+}
+
+func decodeCursorValue(field string, raw json.RawMessage) (interface{}, error) {
+	if string(raw) == "null" {
+		return nil, nil
+	}
+
+	switch field {
+	case "createdAt", "updatedAt", "pinnedAt":
+		var t time.Time
+		err := json.Unmarshal(raw, &t)
+		return t, err
+	case "priorityOrder":
+		var n int
+		err := json.Unmarshal(raw, &n)
+		return n, err
+	case "position":
+		var n float64
+		err := json.Unmarshal(raw, &n)
+		return n, err
+	default:
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// dataExportCollectionName holds the export jobs, and prefixes the GridFS
+// collections holding their archives.
+const dataExportCollectionName string = "DataExport"
+
+const (
+	exportPending	string = "pending"
+	exportReady		string = "ready"
+	exportFailed	string = "failed"
+)
+
+const dataExportReadme = `This archive holds all the data stored about your account.
+
+Each file is a JSON array of the documents of one collection, with every
+stored field. Ids are hex strings and times are RFC 3339 timestamps.
+
+user.json         your account, without the password hash
+todos.json        your todos, archived ones included
+lists.json        your lists
+templates.json    your todo templates
+webhooks.json     your webhooks
+preferences.json  your preferences
+comments.json     the comments you wrote
+audit_log.json    the history of the changes made to your todos
+`
+
+type(
+	DataExportModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Status			string `bson:"status"`
+		Error			string `bson:"error,omitempty"`
+		CreatedAt		time.Time `bson:"createdAt"`
+		CompletedAt		*time.Time `bson:"completedAt,omitempty"`
+	}
+
+	DataExport struct {
+		ID				string `json:"id"`
+		Status			string `json:"status"`
+		CreatedAt		time.Time `json:"createdAt"`
+		CompletedAt		*time.Time `json:"completedAt,omitempty"`
+	}
+
+	// exportSource is a file of the archive and the documents it holds.
+	exportSource struct {
+		File			string
+		Collection		string
+		Filter			bson.M
+		Select			bson.M
+	}
+)
+
+func toDataExport(e DataExportModel) DataExport {
+	return DataExport{
+		ID: e.ID.Hex(),
+		Status: e.Status,
+		CreatedAt: e.CreatedAt,
+		CompletedAt: e.CompletedAt,
+	}
+}
+
+// startDataExport starts building an archive of all the user's data in the
+// background, replacing their previous export, and responds with the job to
+// poll for it.
+//
+// @Summary Start exporting all the user's data
+// @Tags user
+// @Produce json
+// @Success 202 {object} DataEnvelope{data=DataExport}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/data-export [get]
+func startDataExport(w http.ResponseWriter, r *http.Request) {
+	job := DataExportModel{
+		ID: bson.NewObjectId(),
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		Status: exportPending,
+		CreatedAt: time.Now(),
+	}
+
+	// The audit log of the todos holds the changes made to them by anyone
+	// they are shared with, looked up by todo.
+	var todos []TodoModel
+	if err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{})).Select(bson.M{"_id": 1}).All(&todos)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to start data export")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to start data export", ""))
+		return
+	}
+	todoIDs := make([]bson.ObjectId, 0, len(todos))
+	for _, t := range todos {
+		todoIDs = append(todoIDs, t.ID)
+	}
+
+	if err := timedOp(r.Context(), dataExportCollectionName + ".insert", func() error {
+		return dbFor(r).C(dataExportCollectionName).Insert(&job)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to start data export")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to start data export", ""))
+		return
+	}
+
+	sources := []exportSource{
+		{File: "user.json", Collection: userCollectionName, Filter: inTenant(r, bson.M{"_id": job.UserID}), Select: bson.M{"passwordHash": 0}},
+		{File: "todos.json", Collection: cfg.CollectionName, Filter: ownedBy(r, bson.M{})},
+		{File: "lists.json", Collection: listCollectionName, Filter: ownedBy(r, bson.M{})},
+		{File: "templates.json", Collection: templateCollectionName, Filter: ownedBy(r, bson.M{})},
+		{File: "webhooks.json", Collection: webhookCollectionName, Filter: ownedBy(r, bson.M{})},
+		{File: "preferences.json", Collection: preferencesCollectionName, Filter: ownedBy(r, bson.M{})},
+		{File: "comments.json", Collection: commentCollectionName, Filter: ownedBy(r, bson.M{})},
+		{File: "audit_log.json", Collection: auditCollectionName, Filter: bson.M{"todoID": bson.M{"$in": todoIDs}}},
+	}
+
+	go buildDataExport(job, sources, logFor(r))
+
+	w.Header().Set("Location", "/user/data-export/" + job.ID.Hex())
+
+	jsonErr := rnd.JSON(w, http.StatusAccepted, renderer.M{
+		"data": toDataExport(job),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// buildDataExport writes the archive of the job to GridFS and marks the job
+// ready, or failed. The user's earlier exports are removed first.
+func buildDataExport(job DataExportModel, sources []exportSource, logger *zerolog.Logger) {
+	s := sess.Copy()
+	defer s.Close()
+
+	jobs := s.DB(cfg.DBName).C(dataExportCollectionName)
+	files := s.DB(cfg.DBName).GridFS(dataExportCollectionName)
+
+	var previous []DataExportModel
+	if err := jobs.Find(bson.M{"userID": job.UserID, "_id": bson.M{"$ne": job.ID}}).Select(bson.M{"_id": 1}).All(&previous); err != nil {
+		logger.Warn().Err(err).Msg("failed to remove previous data exports")
+	}
+	for _, p := range previous {
+		if err := files.Remove(archiveName(p.ID)); err != nil {
+			logger.Warn().Err(err).Str("exportId", p.ID.Hex()).Msg("failed to remove previous data export")
+			continue
+		}
+		jobs.RemoveId(p.ID)
+	}
+
+	err := writeDataExport(s.DB(cfg.DBName), job, sources)
+
+	now := time.Now()
+	update := bson.M{"status": exportReady, "completedAt": now}
+	if err != nil {
+		logger.Error().Err(err).Str("exportId", job.ID.Hex()).Msg("failed to build data export")
+		update = bson.M{"status": exportFailed, "error": err.Error(), "completedAt": now}
+	}
+
+	if err := jobs.UpdateId(job.ID, bson.M{"$set": update}); err != nil {
+		logger.Error().Err(err).Str("exportId", job.ID.Hex()).Msg("failed to update data export")
+	}
+}
+
+func writeDataExport(database *store.Database, job DataExportModel, sources []exportSource) error {
+	files := database.GridFS(dataExportCollectionName)
+
+	file, err := files.Create(archiveName(job.ID))
+	if err != nil {
+		return err
+	}
+	file.SetContentType("application/zip")
+
+	zw := zip.NewWriter(file)
+
+	err = writeZipFile(zw, "README.txt", func(w io.Writer) error {
+		_, err := io.WriteString(w, dataExportReadme)
+		return err
+	})
+
+	for _, src := range sources {
+		if err != nil {
+			break
+		}
+
+		err = writeZipFile(zw, src.File, func(w io.Writer) error {
+			query := database.C(src.Collection).Find(src.Filter).Sort("_id")
+			if src.Select != nil {
+				query = query.Select(src.Select)
+			}
+
+			return writeJSONArray(w, query.Iter())
+		})
+	}
+
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		files.Remove(archiveName(job.ID))
+	}
+
+	return err
+}
+
+func writeZipFile(zw *zip.Writer, name string, write func(io.Writer) error) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	return write(w)
+}
+
+// writeJSONArray writes the documents of iter as a JSON array, one document
+// at a time.
+func writeJSONArray(w io.Writer, iter *store.Iter) error {
+	defer iter.Close()
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	var doc bson.M
+	for n := 0; iter.Next(&doc); n++ {
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		sep := "\n"
+		if n > 0 {
+			sep = ",\n"
+		}
+		if _, err := io.WriteString(w, sep + string(data)); err != nil {
+			return err
+		}
+		doc = bson.M{}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+func archiveName(id bson.ObjectId) string {
+	return id.Hex() + ".zip"
+}
+
+// getDataExport responds with the status of the export job identified by
+// {jobId} while it is being built, and with the archive once it is ready.
+//
+// @Summary Get a data export
+// @Tags user
+// @Produce json
+// @Param jobId path string true "The export job id"
+// @Success 200 {object} DataEnvelope{data=DataExport}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/data-export/{jobId} [get]
+func getDataExport(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "jobId"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The job id is invalid", ""))
+		return
+	}
+
+	var job DataExportModel
+
+	if err := timedOp(r.Context(), dataExportCollectionName + ".find", func() error {
+		return dbFor(r).C(dataExportCollectionName).Find(ownedBy(r, bson.M{"_id": bson.ObjectIdHex(id)})).One(&job)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Data export not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch data export")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch data export", ""))
+		return
+	}
+
+	if job.Status == exportFailed {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusInternalServerError, "The data export failed", job.Error))
+		return
+	}
+
+	if job.Status != exportReady {
+		jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+			"data": toDataExport(job),
+		})
+
+		utils.CheckErr(jsonErr)
+		return
+	}
+
+	file, err := dbFor(r).GridFS(dataExportCollectionName).Open(archiveName(job.ID))
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to open data export")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch data export", ""))
+		return
+	}
+	defer file.Close()
+
+	filename := "data-export-" + job.CreatedAt.Format("2006-01-02") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if _, err := io.Copy(w, file); err != nil {
+		logFor(r).Warn().Err(err).Msg("failed to send data export")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	deliveryCollectionName	string = "WebhookDelivery"
+	maxDeliveryAttempts		int = 5
+	deliveryRetryBase		time.Duration = 30 * time.Second
+	deliveryPollInterval	time.Duration = 10 * time.Second
+	// deliveryLease keeps other workers off a delivery being attempted.
+	deliveryLease			time.Duration = time.Minute
+)
+
+const (
+	deliveryPending		string = "pending"
+	deliveryDelivered	string = "delivered"
+	deliveryFailed		string = "failed"
+	// deliveryCancelled marks the deliveries of deleted accounts.
+	deliveryCancelled	string = "cancelled"
+)
+
+type(
+	// WebhookDeliveryModel tracks the delivery of one event to one webhook.
+	// Pending deliveries are attempted once nextRetryAt has passed, and are
+	// marked failed after maxDeliveryAttempts.
+	WebhookDeliveryModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		WebhookID		bson.ObjectId `bson:"webhookID"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Event			string `bson:"event"`
+		Payload			string `bson:"payload"`
+		Status			string `bson:"status"`
+		Attempts		int `bson:"attempts"`
+		NextRetryAt		time.Time `bson:"nextRetryAt"`
+		LastError		string `bson:"lastError,omitempty"`
+		CreatedAt		time.Time `bson:"createdAt"`
+		DeliveredAt		*time.Time `bson:"deliveredAt,omitempty"`
+	}
+
+	WebhookDelivery struct {
+		ID				string `json:"id"`
+		Event			string `json:"event"`
+		Payload			string `json:"payload"`
+		Status			string `json:"status"`
+		Attempts		int `json:"attempts"`
+		NextRetryAt		*time.Time `json:"nextRetryAt,omitempty"`
+		LastError		string `json:"lastError,omitempty"`
+		CreatedAt		time.Time `json:"createdAt"`
+		DeliveredAt		*time.Time `json:"deliveredAt,omitempty"`
+	}
+)
+
+// deliveryWake prompts the worker to look for due deliveries straight away.
+var deliveryWake = make(chan struct{}, 1)
+
+func toWebhookDelivery(d WebhookDeliveryModel) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID: d.ID.Hex(),
+		Event: d.Event,
+		Payload: d.Payload,
+		Status: d.Status,
+		Attempts: d.Attempts,
+		LastError: d.LastError,
+		CreatedAt: d.CreatedAt,
+		DeliveredAt: d.DeliveredAt,
+	}
+
+	if d.Status == deliveryPending {
+		delivery.NextRetryAt = &d.NextRetryAt
+	}
+
+	return delivery
+}
+
+// wakeDeliveryWorker asks the worker to run without waiting for its poll.
+func wakeDeliveryWorker() {
+	select {
+	case deliveryWake <- struct{}{}:
+	default:
+	}
+}
+
+// runDeliveryWorker attempts the due webhook deliveries until ctx is done.
+func runDeliveryWorker(ctx context.Context) {
+	ticker := time.NewTicker(deliveryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		attemptDueDeliveries()
+
+		select {
+		case <-ticker.C:
+		case <-deliveryWake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// attemptDueDeliveries claims and attempts the due deliveries one at a time.
+// Claiming pushes nextRetryAt back by deliveryLease, so several servers can
+// run workers without sending a delivery twice.
+func attemptDueDeliveries() {
+	s := sess.Copy()
+	defer s.Close()
+
+	deliveries := s.DB(cfg.DBName).C(deliveryCollectionName)
+
+	for {
+		var d WebhookDeliveryModel
+
+		now := time.Now()
+		_, err := deliveries.Find(bson.M{
+			"status": deliveryPending,
+			"nextRetryAt": bson.M{"$lte": now},
+		}).Sort("nextRetryAt").Apply(store.Change{
+			Update: bson.M{"$set": bson.M{"nextRetryAt": now.Add(deliveryLease)}},
+		}, &d)
+		if err == store.ErrNotFound {
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to fetch webhook deliveries")
+			return
+		}
+
+		update := attemptDelivery(s, d)
+		if err := deliveries.UpdateId(d.ID, update); err != nil {
+			log.Error().Err(err).Str("deliveryId", d.ID.Hex()).Msg("failed to record webhook delivery")
+		}
+	}
+}
+
+// attemptDelivery sends the delivery and returns the update recording the
+// outcome.
+func attemptDelivery(s *store.Session, d WebhookDeliveryModel) bson.M {
+	attempts := d.Attempts + 1
+
+	var hook WebhookModel
+	err := s.DB(cfg.DBName).C(webhookCollectionName).FindId(d.WebhookID).One(&hook)
+	if err == nil && !hook.Active {
+		err = errWebhookInactive
+	}
+	if err == nil {
+		err = deliverWebhook(hook, []byte(d.Payload))
+	}
+
+	if err == nil {
+		return bson.M{"$set": bson.M{
+			"status": deliveryDelivered,
+			"attempts": attempts,
+			"deliveredAt": time.Now(),
+		}, "$unset": bson.M{"lastError": ""}}
+	}
+
+	set := bson.M{"attempts": attempts, "lastError": err.Error()}
+
+	// Deliveries to deleted or disabled webhooks are given up straight away.
+	if attempts >= maxDeliveryAttempts || err == store.ErrNotFound || err == errWebhookInactive {
+		set["status"] = deliveryFailed
+		log.Warn().Err(err).Str("webhookId", d.WebhookID.Hex()).Int("attempts", attempts).Msg("giving up delivering webhook")
+	} else {
+		set["nextRetryAt"] = time.Now().Add(deliveryRetryBase << uint(attempts))
+		log.Warn().Err(err).Str("webhookId", d.WebhookID.Hex()).Int("attempts", attempts).Msg("failed to deliver webhook")
+	}
+
+	return bson.M{"$set": set}
+}
+
+// @Summary List the deliveries of a webhook
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "The webhook id"
+// @Param page query int false "The page, from 1"
+// @Param limit query int false "The page size, at most 100"
+// @Success 200 {object} PageEnvelope{data=[]WebhookDelivery}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /webhooks/{id}/deliveries [get]
+func fetchWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return
+	}
+
+	query := dbFor(r).C(deliveryCollectionName).Find(ownedBy(r, bson.M{"webhookID": hook.ID}))
+
+	var total int
+
+	if err := timedOp(r.Context(), deliveryCollectionName + ".count", func() (err error) {
+		total, err = query.Count()
+		return err
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch webhook deliveries")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch webhook deliveries", err.Error()))
+		return
+	}
+
+	var deliveries []WebhookDeliveryModel
+
+	if err := timedOp(r.Context(), deliveryCollectionName + ".find", func() error {
+		return query.Sort("-createdAt").Skip((page - 1) * limit).Limit(limit).All(&deliveries)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch webhook deliveries")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch webhook deliveries", err.Error()))
+		return
+	}
+
+	deliveryList := []WebhookDelivery{}
+	for _, d := range deliveries {
+		deliveryList = append(deliveryList, toWebhookDelivery(d))
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": deliveryList,
+		"total": total,
+		"page": page,
+		"limit": limit,
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// setDependencies replaces the todos blocking the todo identified by {id}.
+// Dependencies that would make a todo wait on itself are rejected.
+//
+// @Summary Set the todos blocking a todo
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body object{blockedBy=[]string} true "The ids of the blocking todos"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/dependencies [put]
+func setDependencies(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		BlockedBy []string `json:"blockedBy"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	blockedBy := []bson.ObjectId{}
+	seen := map[bson.ObjectId]bool{}
+
+	for _, id := range body.BlockedBy {
+		id = strings.TrimSpace(id)
+		if !bson.IsObjectIdHex(id) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id " + id + " is invalid", ""))
+			return
+		}
+		if oid := bson.ObjectIdHex(id); !seen[oid] {
+			seen[oid] = true
+			blockedBy = append(blockedBy, oid)
+		}
+	}
+
+	if seen[todo.ID] {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "A todo cannot be blocked by itself", ""))
+		return
+	}
+
+	if len(blockedBy) > 0 {
+		var n int
+
+		if err := timedOp(r.Context(), cfg.CollectionName + ".count", func() error {
+			filter, err := visibleTo(r, bson.M{"_id": bson.M{"$in": blockedBy}})
+			if err != nil {
+				return err
+			}
+			n, err = dbFor(r).C(cfg.CollectionName).Find(filter).Count()
+			return err
+		}); err != nil {
+			logFor(r).Error().Err(err).Msg("failed to fetch todos")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update dependencies", ""))
+			return
+		}
+		if n != len(blockedBy) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "A blocking todo was not found", ""))
+			return
+		}
+
+		cyclic, err := createsCycle(r, todo.ID, blockedBy)
+		if err != nil {
+			logFor(r).Error().Err(err).Msg("failed to fetch todo dependencies")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update dependencies", ""))
+			return
+		}
+		if cyclic {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The dependencies would create a cycle", ""))
+			return
+		}
+	}
+
+	applyTodoUpdate(w, r, "dependencies_updated", bson.M{"_id": todo.ID}, bson.M{
+		"$set": bson.M{"blockedBy": blockedBy},
+	})
+}
+
+// createsCycle reports whether blocking id by blockedBy would make id wait,
+// directly or through other todos, on itself: that is whether id can be
+// reached from blockedBy by following the existing dependencies of the todos
+// the user can see.
+func createsCycle(r *http.Request, id bson.ObjectId, blockedBy []bson.ObjectId) (bool, error) {
+	var todos []TodoModel
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		filter, err := visibleTo(r, bson.M{"blockedBy.0": bson.M{"$exists": true}})
+		if err != nil {
+			return err
+		}
+		return dbFor(r).C(cfg.CollectionName).Find(filter).Select(bson.M{"blockedBy": 1}).All(&todos)
+	}); err != nil {
+		return false, err
+	}
+
+	edges := map[bson.ObjectId][]bson.ObjectId{}
+	for _, t := range todos {
+		edges[t.ID] = t.BlockedBy
+	}
+
+	visited := map[bson.ObjectId]bool{}
+	stack := append([]bson.ObjectId{}, blockedBy...)
+
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if next == id {
+			return true, nil
+		}
+		if visited[next] {
+			continue
+		}
+		visited[next] = true
+
+		stack = append(stack, edges[next]...)
+	}
+
+	return false, nil
+}
+
+// setBlocked marks the todos in list, converted from todos, that wait on a
+// todo which is neither completed nor archived.
+func setBlocked(r *http.Request, todos []TodoModel, list []Todo) error {
+	var blockers []bson.ObjectId
+	for _, t := range todos {
+		blockers = append(blockers, t.BlockedBy...)
+	}
+	if len(blockers) == 0 {
+		return nil
+	}
+
+	var open []TodoModel
+	if err := dbFor(r).C(cfg.CollectionName).Find(bson.M{
+		"_id": bson.M{"$in": blockers},
+		"completed": false,
+		"archived": bson.M{"$ne": true},
+	}).Select(bson.M{"_id": 1}).All(&open); err != nil {
+		return err
+	}
+
+	isOpen := map[bson.ObjectId]bool{}
+	for _, t := range open {
+		isOpen[t.ID] = true
+	}
+
+	for i, t := range todos {
+		for _, id := range t.BlockedBy {
+			if isOpen[id] {
+				list[i].IsBlocked = true
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchBlockers lists the todos the todo identified by {id} waits on.
+//
+// @Summary List the todos blocking a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=[]Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/blockers [get]
+func fetchBlockers(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, false)
+	if !ok {
+		return
+	}
+
+	respondWithTodos(w, r, bson.M{"_id": bson.M{"$in": todo.BlockedBy}})
+}
+
+// fetchUnblocks lists the todos waiting on the todo identified by {id}.
+//
+// @Summary List the todos a todo blocks
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=[]Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/unblocks [get]
+func fetchUnblocks(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, false)
+	if !ok {
+		return
+	}
+
+	respondWithTodos(w, r, bson.M{"blockedBy": todo.ID, "archived": bson.M{"$ne": true}})
+}
+
+// respondWithTodos responds with all the todos the user can see matching
+// filter.
+func respondWithTodos(w http.ResponseWriter, r *http.Request, filter bson.M) {
+	var todos []TodoModel
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		filter, err := visibleTo(r, filter)
+		if err != nil {
+			return err
+		}
+		return dbFor(r).C(cfg.CollectionName).Find(filter).Sort("position", "_id").All(&todos)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todos", err.Error()))
+		return
+	}
+
+	list := listedTodos(todos)
+	if err := decorateTodos(r, todos, list); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todos", err.Error()))
+		return
+	}
+
+	if list == nil {
+		list = []Todo{}
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": projectTodos(r, list),
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// decodeTodoBody decodes the todo in the request body into v, first reading
+// a dueDate written as a phrase, such as next friday, with
+// utils.ParseDueDate. It returns the phrase, empty when the due date was
+// missing or already a time. When the body or the phrase is invalid, the
+// error response is written and ok is false.
+func decodeTodoBody(w http.ResponseWriter, r *http.Request, v interface{}) (parsedFrom string, ok bool) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "Failed to read the body", ""))
+		return "", false
+	}
+
+	data, parsedFrom, err = resolveDueDate(data)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The due date is invalid", err.Error()).
+			With("parsed_from", parsedFrom))
+		return "", false
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return "", false
+	}
+
+	return parsedFrom, true
+}
+
+// resolveDueDate replaces the dueDate phrase of the JSON object in data with
+// the time it names, and returns the phrase. Bodies that are not objects are
+// left for the decoder to report.
+func resolveDueDate(data []byte) ([]byte, string, error) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return data, "", nil
+	}
+
+	var phrase string
+	if json.Unmarshal(fields["dueDate"], &phrase) != nil {
+		return data, "", nil
+	}
+	if _, err := time.Parse(time.RFC3339, phrase); err == nil {
+		return data, "", nil
+	}
+
+	dueDate, err := utils.ParseDueDate(phrase)
+	if err != nil {
+		return nil, phrase, err
+	}
+
+	fields["dueDate"], err = json.Marshal(dueDate)
+	if err != nil {
+		return nil, phrase, err
+	}
+
+	data, err = json.Marshal(fields)
+	return data, phrase, err
+}
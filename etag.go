@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// etagMiddleware tags successful GET responses with an ETag computed from
+// their body, and answers conditional requests whose ETag or Last-Modified
+// time still matches with 304 Not Modified. Handlers opt in to Last-Modified
+// by setting the header themselves; lists do not, as a todo dropping out of a
+// list would not make it any newer.
+func etagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &etagRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.status != http.StatusOK {
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(rec.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("ETag", etag)
+
+		if notModified(r, etag, w.Header().Get("Last-Modified")) {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+	})
+}
+
+// notModified reports whether the client's cached copy is still current. As
+// in RFC 7232, If-Modified-Since is ignored when If-None-Match is present.
+func notModified(r *http.Request, etag, lastModified string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if lastModified == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+// setLastModified sets the Last-Modified header, which HTTP dates only give
+// to the second.
+func setLastModified(w http.ResponseWriter, t time.Time) {
+	if !t.IsZero() {
+		w.Header().Set("Last-Modified", t.UTC().Truncate(time.Second).Format(http.TimeFormat))
+	}
+}
+
+// etagRecorder holds back the response so its ETag can be computed first.
+type etagRecorder struct {
+	http.ResponseWriter
+	status		int
+	body		bytes.Buffer
+	wroteHeader	bool
+}
+
+func (e *etagRecorder) WriteHeader(code int) {
+	if !e.wroteHeader {
+		e.status = code
+		e.wroteHeader = true
+	}
+}
+
+func (e *etagRecorder) Write(p []byte) (int, error) {
+	e.wroteHeader = true
+	return e.body.Write(p)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/broadcast"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	wsWriteTimeout	time.Duration = 10 * time.Second
+	wsPongTimeout	time.Duration = 60 * time.Second
+	wsPingInterval	time.Duration = wsPongTimeout * 9 / 10
+	sseKeepAlive	time.Duration = 30 * time.Second
+)
+
+var hub = broadcast.NewHub()
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize: 1024,
+	WriteBufferSize: 1024,
+}
+
+// publishTodoEvent notifies the connected clients and webhooks of the todo's
+// owner that it changed, whoever changed it.
+func publishTodoEvent(r *http.Request, eventType string, todo TodoModel, payload interface{}) {
+	broadcastTodoEvent(logFor(r), eventType, todo, payload)
+}
+
+// broadcastTodoEvent is publishTodoEvent for changes made outside a request,
+// such as by the recurrence scheduler.
+func broadcastTodoEvent(logger *zerolog.Logger, eventType string, todo TodoModel, payload interface{}) {
+	event := broadcast.Event{
+		Type: eventType,
+		TodoID: todo.ID.Hex(),
+		Payload: payload,
+	}
+
+	notifyWebhooks(logger, todo.UserID, event)
+
+	// A change stream, when available, publishes every write to the hub
+	// itself.
+	if !changeStreamEnabled {
+		hub.Broadcast(todo.UserID.Hex(), event)
+	}
+}
+
+// todoWebSocket upgrades the request to a WebSocket that receives an event
+// each time one of the user's todos changes.
+//
+// @Summary Receive todo events over a WebSocket
+// @Tags events
+// @Success 101 "Switching Protocols"
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/ws [get]
+func todoWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response.
+		logFor(r).Warn().Err(err).Msg("failed to upgrade to a WebSocket")
+		return
+	}
+	defer conn.Close()
+
+	sub := hub.Subscribe(currentUserID(r).Hex())
+	defer hub.Unsubscribe(sub)
+
+	// Clients only send control frames; reading them notices when the client
+	// goes away and keeps the pong deadline moving.
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event := <-sub.Events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-shuttingDown:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+			return
+		}
+	}
+}
+
+// streamTodoEvents streams the same events as todoWebSocket as Server-Sent
+// Events, for clients using EventSource.
+//
+// @Summary Receive todo events as Server-Sent Events
+// @Tags events
+// @Produce text/event-stream
+// @Success 200 {string} string "The event stream"
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/events [get]
+func streamTodoEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusInternalServerError, "Streaming is not supported", ""))
+		return
+	}
+
+	sub := hub.Subscribe(currentUserID(r).Hex())
+	defer hub.Unsubscribe(sub)
+
+	// The stream outlives the server's write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event := <-sub.Events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				logFor(r).Error().Err(err).Msg("failed to encode event")
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		}
+	}
+}
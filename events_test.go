@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/broadcast"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+// sseServer serves streamTodoEvents to userID. done is closed once the
+// handler returns.
+func sseServer(userID bson.ObjectId) (srv *httptest.Server, done chan struct{}) {
+	done = make(chan struct{})
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		streamTodoEvents(w, withUserID(r, userID))
+	}))
+
+	return srv, done
+}
+
+// readEvent reads the next event, skipping keep-alive comments.
+func readEvent(t *testing.T, lines *bufio.Scanner) (eventType string, data string) {
+	t.Helper()
+
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && eventType != "":
+			return eventType, data
+		}
+	}
+
+	t.Fatalf("the stream ended before an event: %v", lines.Err())
+	return "", ""
+}
+
+func TestStreamTodoEventsDeliversUserEvents(t *testing.T) {
+	userID, otherID := bson.NewObjectId(), bson.NewObjectId()
+	srv, done := sseServer(userID)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// The handler subscribes before sending the headers, so the events
+	// broadcast from now on reach it. Other users' events must not.
+	hub.Broadcast(otherID.Hex(), broadcast.Event{Type: "deleted", TodoID: "other"})
+	hub.Broadcast(userID.Hex(), broadcast.Event{Type: "created", TodoID: "1", Payload: map[string]string{"title": "Buy milk"}})
+	hub.Broadcast(userID.Hex(), broadcast.Event{Type: "updated", TodoID: "1"})
+
+	lines := bufio.NewScanner(resp.Body)
+
+	tests := []struct {
+		eventType	string
+		todoID		string
+	}{
+		{"created", "1"},
+		{"updated", "1"},
+	}
+
+	for _, tt := range tests {
+		eventType, data := readEvent(t, lines)
+		if eventType != tt.eventType {
+			t.Errorf("event = %q, want %q", eventType, tt.eventType)
+		}
+
+		var event broadcast.Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("data %q is not an event: %v", data, err)
+		}
+		if event.Type != tt.eventType || event.TodoID != tt.todoID {
+			t.Errorf("data = %+v, want a %s event for todo %s", event, tt.eventType, tt.todoID)
+		}
+	}
+
+	// Disconnecting ends the handler, which unsubscribes as it returns.
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler did not return after the client disconnected")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+var csvHeader = []string{"ID", "Title", "Completed", "Priority", "DueDate", "Tags", "CreatedAt"}
+
+// exportTodosCSV streams the user's todos matching the list filters as a CSV
+// file, one todo at a time so memory use does not grow with the list.
+//
+// @Summary Export todos as CSV
+// @Tags todos
+// @Produce text/csv
+// @Success 200 {file} file
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/export.csv [get]
+func exportTodosCSV(w http.ResponseWriter, r *http.Request) {
+	filter, err := todoFilter(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return
+	}
+
+	iter := dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, filter)).Sort("createdAt").Iter()
+	defer iter.Close()
+
+	filename := "todos-" + time.Now().Format("2006-01-02") + ".csv"
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+
+	var t TodoModel
+	for iter.Next(&t) {
+		dueDate := ""
+		if t.DueDate != nil {
+			dueDate = t.DueDate.Format(time.RFC3339)
+		}
+
+		cw.Write([]string{
+			t.ID.Hex(),
+			csvSafe(t.Title),
+			strconv.FormatBool(t.Completed),
+			t.Priority,
+			dueDate,
+			csvSafe(strings.Join(t.Tags, ",")),
+			t.CreatedAt.Format(time.RFC3339),
+		})
+		t = TodoModel{}
+	}
+
+	cw.Flush()
+
+	// The status has been sent with the first rows, so failures can only be
+	// logged.
+	if err := iter.Err(); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to export todos")
+	}
+	if err := cw.Error(); err != nil {
+		logFor(r).Warn().Err(err).Msg("failed to write the todo export")
+	}
+}
+
+// csvSafe keeps spreadsheets from evaluating user text as a formula.
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsAny(v[:1], "=+-@") {
+		return "'" + v
+	}
+	return v
+}
+
+const icsTimeFormat = "20060102T150405Z"
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// exportTodosICS exports the user's todos that have a due date as VTODO
+// components of an iCalendar (RFC 5545) file.
+//
+// @Summary Export todos with a due date as iCalendar
+// @Tags todos
+// @Produce text/calendar
+// @Success 200 {file} file
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/export.ics [get]
+func exportTodosICS(w http.ResponseWriter, r *http.Request) {
+	iter := dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{
+		"archived": bson.M{"$ne": true},
+		"dueDate": bson.M{"$ne": nil},
+	})).Sort("dueDate").Iter()
+	defer iter.Close()
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="todos.ics"`)
+
+	bw := bufio.NewWriter(w)
+	now := time.Now().UTC().Format(icsTimeFormat)
+
+	writeICSLine(bw, "BEGIN:VCALENDAR")
+	writeICSLine(bw, "VERSION:2.0")
+	writeICSLine(bw, "PRODID:-//go-chi-mongodb-simple-todo//Todos//EN")
+
+	var t TodoModel
+	for iter.Next(&t) {
+		writeICSLine(bw, "BEGIN:VTODO")
+		writeICSLine(bw, "UID:"+t.ID.Hex()+"@go-chi-mongodb-simple-todo")
+		writeICSLine(bw, "DTSTAMP:"+now)
+		writeICSLine(bw, "SUMMARY:"+icsEscaper.Replace(t.Title))
+		if t.Description != "" {
+			writeICSLine(bw, "DESCRIPTION:"+icsEscaper.Replace(t.Description))
+		}
+		writeICSLine(bw, "DTSTART:"+t.CreatedAt.UTC().Format(icsTimeFormat))
+		writeICSLine(bw, "DUE:"+t.DueDate.UTC().Format(icsTimeFormat))
+		if t.Completed {
+			writeICSLine(bw, "STATUS:COMPLETED")
+			if t.CompletedAt != nil {
+				writeICSLine(bw, "COMPLETED:"+t.CompletedAt.UTC().Format(icsTimeFormat))
+			}
+		} else {
+			writeICSLine(bw, "STATUS:NEEDS-ACTION")
+		}
+		writeICSLine(bw, "END:VTODO")
+		t = TodoModel{}
+	}
+
+	writeICSLine(bw, "END:VCALENDAR")
+
+	if err := iter.Err(); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to export todos")
+	}
+	if err := bw.Flush(); err != nil {
+		logFor(r).Warn().Err(err).Msg("failed to write the todo export")
+	}
+}
+
+// writeICSLine writes a content line, folded into lines of at most 75 octets
+// without splitting UTF-8 characters.
+func writeICSLine(w *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts towards them.
+		limit = 74
+	}
+
+	w.WriteString(line + "\r\n")
+}
@@ -0,0 +1,1261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mgobson "gopkg.in/mgo.v2/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/config"
+)
+
+const (
+	opReply	int32 = 1
+	opQuery	int32 = 2004
+	opMsg	int32 = 2013
+)
+
+// fakeMongo is an in-memory MongoDB server for the handler tests. It speaks
+// enough of the wire protocol for the commands the handlers send, and keeps
+// the documents of each collection in insertion order.
+type fakeMongo struct {
+	listener	net.Listener
+	mu			sync.Mutex
+	collections	map[string][]mgobson.M
+}
+
+var (
+	fakeMongoOnce	sync.Once
+	testMongo		*fakeMongo
+)
+
+// useFakeMongo connects the server's session to an empty fake MongoDB, started
+// on first use and shared by the tests.
+func useFakeMongo(t *testing.T) *fakeMongo {
+	t.Helper()
+
+	fakeMongoOnce.Do(func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+
+		testMongo = &fakeMongo{listener: l, collections: map[string][]mgobson.M{}}
+		go testMongo.serve()
+
+		cfg = config.Defaults()
+		cfg.HostName = l.Addr().String()
+		cfg.DBName = "test_todo"
+		cfg.MongoMaxRetries = 0
+		cfg.MongoTimeout = 2 * time.Second
+		cfg.MongoSlowQuery = time.Minute
+		connect()
+	})
+
+	testMongo.mu.Lock()
+	testMongo.collections = map[string][]mgobson.M{}
+	testMongo.mu.Unlock()
+
+	return testMongo
+}
+
+// fakeDoc converts v, marshalled like the server's store would send it, to a
+// document of the fake.
+func fakeDoc(t *testing.T, v interface{}) mgobson.M {
+	t.Helper()
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal %T: %v", v, err)
+	}
+
+	var m mgobson.M
+	if err := mgobson.Unmarshal(data, &m); err != nil {
+		t.Fatalf("unmarshal %T: %v", v, err)
+	}
+	return m
+}
+
+// insert stores doc, marshalled like the server would send it, in the
+// collection.
+func (f *fakeMongo) insert(t *testing.T, collection string, doc interface{}) {
+	t.Helper()
+
+	m := fakeDoc(t, doc)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.collections[collection] = append(f.collections[collection], m)
+}
+
+// set sets the fields of the collection's document with the id.
+func (f *fakeMongo) set(t *testing.T, collection string, id interface{}, fields bson.M) {
+	t.Helper()
+
+	m := fakeDoc(t, bson.M{"_id": id, "fields": fields})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, doc := range f.collections[collection] {
+		if equalValues(doc["_id"], m["_id"]) {
+			for k, v := range m["fields"].(mgobson.M) {
+				doc[k] = v
+			}
+			return
+		}
+	}
+
+	t.Fatalf("no document %v in %s", id, collection)
+}
+
+// find decodes the first document of the collection matching filter into out,
+// and reports whether there was one.
+func (f *fakeMongo) find(t *testing.T, collection string, filter bson.M, out interface{}) bool {
+	t.Helper()
+
+	m := fakeDoc(t, filter)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, doc := range f.collections[collection] {
+		if matches(doc, m) {
+			data, err := mgobson.Marshal(doc)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if err := bson.Unmarshal(data, out); err != nil {
+				t.Fatalf("unmarshal into %T: %v", out, err)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *fakeMongo) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.serveConn(conn)
+	}
+}
+
+func (f *fakeMongo) serveConn(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(rd, header[:]); err != nil {
+			return
+		}
+
+		length := int32(binary.LittleEndian.Uint32(header[0:]))
+		requestID := int32(binary.LittleEndian.Uint32(header[4:]))
+		opCode := int32(binary.LittleEndian.Uint32(header[12:]))
+
+		body := make([]byte, length - 16)
+		if _, err := io.ReadFull(rd, body); err != nil {
+			return
+		}
+
+		reply, ok := f.handle(opCode, body)
+		if !ok {
+			return
+		}
+		if reply == nil {
+			continue
+		}
+
+		out := make([]byte, 16, 16 + len(reply))
+		binary.LittleEndian.PutUint32(out[4:], uint32(requestID + 1))
+		binary.LittleEndian.PutUint32(out[8:], uint32(requestID))
+		out = append(out, reply...)
+		binary.LittleEndian.PutUint32(out[0:], uint32(len(out)))
+
+		// The reply's opcode follows the request's.
+		replyOp := opReply
+		if opCode != opQuery {
+			replyOp = opCode
+		}
+		binary.LittleEndian.PutUint32(out[12:], uint32(replyOp))
+
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers the message, returning a nil reply for messages that need
+// none and false for those it cannot read.
+func (f *fakeMongo) handle(opCode int32, body []byte) ([]byte, bool) {
+	switch opCode {
+	case opQuery:
+		// flags, the full collection name, then the number to skip and to
+		// return before the command.
+		name := body[4:]
+		end := 0
+		for name[end] != 0 {
+			end++
+		}
+		collection := string(name[:end])
+		doc := name[end + 1 + 8:]
+
+		var raw mgobson.RawD
+		if err := mgobson.Unmarshal(doc, &raw); err != nil {
+			return nil, false
+		}
+		if len(raw) > 0 && raw[0].Name == "$query" {
+			if err := raw[0].Value.Unmarshal(&raw); err != nil {
+				return nil, false
+			}
+		}
+
+		db := strings.SplitN(collection, ".", 2)[0]
+		data := f.reply(db, raw)
+
+		reply := make([]byte, 20, 20 + len(data))
+		binary.LittleEndian.PutUint32(reply[16:], 1)
+		return append(reply, data...), true
+	case opMsg:
+		// flags, then a section of kind 0 holding the command and sections
+		// of kind 1 holding sequences of documents, which are arrays of the
+		// command.
+		var raw mgobson.RawD
+		var sequences mgobson.D
+
+		for rest := body[4:]; len(rest) > 5; {
+			kind := rest[0]
+			rest = rest[1:]
+			size := int(binary.LittleEndian.Uint32(rest))
+
+			switch kind {
+			case 0:
+				if err := mgobson.Unmarshal(rest[:size], &raw); err != nil {
+					return nil, false
+				}
+			case 1:
+				seq := rest[4:size]
+				end := bytes.IndexByte(seq, 0)
+				name := string(seq[:end])
+
+				var docs []mgobson.Raw
+				for seq = seq[end + 1:]; len(seq) > 0; {
+					n := int(binary.LittleEndian.Uint32(seq))
+					docs = append(docs, mgobson.Raw{Kind: 0x03, Data: seq[:n]})
+					seq = seq[n:]
+				}
+				sequences = append(sequences, mgobson.DocElem{Name: name, Value: docs})
+			default:
+				return nil, false
+			}
+
+			rest = rest[size:]
+		}
+
+		if len(sequences) > 0 {
+			data, err := mgobson.Marshal(sequences)
+			if err != nil {
+				return nil, false
+			}
+			var arrays mgobson.RawD
+			if err := mgobson.Unmarshal(data, &arrays); err != nil {
+				return nil, false
+			}
+			raw = append(raw, arrays...)
+		}
+
+		data := f.reply(newFakeCommand(raw).str("$db"), raw)
+
+		return append([]byte{0, 0, 0, 0, 0}, data...), true
+	}
+
+	return nil, false
+}
+
+// reply runs the command on the database and marshals its result.
+func (f *fakeMongo) reply(db string, raw mgobson.RawD) []byte {
+	data, err := mgobson.Marshal(f.command(db, newFakeCommand(raw)))
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// fakeCommand is a command document, with its fields left undecoded.
+type fakeCommand struct {
+	name	string
+	fields	map[string]mgobson.Raw
+}
+
+func newFakeCommand(raw mgobson.RawD) fakeCommand {
+	cmd := fakeCommand{fields: map[string]mgobson.Raw{}}
+	for i, e := range raw {
+		if i == 0 {
+			cmd.name = e.Name
+		}
+		cmd.fields[e.Name] = e.Value
+	}
+
+	return cmd
+}
+
+// get decodes the field into out, and reports whether the command has it.
+func (c fakeCommand) get(key string, out interface{}) bool {
+	raw, ok := c.fields[key]
+	if !ok {
+		return false
+	}
+
+	if err := raw.Unmarshal(out); err != nil {
+		panic(fmt.Sprintf("fake mongo: %s.%s: %v", c.name, key, err))
+	}
+	return true
+}
+
+func (c fakeCommand) str(key string) string {
+	var s string
+	c.get(key, &s)
+	return s
+}
+
+func (c fakeCommand) num(key string) int {
+	var v interface{}
+	c.get(key, &v)
+	n, _ := number(v)
+	return int(n)
+}
+
+func (c fakeCommand) flag(key string) bool {
+	var v interface{}
+	c.get(key, &v)
+	b, _ := v.(bool)
+	return b
+}
+
+func (c fakeCommand) doc(key string) mgobson.M {
+	m := mgobson.M{}
+	c.get(key, &m)
+	return m
+}
+
+func okReply(fields ...mgobson.DocElem) mgobson.D {
+	return append(mgobson.D(fields), mgobson.DocElem{Name: "ok", Value: 1.0})
+}
+
+func commandError(code int, msg string) mgobson.D {
+	return mgobson.D{{Name: "ok", Value: 0.0}, {Name: "errmsg", Value: msg}, {Name: "code", Value: int32(code)}}
+}
+
+func (f *fakeMongo) command(db string, cmd fakeCommand) mgobson.D {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToLower(cmd.name) {
+	case "ismaster", "hello":
+		return okReply(
+			mgobson.DocElem{Name: "ismaster", Value: true},
+			mgobson.DocElem{Name: "isWritablePrimary", Value: true},
+			mgobson.DocElem{Name: "helloOk", Value: true},
+			mgobson.DocElem{Name: "maxWireVersion", Value: int32(8)},
+			mgobson.DocElem{Name: "minWireVersion", Value: int32(0)},
+			mgobson.DocElem{Name: "maxBsonObjectSize", Value: int32(16 * 1024 * 1024)},
+			mgobson.DocElem{Name: "maxMessageSizeBytes", Value: int32(48000000)},
+			mgobson.DocElem{Name: "maxWriteBatchSize", Value: int32(100000)},
+			mgobson.DocElem{Name: "localTime", Value: time.Now()},
+		)
+	case "ping", "endsessions", "killcursors", "createindexes", "dropindexes", "committransaction", "aborttransaction":
+		return okReply()
+	case "getnonce":
+		return okReply(mgobson.DocElem{Name: "nonce", Value: "2375531c32080ae8"})
+	case "buildinfo":
+		return okReply(mgobson.DocElem{Name: "version", Value: "4.2.0"})
+	case "listindexes":
+		return cursorReply(db, cmd.str(cmd.name), nil)
+	case "find":
+		return f.findCommand(db, cmd)
+	case "getmore":
+		return okReply(mgobson.DocElem{Name: "cursor", Value: mgobson.D{
+			{Name: "nextBatch", Value: []interface{}{}},
+			{Name: "id", Value: int64(0)},
+			{Name: "ns", Value: db + "." + cmd.str("collection")},
+		}})
+	case "count":
+		n := len(f.matching(cmd.str("count"), cmd.doc("query")))
+		if skip := cmd.num("skip"); skip > 0 {
+			n = max(n - skip, 0)
+		}
+		if limit := cmd.num("limit"); limit > 0 {
+			n = min(n, limit)
+		}
+		return okReply(mgobson.DocElem{Name: "n", Value: int32(n)})
+	case "insert":
+		return f.insertDocuments(cmd)
+	case "update":
+		return f.update(cmd)
+	case "delete":
+		return f.delete(cmd)
+	case "findandmodify":
+		return f.findAndModify(cmd)
+	case "aggregate":
+		return f.aggregate(db, cmd)
+	}
+
+	return commandError(59, "no such command: " + cmd.name)
+}
+
+func cursorReply(db, collection string, docs []mgobson.M) mgobson.D {
+	batch := make([]interface{}, len(docs))
+	for i := range docs {
+		batch[i] = docs[i]
+	}
+
+	return okReply(mgobson.DocElem{Name: "cursor", Value: mgobson.D{
+		{Name: "firstBatch", Value: batch},
+		{Name: "id", Value: int64(0)},
+		{Name: "ns", Value: db + "." + collection},
+	}})
+}
+
+// matching returns the indexes of the collection's documents matching filter.
+func (f *fakeMongo) matching(collection string, filter mgobson.M) []int {
+	var found []int
+	for i, doc := range f.collections[collection] {
+		if matches(doc, filter) {
+			found = append(found, i)
+		}
+	}
+
+	return found
+}
+
+// hasID reports whether the collection holds a document with the id.
+func (f *fakeMongo) hasID(collection string, id interface{}) bool {
+	for _, doc := range f.collections[collection] {
+		if reflect.DeepEqual(doc["_id"], id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeMongo) findCommand(db string, cmd fakeCommand) mgobson.D {
+	collection := cmd.str("find")
+
+	var docs []mgobson.M
+	for _, i := range f.matching(collection, cmd.doc("filter")) {
+		docs = append(docs, copyDoc(f.collections[collection][i]))
+	}
+
+	var order mgobson.D
+	cmd.get("sort", &order)
+	sortDocs(docs, order)
+
+	if skip := cmd.num("skip"); skip > 0 {
+		docs = docs[min(skip, len(docs)):]
+	}
+	limit := cmd.num("limit")
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < len(docs) {
+		docs = docs[:limit]
+	}
+
+	if projection := cmd.doc("projection"); len(projection) > 0 {
+		for i := range docs {
+			docs[i] = project(docs[i], projection)
+		}
+	}
+
+	return cursorReply(db, collection, docs)
+}
+
+// documents decodes the array field of the command.
+func (c fakeCommand) documents(key string) []mgobson.M {
+	var docs []mgobson.M
+	c.get(key, &docs)
+	return docs
+}
+
+func (f *fakeMongo) insertDocuments(cmd fakeCommand) mgobson.D {
+	collection := cmd.str("insert")
+	docs := cmd.documents("documents")
+
+	for _, doc := range docs {
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = mgobson.NewObjectId()
+		}
+		f.collections[collection] = append(f.collections[collection], doc)
+	}
+
+	return okReply(mgobson.DocElem{Name: "n", Value: int32(len(docs))})
+}
+
+func (f *fakeMongo) update(cmd fakeCommand) mgobson.D {
+	collection := cmd.str("update")
+
+	matched, modified := 0, 0
+	var upserted []interface{}
+
+	for i, u := range cmd.documents("updates") {
+		filter, _ := u["q"].(mgobson.M)
+		update, _ := u["u"].(mgobson.M)
+		multi, _ := u["multi"].(bool)
+		upsert, _ := u["upsert"].(bool)
+
+		found := f.matching(collection, filter)
+		if !multi && len(found) > 1 {
+			found = found[:1]
+		}
+
+		for _, j := range found {
+			doc := f.collections[collection][j]
+			before := copyDoc(doc)
+			f.collections[collection][j] = applyUpdate(doc, filter, update, false)
+			matched++
+			if !reflect.DeepEqual(before, f.collections[collection][j]) {
+				modified++
+			}
+		}
+
+		if len(found) == 0 && upsert {
+			doc := applyUpdate(upsertBase(filter), filter, update, true)
+			f.collections[collection] = append(f.collections[collection], doc)
+			upserted = append(upserted, mgobson.D{{Name: "index", Value: int32(i)}, {Name: "_id", Value: doc["_id"]}})
+			matched++
+		}
+	}
+
+	fields := []mgobson.DocElem{
+		{Name: "n", Value: int32(matched)},
+		{Name: "nModified", Value: int32(modified)},
+	}
+	if len(upserted) > 0 {
+		fields = append(fields, mgobson.DocElem{Name: "upserted", Value: upserted})
+	}
+
+	return okReply(fields...)
+}
+
+func (f *fakeMongo) delete(cmd fakeCommand) mgobson.D {
+	collection := cmd.str("delete")
+	removed := 0
+
+	for _, d := range cmd.documents("deletes") {
+		filter, _ := d["q"].(mgobson.M)
+		limit, _ := number(d["limit"])
+
+		var kept []mgobson.M
+		for _, doc := range f.collections[collection] {
+			if matches(doc, filter) && (limit == 0 || removed < int(limit)) {
+				removed++
+				continue
+			}
+			kept = append(kept, doc)
+		}
+		f.collections[collection] = kept
+	}
+
+	return okReply(mgobson.DocElem{Name: "n", Value: int32(removed)})
+}
+
+func (f *fakeMongo) findAndModify(cmd fakeCommand) mgobson.D {
+	collection := cmd.str(cmd.name)
+	filter := cmd.doc("query")
+	update := cmd.doc("update")
+
+	var docs []mgobson.M
+	for _, i := range f.matching(collection, filter) {
+		docs = append(docs, f.collections[collection][i])
+	}
+	var order mgobson.D
+	cmd.get("sort", &order)
+	sortDocs(docs, order)
+
+	lastError := mgobson.D{{Name: "n", Value: int32(0)}, {Name: "updatedExisting", Value: false}}
+
+	if len(docs) == 0 {
+		if !cmd.flag("upsert") {
+			return okReply(mgobson.DocElem{Name: "value", Value: nil}, mgobson.DocElem{Name: "lastErrorObject", Value: lastError})
+		}
+
+		doc := applyUpdate(upsertBase(filter), filter, update, true)
+		if f.hasID(collection, doc["_id"]) {
+			return commandError(11000, "E11000 duplicate key error collection: " + collection + " index: _id_")
+		}
+		f.collections[collection] = append(f.collections[collection], doc)
+		lastError = mgobson.D{{Name: "n", Value: int32(1)}, {Name: "updatedExisting", Value: false}, {Name: "upserted", Value: doc["_id"]}}
+
+		var value interface{}
+		if cmd.flag("new") {
+			value = copyDoc(doc)
+		}
+		return okReply(mgobson.DocElem{Name: "value", Value: value}, mgobson.DocElem{Name: "lastErrorObject", Value: lastError})
+	}
+
+	target := docs[0]
+	before := copyDoc(target)
+	lastError = mgobson.D{{Name: "n", Value: int32(1)}, {Name: "updatedExisting", Value: !cmd.flag("remove")}}
+
+	for i, doc := range f.collections[collection] {
+		if reflect.ValueOf(doc).Pointer() != reflect.ValueOf(target).Pointer() {
+			continue
+		}
+
+		if cmd.flag("remove") {
+			f.collections[collection] = append(f.collections[collection][:i], f.collections[collection][i + 1:]...)
+			return okReply(mgobson.DocElem{Name: "value", Value: before}, mgobson.DocElem{Name: "lastErrorObject", Value: lastError})
+		}
+
+		after := applyUpdate(doc, filter, update, false)
+		f.collections[collection][i] = after
+		if cmd.flag("new") {
+			return okReply(mgobson.DocElem{Name: "value", Value: copyDoc(after)}, mgobson.DocElem{Name: "lastErrorObject", Value: lastError})
+		}
+		return okReply(mgobson.DocElem{Name: "value", Value: before}, mgobson.DocElem{Name: "lastErrorObject", Value: lastError})
+	}
+
+	return commandError(1, "fake mongo: lost the matched document")
+}
+
+// aggregate runs the pipelines made of $match, $sort, $skip, $limit and a
+// $group counting documents, which is what counting with the official driver
+// sends.
+func (f *fakeMongo) aggregate(db string, cmd fakeCommand) mgobson.D {
+	collection := cmd.str("aggregate")
+
+	var docs []mgobson.M
+	for _, doc := range f.collections[collection] {
+		docs = append(docs, copyDoc(doc))
+	}
+
+	var pipeline []mgobson.RawD
+	cmd.get("pipeline", &pipeline)
+
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			return commandError(40323, "a pipeline stage must have exactly one field")
+		}
+
+		switch stage[0].Name {
+		case "$match":
+			var filter mgobson.M
+			stage[0].Value.Unmarshal(&filter)
+			var kept []mgobson.M
+			for _, doc := range docs {
+				if matches(doc, filter) {
+					kept = append(kept, doc)
+				}
+			}
+			docs = kept
+		case "$sort":
+			var order mgobson.D
+			stage[0].Value.Unmarshal(&order)
+			sortDocs(docs, order)
+		case "$skip":
+			var n interface{}
+			stage[0].Value.Unmarshal(&n)
+			skip, _ := number(n)
+			docs = docs[min(int(skip), len(docs)):]
+		case "$limit":
+			var n interface{}
+			stage[0].Value.Unmarshal(&n)
+			limit, _ := number(n)
+			docs = docs[:min(int(limit), len(docs))]
+		case "$group":
+			var group mgobson.M
+			stage[0].Value.Unmarshal(&group)
+			if len(docs) == 0 {
+				break
+			}
+			result := mgobson.M{"_id": group["_id"]}
+			for field, acc := range group {
+				if field != "_id" {
+					if sum, ok := acc.(mgobson.M)["$sum"]; ok {
+						n, _ := number(sum)
+						result[field] = int32(n * float64(len(docs)))
+					}
+				}
+			}
+			docs = []mgobson.M{result}
+		default:
+			return commandError(40324, "fake mongo: unsupported pipeline stage " + stage[0].Name)
+		}
+	}
+
+	return cursorReply(db, collection, docs)
+}
+
+func copyDoc(doc mgobson.M) mgobson.M {
+	data, err := mgobson.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+
+	var out mgobson.M
+	if err := mgobson.Unmarshal(data, &out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// upsertBase is the document an upsert starts from: the equality conditions of
+// filter.
+func upsertBase(filter mgobson.M) mgobson.M {
+	doc := mgobson.M{}
+	for k, v := range filter {
+		if strings.HasPrefix(k, "$") || strings.Contains(k, ".") {
+			continue
+		}
+		if m, ok := v.(mgobson.M); ok && isOperatorDoc(m) {
+			continue
+		}
+		doc[k] = v
+	}
+
+	return doc
+}
+
+func project(doc, projection mgobson.M) mgobson.M {
+	include := false
+	for k, v := range projection {
+		if n, _ := number(v); k != "_id" && n != 0 {
+			include = true
+		}
+	}
+
+	out := mgobson.M{}
+	if include {
+		if n, ok := number(projection["_id"]); !ok || n != 0 {
+			if id, ok := doc["_id"]; ok {
+				out["_id"] = id
+			}
+		}
+		for k := range projection {
+			if v, ok := doc[k]; ok && k != "_id" {
+				out[k] = v
+			}
+		}
+		return out
+	}
+
+	for k, v := range doc {
+		if n, ok := number(projection[k]); !ok || n != 0 {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func sortDocs(docs []mgobson.M, order mgobson.D) {
+	if len(order) == 0 {
+		return
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, e := range order {
+			dir, _ := number(e.Value)
+			a, _ := lookup(docs[i], e.Name)
+			b, _ := lookup(docs[j], e.Name)
+			if c := compareValues(a, b); c != 0 {
+				return (c < 0) == (dir >= 0)
+			}
+		}
+		return false
+	})
+}
+
+// lookup returns the value at the dotted path, collecting the values of the
+// arrays along the way.
+func lookup(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+
+	key, rest, _ := strings.Cut(path, ".")
+
+	switch t := v.(type) {
+	case mgobson.M:
+		child, ok := t[key]
+		if !ok {
+			return nil, false
+		}
+		return lookup(child, rest)
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil {
+			if i >= len(t) {
+				return nil, false
+			}
+			return lookup(t[i], rest)
+		}
+
+		var values []interface{}
+		for _, e := range t {
+			if child, ok := lookup(e, path); ok {
+				values = append(values, child)
+			}
+		}
+		return values, len(values) > 0
+	}
+
+	return nil, false
+}
+
+func isOperatorDoc(m mgobson.M) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether doc matches the query filter.
+func matches(doc mgobson.M, filter mgobson.M) bool {
+	for key, cond := range filter {
+		switch key {
+		case "$or", "$and", "$nor":
+			subs, _ := cond.([]interface{})
+			any := false
+			all := true
+			for _, s := range subs {
+				m, _ := s.(mgobson.M)
+				if matches(doc, m) {
+					any = true
+				} else {
+					all = false
+				}
+			}
+			if (key == "$or" && !any) || (key == "$and" && !all) || (key == "$nor" && any) {
+				return false
+			}
+			continue
+		case "$comment":
+			continue
+		}
+
+		value, present := lookup(doc, key)
+		if !matchValue(value, present, cond) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchValue(value interface{}, present bool, cond interface{}) bool {
+	if m, ok := cond.(mgobson.M); ok && isOperatorDoc(m) {
+		for op, arg := range m {
+			if !matchOperator(value, present, op, arg, m) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if re, ok := cond.(mgobson.RegEx); ok {
+		return matchRegex(value, re.Pattern, re.Options)
+	}
+
+	return equalsAny(value, present, cond)
+}
+
+// equalsAny reports whether the value, or one of its elements, equals cond.
+// A nil cond matches missing values.
+func equalsAny(value interface{}, present bool, cond interface{}) bool {
+	if cond == nil {
+		if !present || value == nil {
+			return true
+		}
+		if list, ok := value.([]interface{}); ok {
+			for _, e := range list {
+				if e == nil {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !present {
+		return false
+	}
+
+	if equalValues(value, cond) {
+		return true
+	}
+	if list, ok := value.([]interface{}); ok {
+		for _, e := range list {
+			if equalValues(e, cond) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchOperator(value interface{}, present bool, op string, arg interface{}, all mgobson.M) bool {
+	switch op {
+	case "$eq":
+		return equalsAny(value, present, arg)
+	case "$ne":
+		return !equalsAny(value, present, arg)
+	case "$in", "$nin":
+		list, _ := arg.([]interface{})
+		in := false
+		for _, e := range list {
+			if matchValue(value, present, e) {
+				in = true
+				break
+			}
+		}
+		return in == (op == "$in")
+	case "$all":
+		list, _ := arg.([]interface{})
+		for _, e := range list {
+			if !equalsAny(value, present, e) {
+				return false
+			}
+		}
+		return present
+	case "$gt", "$gte", "$lt", "$lte":
+		if !present {
+			return false
+		}
+		values := []interface{}{value}
+		if list, ok := value.([]interface{}); ok {
+			values = list
+		}
+		for _, v := range values {
+			if comparableValues(v, arg) {
+				c := compareValues(v, arg)
+				if (op == "$gt" && c > 0) || (op == "$gte" && c >= 0) || (op == "$lt" && c < 0) || (op == "$lte" && c <= 0) {
+					return true
+				}
+			}
+		}
+		return false
+	case "$exists":
+		want, _ := arg.(bool)
+		if n, ok := number(arg); ok {
+			want = n != 0
+		}
+		return present == want
+	case "$size":
+		list, ok := value.([]interface{})
+		n, _ := number(arg)
+		return ok && len(list) == int(n)
+	case "$elemMatch":
+		list, _ := value.([]interface{})
+		sub, _ := arg.(mgobson.M)
+		for _, e := range list {
+			if d, ok := e.(mgobson.M); ok && !isOperatorDoc(sub) {
+				if matches(d, sub) {
+					return true
+				}
+			} else if matchValue(e, true, sub) {
+				return true
+			}
+		}
+		return false
+	case "$not":
+		return !matchValue(value, present, arg)
+	case "$regex":
+		pattern, _ := arg.(string)
+		if re, ok := arg.(mgobson.RegEx); ok {
+			pattern = re.Pattern
+		}
+		options, _ := all["$options"].(string)
+		return matchRegex(value, pattern, options)
+	case "$options":
+		return true
+	}
+
+	panic("fake mongo: unsupported query operator " + op)
+}
+
+func matchRegex(value interface{}, pattern, options string) bool {
+	if strings.Contains(options, "i") {
+		pattern = "(?i)" + pattern
+	}
+	re := regexp.MustCompile(pattern)
+
+	values := []interface{}{value}
+	if list, ok := value.([]interface{}); ok {
+		values = list
+	}
+	for _, v := range values {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func comparableValues(a, b interface{}) bool {
+	if _, ok := number(a); ok {
+		_, ok = number(b)
+		return ok
+	}
+	return reflect.TypeOf(a) == reflect.TypeOf(b)
+}
+
+func equalValues(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	if x, ok := a.(time.Time); ok {
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders values of the same type, and missing values first.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	switch x := a.(type) {
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case mgobson.ObjectId:
+		if y, ok := b.(mgobson.ObjectId); ok {
+			return strings.Compare(string(x), string(y))
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x != y {
+			if y {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// applyUpdate applies the update operators, or the replacement document, to
+// doc. The positional $ refers to the first array element the filter matched.
+func applyUpdate(doc, filter, update mgobson.M, inserting bool) mgobson.M {
+	if !isOperatorDoc(update) {
+		replaced := copyDoc(update)
+		if id, ok := doc["_id"]; ok {
+			replaced["_id"] = id
+		}
+		if _, ok := replaced["_id"]; !ok {
+			replaced["_id"] = mgobson.NewObjectId()
+		}
+		return replaced
+	}
+
+	for op, arg := range update {
+		fields, _ := arg.(mgobson.M)
+		for path, v := range fields {
+			path = resolvePositional(doc, filter, path)
+
+			switch op {
+			case "$set":
+				setPath(doc, path, v)
+			case "$setOnInsert":
+				if inserting {
+					setPath(doc, path, v)
+				}
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				current, _ := lookup(doc, path)
+				x, _ := number(current)
+				y, _ := number(v)
+				if _, ok := current.(float64); ok {
+					setPath(doc, path, x + y)
+				} else if _, ok := v.(float64); ok {
+					setPath(doc, path, x + y)
+				} else {
+					setPath(doc, path, int64(x + y))
+				}
+			case "$min", "$max":
+				current, present := lookup(doc, path)
+				c := compareValues(v, current)
+				if !present || (op == "$min" && c < 0) || (op == "$max" && c > 0) {
+					setPath(doc, path, v)
+				}
+			case "$currentDate":
+				setPath(doc, path, time.Now().UTC().Truncate(time.Millisecond))
+			case "$push", "$addToSet":
+				current, _ := lookup(doc, path)
+				list, _ := current.([]interface{})
+				values := []interface{}{v}
+				if m, ok := v.(mgobson.M); ok {
+					if each, ok := m["$each"].([]interface{}); ok {
+						values = each
+					}
+				}
+				for _, e := range values {
+					if op == "$addToSet" && equalsAny(list, true, e) {
+						continue
+					}
+					list = append(list, e)
+				}
+				setPath(doc, path, list)
+			case "$pull":
+				current, _ := lookup(doc, path)
+				list, _ := current.([]interface{})
+				kept := []interface{}{}
+				for _, e := range list {
+					if m, ok := v.(mgobson.M); ok {
+						if d, ok := e.(mgobson.M); ok && !isOperatorDoc(m) {
+							if matches(d, m) {
+								continue
+							}
+						} else if matchValue(e, true, m) {
+							continue
+						}
+					} else if equalValues(e, v) {
+						continue
+					}
+					kept = append(kept, e)
+				}
+				setPath(doc, path, kept)
+			default:
+				panic("fake mongo: unsupported update operator " + op)
+			}
+		}
+	}
+
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = mgobson.NewObjectId()
+	}
+	return doc
+}
+
+// resolvePositional replaces the $ of path with the index of the first element
+// of the array matching the filter's conditions on it.
+func resolvePositional(doc, filter mgobson.M, path string) string {
+	array, rest, found := strings.Cut(path, ".$")
+	if !found {
+		return path
+	}
+
+	current, _ := lookup(doc, array)
+	list, _ := current.([]interface{})
+
+	for i, e := range list {
+		element, _ := e.(mgobson.M)
+		all := true
+		for key, cond := range filter {
+			if sub, ok := strings.CutPrefix(key, array + "."); ok {
+				value, present := lookup(element, sub)
+				if !matchValue(value, present, cond) {
+					all = false
+				}
+			}
+		}
+		if all {
+			return array + "." + strconv.Itoa(i) + rest
+		}
+	}
+
+	return path
+}
+
+func setPath(doc mgobson.M, path string, v interface{}) {
+	key, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		doc[key] = v
+		return
+	}
+
+	switch child := doc[key].(type) {
+	case mgobson.M:
+		setPath(child, rest, v)
+	case []interface{}:
+		i, rest2, _ := strings.Cut(rest, ".")
+		n, err := strconv.Atoi(i)
+		if err != nil || n >= len(child) {
+			panic("fake mongo: cannot set " + path)
+		}
+		if rest2 == "" {
+			child[n] = v
+			return
+		}
+		element, _ := child[n].(mgobson.M)
+		setPath(element, rest2, v)
+	default:
+		m := mgobson.M{}
+		doc[key] = m
+		setPath(m, rest, v)
+	}
+}
+
+func unsetPath(doc mgobson.M, path string) {
+	key, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		delete(doc, key)
+		return
+	}
+
+	if child, ok := doc[key].(mgobson.M); ok {
+		unsetPath(child, rest)
+	}
+}
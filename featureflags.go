@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/flags"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	featureFlagCollectionName	string = "FeatureFlag"
+	featureFlagCacheTTL			time.Duration = 10 * time.Second
+)
+
+type(
+	// FeatureFlagModel turns an experimental feature on for every user, the
+	// users listed, and a percentage of the others. Flags apply to every
+	// tenant.
+	FeatureFlagModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		Name			string `bson:"name"`
+		EnabledForAll	bool `bson:"enabledForAll"`
+		UserIDs			[]bson.ObjectId `bson:"userIDs"`
+		Percentage		int `bson:"percentage"`
+		CreatedAt		time.Time `bson:"createdAt"`
+		UpdatedAt		time.Time `bson:"updatedAt"`
+	}
+
+	FeatureFlag struct {
+		Name			string `json:"name" validate:"required,max=100"`
+		EnabledForAll	bool `json:"enabledForAll"`
+		UserIDs			[]string `json:"userIds"`
+		Percentage		int `json:"percentage" validate:"min=0,max=100"`
+		CreatedAt		time.Time `json:"createdAt"`
+		UpdatedAt		time.Time `json:"updatedAt"`
+	}
+)
+
+var (
+	featureFlagsMu			sync.Mutex
+	featureFlagsCache		[]flags.Flag
+	featureFlagsCachedAt	time.Time
+)
+
+func toFeatureFlag(f FeatureFlagModel) FeatureFlag {
+	userIDs := []string{}
+	for _, id := range f.UserIDs {
+		userIDs = append(userIDs, id.Hex())
+	}
+
+	return FeatureFlag{
+		Name: f.Name,
+		EnabledForAll: f.EnabledForAll,
+		UserIDs: userIDs,
+		Percentage: f.Percentage,
+		CreatedAt: f.CreatedAt,
+		UpdatedAt: f.UpdatedAt,
+	}
+}
+
+// featureFlagMiddleware attaches the flags of the authenticated user to the
+// request context, for flags.IsEnabled. Should the flags fail to load, every
+// flag is off rather than the request failing.
+func featureFlagMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, err := loadFeatureFlags(r)
+		if err != nil {
+			logFor(r).Error().Err(err).Msg("failed to fetch feature flags")
+		}
+
+		ctx := flags.WithChecker(r.Context(), flags.NewChecker(currentUserID(r).Hex(), list))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// loadFeatureFlags returns every flag, cached for featureFlagCacheTTL so that
+// requests rarely need to query them.
+func loadFeatureFlags(r *http.Request) ([]flags.Flag, error) {
+	featureFlagsMu.Lock()
+	defer featureFlagsMu.Unlock()
+
+	if featureFlagsCache != nil && time.Since(featureFlagsCachedAt) < featureFlagCacheTTL {
+		return featureFlagsCache, nil
+	}
+
+	var models []FeatureFlagModel
+
+	if err := timedOp(r.Context(), featureFlagCollectionName + ".find", func() error {
+		return dbFor(r).C(featureFlagCollectionName).Find(nil).All(&models)
+	}); err != nil {
+		return nil, err
+	}
+
+	list := []flags.Flag{}
+	for _, m := range models {
+		f := flags.Flag{Name: m.Name, EnabledForAll: m.EnabledForAll, Percentage: m.Percentage}
+		for _, id := range m.UserIDs {
+			f.UserIDs = append(f.UserIDs, id.Hex())
+		}
+		list = append(list, f)
+	}
+
+	featureFlagsCache = list
+	featureFlagsCachedAt = time.Now()
+
+	return list, nil
+}
+
+// resetFeatureFlagCache makes the next request load the flags again. Other
+// instances still serve their cached flags for up to featureFlagCacheTTL.
+func resetFeatureFlagCache() {
+	featureFlagsMu.Lock()
+	defer featureFlagsMu.Unlock()
+
+	featureFlagsCache = nil
+}
+
+// parseFeatureFlag decodes and validates a flag from the request body. It
+// writes the problem and returns false when the flag is invalid.
+func parseFeatureFlag(w http.ResponseWriter, r *http.Request, name string) (FeatureFlag, []bson.ObjectId, bool) {
+	var f FeatureFlag
+
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return f, nil, false
+	}
+
+	if name != "" {
+		f.Name = name
+	}
+	f.Name = strings.TrimSpace(f.Name)
+
+	if !checkInput(w, r, f) {
+		return f, nil, false
+	}
+
+	userIDs := []bson.ObjectId{}
+	seen := map[bson.ObjectId]bool{}
+
+	for _, id := range f.UserIDs {
+		id = strings.TrimSpace(id)
+		if !bson.IsObjectIdHex(id) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The user id " + id + " is invalid", ""))
+			return f, nil, false
+		}
+		if oid := bson.ObjectIdHex(id); !seen[oid] {
+			seen[oid] = true
+			userIDs = append(userIDs, oid)
+		}
+	}
+
+	return f, userIDs, true
+}
+
+// @Summary List feature flags
+// @Tags admin
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=[]FeatureFlag}
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/flags [get]
+func fetchFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	var models []FeatureFlagModel
+
+	if err := timedOp(r.Context(), featureFlagCollectionName + ".find", func() error {
+		return dbFor(r).C(featureFlagCollectionName).Find(nil).Sort("name").All(&models)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch feature flags")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch feature flags", err.Error()))
+		return
+	}
+
+	list := []FeatureFlag{}
+	for _, m := range models {
+		list = append(list, toFeatureFlag(m))
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": list,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Create a feature flag
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param body body FeatureFlag true "The flag"
+// @Success 201 {object} DataEnvelope{data=FeatureFlag}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/flags [post]
+func createFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	f, userIDs, ok := parseFeatureFlag(w, r, "")
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	model := FeatureFlagModel{
+		ID: bson.NewObjectId(),
+		Name: f.Name,
+		EnabledForAll: f.EnabledForAll,
+		UserIDs: userIDs,
+		Percentage: f.Percentage,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := timedOp(r.Context(), featureFlagCollectionName + ".insert", func() error {
+		return dbFor(r).C(featureFlagCollectionName).Insert(&model)
+	}); err != nil {
+		if store.IsDup(err) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "A flag with this name already exists", "").
+				With("name", f.Name))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to create feature flag")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to create feature flag", err.Error()))
+		return
+	}
+
+	resetFeatureFlagCache()
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": toFeatureFlag(model),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// updateFeatureFlag replaces who the flag is on for. The name in the body, if
+// any, is ignored.
+//
+// @Summary Replace a feature flag
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param name path string true "The flag name"
+// @Param body body FeatureFlag true "The flag"
+// @Success 200 {object} DataEnvelope{data=FeatureFlag}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/flags/{name} [put]
+func updateFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	f, userIDs, ok := parseFeatureFlag(w, r, chi.URLParam(r, "name"))
+	if !ok {
+		return
+	}
+
+	var model FeatureFlagModel
+
+	if err := timedOp(r.Context(), featureFlagCollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(featureFlagCollectionName).Find(bson.M{"name": f.Name}).Apply(store.Change{
+			Update: bson.M{"$set": bson.M{
+				"enabledForAll": f.EnabledForAll,
+				"userIDs": userIDs,
+				"percentage": f.Percentage,
+				"updatedAt": time.Now(),
+			}},
+			ReturnNew: true,
+		}, &model)
+		return err
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Feature flag not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to update feature flag")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update feature flag", err.Error()))
+		return
+	}
+
+	resetFeatureFlagCache()
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toFeatureFlag(model),
+	})
+
+	utils.CheckErr(jsonErr)
+}
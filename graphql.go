@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+//go:generate go tool gqlgen generate
+
+const (
+	graphqlRequestKey	contextKey = "graphqlRequest"
+	commentLoaderKey	contextKey = "commentLoader"
+)
+
+type(
+	// graphqlResolver resolves the schema in graph/schema.graphqls. Like
+	// todoService, it runs queries and mutations through the HTTP todo
+	// routes.
+	graphqlResolver struct {
+		todos			http.Handler
+	}
+
+	queryResolver struct{ *graphqlResolver }
+
+	mutationResolver struct{ *graphqlResolver }
+
+	todoResolver struct{ *graphqlResolver }
+
+	// commentLoader loads the comments of the todos of a GraphQL request in
+	// a single query: those of every todo listed so far are loaded together
+	// the first time any is asked for. Subtasks need no loader, being stored
+	// in their todo.
+	commentLoader struct {
+		mu				sync.Mutex
+		pending			[]string
+		loaded			map[string][]*Comment
+	}
+)
+
+func (r *graphqlResolver) Query() QueryResolver { return &queryResolver{r} }
+
+func (r *graphqlResolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+func (r *graphqlResolver) Todo() TodoResolver { return &todoResolver{r} }
+
+// graphqlHandler serves GraphQL queries, authenticated as the HTTP routes are.
+//
+// @Summary Run a GraphQL query
+// @Tags graphql
+// @Accept json
+// @Produce json
+// @Param body body object{query=string,variables=object,operationName=string} true "The query"
+// @Success 200 {object} object{data=object,errors=[]object}
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /graphql [post]
+func graphqlHandler() http.Handler {
+	srv := handler.New(NewExecutableSchema(Config{
+		Resolvers: &graphqlResolver{todos: todoHandlers()},
+	}))
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), graphqlRequestKey, r)
+		ctx = context.WithValue(ctx, commentLoaderKey, &commentLoader{loaded: map[string][]*Comment{}})
+		srv.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// call runs a request to the todo routes with the headers of the GraphQL
+// request, and decodes a successful response into out. Problems become
+// GraphQL errors carrying their HTTP status.
+func (r *graphqlResolver) call(ctx context.Context, method, target string, body, out interface{}) error {
+	req := ctx.Value(graphqlRequestKey).(*http.Request)
+
+	header := http.Header{}
+	for _, key := range routeHeaders {
+		if v := req.Header.Get(key); v != "" {
+			header.Set(key, v)
+		}
+	}
+
+	err := callRoute(ctx, r.todos, header, req.Host, method, target, body, out)
+	if problem, ok := err.(*routeError); ok {
+		return &gqlerror.Error{
+			Message: problem.Error(),
+			Extensions: map[string]interface{}{"status": problem.Status},
+		}
+	}
+
+	return err
+}
+
+// todo fetches the todo, as GET /todo/{id} returns it.
+func (r *graphqlResolver) todo(ctx context.Context, id string) (*Todo, error) {
+	var resp struct {
+		Data			Todo `json:"data"`
+	}
+
+	if err := r.call(ctx, http.MethodGet, todoPath(id), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Data, nil
+}
+
+func (r *queryResolver) Todos(ctx context.Context, completed *bool, listID *string, tags []string, sort *string, page *int, limit *int) ([]*Todo, error) {
+	query := url.Values{"page": {"1"}}
+	if completed != nil {
+		query.Set("completed", strconv.FormatBool(*completed))
+	}
+	if listID != nil {
+		query.Set("listId", *listID)
+	}
+	if len(tags) > 0 {
+		query.Set("tags", strings.Join(tags, ","))
+	}
+	if sort != nil {
+		query.Set("sort", *sort)
+	}
+	if page != nil {
+		query.Set("page", strconv.Itoa(*page))
+	}
+	if limit != nil {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+
+	var resp struct {
+		Data			[]Todo `json:"data"`
+	}
+
+	if err := r.call(ctx, http.MethodGet, "/?" + query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	loader := ctx.Value(commentLoaderKey).(*commentLoader)
+
+	todos := []*Todo{}
+	for i := range resp.Data {
+		todos = append(todos, &resp.Data[i])
+		loader.prime(resp.Data[i].ID)
+	}
+
+	return todos, nil
+}
+
+func (r *queryResolver) Todo(ctx context.Context, id string) (*Todo, error) {
+	todo, err := r.todo(ctx, id)
+	if problem, ok := err.(*gqlerror.Error); ok && problem.Extensions["status"] == http.StatusNotFound {
+		return nil, nil
+	}
+
+	return todo, err
+}
+
+func (r *mutationResolver) CreateTodo(ctx context.Context, input NewTodo) (*Todo, error) {
+	body := map[string]interface{}{
+		"title": input.Title,
+		"tags": append([]string{}, input.Tags...),
+	}
+	if input.Description != nil {
+		body["description"] = *input.Description
+	}
+	if input.Priority != nil {
+		body["priority"] = *input.Priority
+	}
+	if input.ListID != nil {
+		body["listId"] = *input.ListID
+	}
+	if input.DueDate != nil {
+		body["dueDate"] = input.DueDate.Format(time.RFC3339)
+	}
+
+	var resp struct {
+		ID				string `json:"todo_id"`
+	}
+
+	if err := r.call(ctx, http.MethodPost, "/", body, &resp); err != nil {
+		return nil, err
+	}
+
+	return r.todo(ctx, resp.ID)
+}
+
+// UpdateTodo patches the todo; tags and dueDate set to null are cleared.
+func (r *mutationResolver) UpdateTodo(ctx context.Context, id string, input TodoChanges) (*Todo, error) {
+	body := map[string]interface{}{}
+	if input.Title != nil {
+		body["title"] = *input.Title
+	}
+	if input.Completed != nil {
+		body["completed"] = *input.Completed
+	}
+	if input.Status != nil {
+		body["status"] = *input.Status
+	}
+	if input.Priority != nil {
+		body["priority"] = *input.Priority
+	}
+	if input.Description != nil {
+		body["description"] = *input.Description
+	}
+	if tags, ok := input.Tags.ValueOK(); ok {
+		body["tags"] = append([]string{}, tags...)
+	}
+	if dueDate, ok := input.DueDate.ValueOK(); ok {
+		body["dueDate"] = nil
+		if dueDate != nil {
+			body["dueDate"] = dueDate.Format(time.RFC3339)
+		}
+	}
+
+	if err := r.call(ctx, http.MethodPatch, todoPath(id), body, nil); err != nil {
+		return nil, err
+	}
+
+	return r.todo(ctx, id)
+}
+
+func (r *mutationResolver) DeleteTodo(ctx context.Context, id string) (bool, error) {
+	if err := r.call(ctx, http.MethodDelete, todoPath(id), nil, nil); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) ToggleTodo(ctx context.Context, id string) (*Todo, error) {
+	var resp struct {
+		Data			Todo `json:"data"`
+	}
+
+	if err := r.call(ctx, http.MethodPost, todoPath(id) + "/toggle", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Data, nil
+}
+
+// Comments does not check access to the todo again, as it was loaded
+// through the routes, which did.
+func (r *todoResolver) Comments(ctx context.Context, obj *Todo) ([]*Comment, error) {
+	req := ctx.Value(graphqlRequestKey).(*http.Request)
+	return ctx.Value(commentLoaderKey).(*commentLoader).load(req, obj.ID)
+}
+
+// prime adds the todo to those whose comments are loaded together.
+func (l *commentLoader) prime(todoID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pending = append(l.pending, todoID)
+}
+
+// load returns the comments of the todo, oldest first, loading them along
+// with those of every todo primed and not loaded yet.
+func (l *commentLoader) load(r *http.Request, todoID string) ([]*Comment, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if comments, ok := l.loaded[todoID]; ok {
+		return comments, nil
+	}
+
+	var ids []bson.ObjectId
+	for _, id := range append(l.pending, todoID) {
+		if _, ok := l.loaded[id]; !ok && bson.IsObjectIdHex(id) {
+			ids = append(ids, bson.ObjectIdHex(id))
+			l.loaded[id] = []*Comment{}
+		}
+	}
+	l.pending = nil
+
+	var comments []CommentModel
+
+	if err := timedOp(r.Context(), commentCollectionName + ".find", func() error {
+		return dbFor(r).C(commentCollectionName).Find(inTenant(r, bson.M{"todoID": bson.M{"$in": ids}})).
+			Sort("createdAt").All(&comments)
+	}); err != nil {
+		for _, id := range ids {
+			delete(l.loaded, id.Hex())
+		}
+		logFor(r).Error().Err(err).Msg("failed to fetch comments")
+		return nil, err
+	}
+
+	for _, c := range comments {
+		comment := toComment(c)
+		l.loaded[comment.TodoID] = append(l.loaded[comment.TodoID], &comment)
+	}
+
+	if comments, ok := l.loaded[todoID]; ok {
+		return comments, nil
+	}
+	return []*Comment{}, nil
+}
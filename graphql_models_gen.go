@@ -0,0 +1,34 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package main
+
+import (
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+type Mutation struct {
+}
+
+type NewTodo struct {
+	Title       string     `json:"title"`
+	Description *string    `json:"description,omitempty"`
+	Priority    *string    `json:"priority,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	ListID      *string    `json:"listId,omitempty"`
+	DueDate     *time.Time `json:"dueDate,omitempty"`
+}
+
+type Query struct {
+}
+
+type TodoChanges struct {
+	Title       *string                       `json:"title,omitempty"`
+	Completed   *bool                         `json:"completed,omitempty"`
+	Status      *string                       `json:"status,omitempty"`
+	Priority    *string                       `json:"priority,omitempty"`
+	Description *string                       `json:"description,omitempty"`
+	Tags        graphql.Omittable[[]string]   `json:"tags,omitempty"`
+	DueDate     graphql.Omittable[*time.Time] `json:"dueDate,omitempty"`
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	todopb "github.com/nkpremices/go-chi-mongodb-simple-todo/proto"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// todoService implements TodoService by running each call through the HTTP
+// todo routes, so that both APIs share their authentication, validation,
+// caching, events and audit trail.
+type todoService struct {
+	todopb.UnimplementedTodoServiceServer
+	todos			http.Handler
+}
+
+// newGRPCServer returns the gRPC server of TodoService, using TLS when
+// tlsConfig is not nil.
+func newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	s := grpc.NewServer(opts...)
+	todopb.RegisterTodoServiceServer(s, &todoService{
+		todos: utils.RequestID(requestLogger(tenantMiddleware(todoHandlers()))),
+	})
+
+	return s
+}
+
+// serveGRPC runs s on cfg.GRPCPort until it is stopped.
+func serveGRPC(s *grpc.Server) {
+	lis, err := net.Listen("tcp", cfg.GRPCPort)
+	if err != nil {
+		log.Error().Err(err).Str("port", cfg.GRPCPort).Msg("failed to listen for gRPC")
+		return
+	}
+
+	log.Info().Str("port", cfg.GRPCPort).Msg("listening for gRPC")
+	if err := s.Serve(lis); err != nil {
+		log.Error().Err(err).Msg("gRPC serve")
+	}
+}
+
+// stopGRPC lets the calls still running finish until ctx is done, and then
+// cancels them.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// call runs the HTTP request described by method, path, query and body
+// through h, authenticated by the call's metadata, and decodes a successful
+// response into out. Failures become the gRPC status matching the problem
+// returned.
+func (s *todoService) call(ctx context.Context, h http.Handler, method, path string, query url.Values, body interface{}, out interface{}) error {
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	header := http.Header{}
+	for _, key := range routeHeaders {
+		if v := md.Get(key); len(v) > 0 {
+			header.Set(key, v[0])
+		}
+	}
+
+	host := ""
+	if v := md.Get(":authority"); len(v) > 0 {
+		host = v[0]
+	}
+
+	err := callRoute(ctx, h, header, host, method, target, body, out)
+	if problem, ok := err.(*routeError); ok {
+		return status.Error(grpcCode(problem.Status), problem.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return nil
+}
+
+// grpcCode maps the HTTP status of a problem to a gRPC code. Database errors,
+// reported as 102 Processing, are internal errors.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+func (s *todoService) GetTodo(ctx context.Context, req *todopb.GetTodoRequest) (*todopb.Todo, error) {
+	var resp struct {
+		Data			Todo `json:"data"`
+	}
+
+	if err := s.call(ctx, s.todos, http.MethodGet, todoPath(req.GetId()), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return toProtoTodo(resp.Data), nil
+}
+
+func (s *todoService) ListTodos(ctx context.Context, req *todopb.ListTodosRequest) (*todopb.ListTodosResponse, error) {
+	query := url.Values{}
+	if req.GetLimit() > 0 {
+		query.Set("limit", strconv.Itoa(int(req.GetLimit())))
+	}
+	if req.GetCursor() != "" {
+		query.Set("cursor", req.GetCursor())
+	}
+	if req.Completed != nil {
+		query.Set("completed", strconv.FormatBool(req.GetCompleted()))
+	}
+	if req.GetListId() != "" {
+		query.Set("listId", req.GetListId())
+	}
+	if len(req.GetTags()) > 0 {
+		query.Set("tags", strings.Join(req.GetTags(), ","))
+	}
+	if req.GetSort() != "" {
+		query.Set("sort", req.GetSort())
+	}
+
+	var resp struct {
+		Data			[]Todo `json:"data"`
+		NextCursor		string `json:"nextCursor"`
+	}
+
+	if err := s.call(ctx, s.todos, http.MethodGet, "/", query, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	list := &todopb.ListTodosResponse{NextCursor: resp.NextCursor}
+	for _, t := range resp.Data {
+		list.Todos = append(list.Todos, toProtoTodo(t))
+	}
+
+	return list, nil
+}
+
+func (s *todoService) CreateTodo(ctx context.Context, req *todopb.CreateTodoRequest) (*todopb.Todo, error) {
+	body := map[string]interface{}{
+		"title": req.GetTitle(),
+		"description": req.GetDescription(),
+		"priority": req.GetPriority(),
+		"tags": append([]string{}, req.GetTags()...),
+	}
+	if req.GetListId() != "" {
+		body["listId"] = req.GetListId()
+	}
+	if req.GetDueDate() != nil {
+		body["dueDate"] = req.GetDueDate().AsTime().Format(time.RFC3339)
+	}
+
+	var resp struct {
+		ID				string `json:"todo_id"`
+	}
+
+	if err := s.call(ctx, s.todos, http.MethodPost, "/", nil, body, &resp); err != nil {
+		return nil, err
+	}
+
+	return s.GetTodo(ctx, &todopb.GetTodoRequest{Id: resp.ID})
+}
+
+// UpdateTodo patches the todo, so that fields gRPC clients do not know about
+// are kept.
+func (s *todoService) UpdateTodo(ctx context.Context, req *todopb.UpdateTodoRequest) (*todopb.Todo, error) {
+	body := map[string]interface{}{}
+	if req.Title != nil {
+		body["title"] = req.GetTitle()
+	}
+	if req.Completed != nil {
+		body["completed"] = req.GetCompleted()
+	}
+	if req.Status != nil {
+		body["status"] = req.GetStatus()
+	}
+	if req.Priority != nil {
+		body["priority"] = req.GetPriority()
+	}
+	if req.Description != nil {
+		body["description"] = req.GetDescription()
+	}
+	if req.GetUpdateTags() {
+		body["tags"] = append([]string{}, req.GetTags()...)
+	}
+	switch {
+	case req.GetClearDueDate():
+		body["dueDate"] = nil
+	case req.GetDueDate() != nil:
+		body["dueDate"] = req.GetDueDate().AsTime().Format(time.RFC3339)
+	}
+
+	if err := s.call(ctx, s.todos, http.MethodPatch, todoPath(req.GetId()), nil, body, nil); err != nil {
+		return nil, err
+	}
+
+	return s.GetTodo(ctx, &todopb.GetTodoRequest{Id: req.GetId()})
+}
+
+func (s *todoService) DeleteTodo(ctx context.Context, req *todopb.DeleteTodoRequest) (*todopb.DeleteTodoResponse, error) {
+	if err := s.call(ctx, s.todos, http.MethodDelete, todoPath(req.GetId()), nil, nil, nil); err != nil {
+		return nil, err
+	}
+
+	return &todopb.DeleteTodoResponse{}, nil
+}
+
+func (s *todoService) WatchTodos(req *todopb.WatchTodosRequest, stream todopb.TodoService_WatchTodosServer) error {
+	// Only the authentication of the HTTP routes is needed, to know whose
+	// events to stream.
+	var userID bson.ObjectId
+	authenticated := tenantMiddleware(authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = currentUserID(r)
+	})))
+
+	if err := s.call(stream.Context(), utils.RequestID(authenticated), http.MethodGet, "/", nil, nil, nil); err != nil {
+		return err
+	}
+
+	sub := hub.Subscribe(userID.Hex())
+	defer hub.Unsubscribe(sub)
+
+	for {
+		select {
+		case event := <-sub.Events:
+			if err := stream.Send(&todopb.TodoEvent{Type: event.Type, TodoId: event.TodoID}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-shuttingDown:
+			return status.Error(codes.Unavailable, "The server is shutting down")
+		}
+	}
+}
+
+// todoPath is the path of the todo's HTTP route, relative to /todo.
+func todoPath(id string) string {
+	return "/" + url.PathEscape(id)
+}
+
+func toProtoTodo(t Todo) *todopb.Todo {
+	todo := &todopb.Todo{
+		Id: t.ID,
+		Title: t.Title,
+		Completed: t.Completed,
+		Status: t.Status,
+		Priority: t.Priority,
+		Description: t.Description,
+		Tags: t.Tags,
+		ListId: t.ListID,
+		DueDate: toTimestamp(t.DueDate),
+		CreatedAt: timestamppb.New(t.CreatedAt),
+		UpdatedAt: timestamppb.New(t.UpdatedAt),
+		CompletedAt: toTimestamp(t.CompletedAt),
+		Version: t.Version,
+	}
+
+	for _, st := range t.Subtasks {
+		todo.Subtasks = append(todo.Subtasks, &todopb.Subtask{
+			Id: st.ID,
+			Title: st.Title,
+			Completed: st.Completed,
+		})
+	}
+
+	return todo
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
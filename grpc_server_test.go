@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	todopb "github.com/nkpremices/go-chi-mongodb-simple-todo/proto"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const grpcTestToken string = "Bearer test-token"
+
+// fakeTodoRoutes stands in for the HTTP todo routes the service calls,
+// keeping the todos in memory and recording the requests.
+type fakeTodoRoutes struct {
+	mu			sync.Mutex
+	todos		map[string]Todo
+	queries		[]url.Values
+	patches		[]map[string]interface{}
+}
+
+func (f *fakeTodoRoutes) handler() http.Handler {
+	rg := chi.NewRouter()
+
+	rg.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != grpcTestToken {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnauthorized, "Authentication is required", ""))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	rg.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.queries = append(f.queries, r.URL.Query())
+		if r.URL.Query().Get("sort") == "secret" {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The sort fields are invalid", ""))
+			return
+		}
+
+		list := []Todo{}
+		for _, t := range f.todos {
+			list = append(list, t)
+		}
+		rnd.JSON(w, http.StatusOK, renderer.M{"data": list, "nextCursor": "next"})
+	})
+
+	rg.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		var t Todo
+		json.NewDecoder(r.Body).Decode(&t)
+		if t.Title == "" {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The body is invalid", ""))
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		t.ID = "64b7f0c2e4b0a1a2b3c4d5f0"
+		f.todos[t.ID] = t
+		rnd.JSON(w, http.StatusCreated, renderer.M{"todo_id": t.ID})
+	})
+
+	rg.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		t, ok := f.todos[chi.URLParam(r, "id")]
+		if !ok {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
+		rnd.JSON(w, http.StatusOK, renderer.M{"data": t})
+	})
+
+	rg.Patch("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		t, ok := f.todos[chi.URLParam(r, "id")]
+		if !ok {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
+		f.patches = append(f.patches, body)
+		if title, ok := body["title"].(string); ok {
+			t.Title = title
+		}
+		if completed, ok := body["completed"].(bool); ok {
+			t.Completed = completed
+		}
+		f.todos[t.ID] = t
+		rnd.JSON(w, http.StatusOK, renderer.M{"message": "Todo updated successfully"})
+	})
+
+	rg.Delete("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		id := chi.URLParam(r, "id")
+		if id == "busy" {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete todo", ""))
+			return
+		}
+		if _, ok := f.todos[id]; !ok {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
+		delete(f.todos, id)
+		rnd.JSON(w, http.StatusOK, renderer.M{"message": "Todo deleted successfully"})
+	})
+
+	return rg
+}
+
+// newTestTodoClient serves todoService over routes through an in-memory
+// connection, and returns a client for it.
+func newTestTodoClient(t *testing.T, routes *fakeTodoRoutes) todopb.TodoServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	todopb.RegisterTodoServiceServer(srv, &todoService{todos: routes.handler()})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return todopb.NewTodoServiceClient(conn)
+}
+
+func authenticated(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", grpcTestToken)
+}
+
+func newFakeTodoRoutes() *fakeTodoRoutes {
+	return &fakeTodoRoutes{todos: map[string]Todo{
+		"64b7f0c2e4b0a1a2b3c4d5e6": {
+			ID: "64b7f0c2e4b0a1a2b3c4d5e6",
+			Title: "Write the report",
+			Status: statusInProgress,
+			Priority: "high",
+			Tags: []string{"work"},
+			CreatedAt: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+			Version: 3,
+			Subtasks: []Subtask{{ID: "64b7f0c2e4b0a1a2b3c4d5e7", Title: "Outline", Completed: true}},
+		},
+	}}
+}
+
+func TestGRPCGetTodo(t *testing.T) {
+	client := newTestTodoClient(t, newFakeTodoRoutes())
+
+	tests := []struct {
+		name		string
+		ctx			context.Context
+		id			string
+		code		codes.Code
+	}{
+		{"existing todo", authenticated(context.Background()), "64b7f0c2e4b0a1a2b3c4d5e6", codes.OK},
+		{"missing todo", authenticated(context.Background()), "64b7f0c2e4b0a1a2b3c4d5ff", codes.NotFound},
+		{"unauthenticated", context.Background(), "64b7f0c2e4b0a1a2b3c4d5e6", codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todo, err := client.GetTodo(tt.ctx, &todopb.GetTodoRequest{Id: tt.id})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+			if tt.code != codes.OK {
+				return
+			}
+
+			want := &todopb.Todo{
+				Id: "64b7f0c2e4b0a1a2b3c4d5e6",
+				Title: "Write the report",
+				Status: statusInProgress,
+				Priority: "high",
+				Tags: []string{"work"},
+				CreatedAt: todo.GetCreatedAt(),
+				UpdatedAt: todo.GetUpdatedAt(),
+				Version: 3,
+				Subtasks: []*todopb.Subtask{{Id: "64b7f0c2e4b0a1a2b3c4d5e7", Title: "Outline", Completed: true}},
+			}
+			if !proto.Equal(todo, want) {
+				t.Errorf("todo = %v, want %v", todo, want)
+			}
+			if got := todo.GetCreatedAt().AsTime(); !got.Equal(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)) {
+				t.Errorf("createdAt = %v, want 2026-03-01T09:00:00Z", got)
+			}
+		})
+	}
+}
+
+func TestGRPCListTodos(t *testing.T) {
+	completed := true
+
+	tests := []struct {
+		name		string
+		req			*todopb.ListTodosRequest
+		query		url.Values
+		code		codes.Code
+	}{
+		{"no filters", &todopb.ListTodosRequest{}, url.Values{}, codes.OK},
+		{
+			"filters and paging",
+			&todopb.ListTodosRequest{Limit: 5, Cursor: "abc", Completed: &completed, Tags: []string{"work", "home"}, Sort: "-createdAt"},
+			url.Values{"limit": {"5"}, "cursor": {"abc"}, "completed": {"true"}, "tags": {"work,home"}, "sort": {"-createdAt"}},
+			codes.OK,
+		},
+		{"invalid argument", &todopb.ListTodosRequest{Sort: "secret"}, url.Values{"sort": {"secret"}}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := newFakeTodoRoutes()
+			client := newTestTodoClient(t, routes)
+
+			resp, err := client.ListTodos(authenticated(context.Background()), tt.req)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+
+			if len(routes.queries) != 1 {
+				t.Fatalf("the route was called %d times, want once", len(routes.queries))
+			}
+			if got := routes.queries[0].Encode(); got != tt.query.Encode() {
+				t.Errorf("query = %s, want %s", got, tt.query.Encode())
+			}
+
+			if tt.code == codes.OK && (len(resp.GetTodos()) != 1 || resp.GetNextCursor() != "next") {
+				t.Errorf("response = %v, want the one todo and the next cursor", resp)
+			}
+		})
+	}
+}
+
+func TestGRPCCreateTodo(t *testing.T) {
+	tests := []struct {
+		name		string
+		title		string
+		code		codes.Code
+	}{
+		{"valid todo", "Buy milk", codes.OK},
+		{"invalid todo", "", codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestTodoClient(t, newFakeTodoRoutes())
+
+			todo, err := client.CreateTodo(authenticated(context.Background()), &todopb.CreateTodoRequest{Title: tt.title})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+			if tt.code == codes.OK && (todo.GetId() != "64b7f0c2e4b0a1a2b3c4d5f0" || todo.GetTitle() != tt.title) {
+				t.Errorf("todo = %v, want the todo created", todo)
+			}
+		})
+	}
+}
+
+func TestGRPCUpdateTodoSendsOnlySetFields(t *testing.T) {
+	routes := newFakeTodoRoutes()
+	client := newTestTodoClient(t, routes)
+	title, completed := "Send the report", true
+
+	todo, err := client.UpdateTodo(authenticated(context.Background()), &todopb.UpdateTodoRequest{
+		Id: "64b7f0c2e4b0a1a2b3c4d5e6",
+		Title: &title,
+		Completed: &completed,
+	})
+	if err != nil {
+		t.Fatalf("UpdateTodo: %v", err)
+	}
+
+	if todo.GetTitle() != title || !todo.GetCompleted() {
+		t.Errorf("todo = %v, want it renamed and completed", todo)
+	}
+
+	want := map[string]interface{}{"title": title, "completed": true}
+	if len(routes.patches) != 1 || len(routes.patches[0]) != len(want) {
+		t.Fatalf("patches = %v, want one with only %v", routes.patches, want)
+	}
+	for k, v := range want {
+		if routes.patches[0][k] != v {
+			t.Errorf("patch %s = %v, want %v", k, routes.patches[0][k], v)
+		}
+	}
+
+	_, err = client.UpdateTodo(authenticated(context.Background()), &todopb.UpdateTodoRequest{Id: "64b7f0c2e4b0a1a2b3c4d5ff", Title: &title})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Errorf("updating a missing todo: code = %v, want %v", code, codes.NotFound)
+	}
+}
+
+func TestGRPCDeleteTodo(t *testing.T) {
+	tests := []struct {
+		name		string
+		id			string
+		code		codes.Code
+	}{
+		{"existing todo", "64b7f0c2e4b0a1a2b3c4d5e6", codes.OK},
+		{"missing todo", "64b7f0c2e4b0a1a2b3c4d5ff", codes.NotFound},
+		{"database error", "busy", codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := newFakeTodoRoutes()
+			client := newTestTodoClient(t, routes)
+
+			_, err := client.DeleteTodo(authenticated(context.Background()), &todopb.DeleteTodoRequest{Id: tt.id})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %v, want %v (%v)", code, tt.code, err)
+			}
+			if _, ok := routes.todos[tt.id]; tt.code == codes.OK && ok {
+				t.Error("the todo was not deleted")
+			}
+		})
+	}
+}
+
+func TestGRPCWatchTodosRequiresAuthentication(t *testing.T) {
+	client := newTestTodoClient(t, newFakeTodoRoutes())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	stream, err := client.WatchTodos(ctx, &todopb.WatchTodosRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Errorf("code = %v, want %v (%v)", code, codes.Unauthenticated, err)
+	}
+}
+
+func TestGRPCCode(t *testing.T) {
+	tests := []struct {
+		status		int
+		code		codes.Code
+	}{
+		{http.StatusBadRequest, codes.InvalidArgument},
+		{http.StatusUnprocessableEntity, codes.InvalidArgument},
+		{http.StatusUnauthorized, codes.Unauthenticated},
+		{http.StatusForbidden, codes.PermissionDenied},
+		{http.StatusNotFound, codes.NotFound},
+		{http.StatusConflict, codes.Aborted},
+		{http.StatusPreconditionFailed, codes.FailedPrecondition},
+		{http.StatusTooManyRequests, codes.ResourceExhausted},
+		{http.StatusServiceUnavailable, codes.Unavailable},
+		{http.StatusProcessing, codes.Internal},
+	}
+
+	for _, tt := range tests {
+		if got := grpcCode(tt.status); got != tt.code {
+			t.Errorf("grpcCode(%d) = %v, want %v", tt.status, got, tt.code)
+		}
+	}
+}
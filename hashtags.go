@@ -0,0 +1,28 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// hashtagPattern matches the #hashtags of titles, which start a word.
+var hashtagPattern = regexp.MustCompile(`(^|\s)#(\w+)`)
+
+// extractHashtags moves the hashtags of the title into the tags, after any
+// tags given explicitly, and strips them from the title. It returns the
+// title as it was sent when it had any, and an empty string otherwise.
+func extractHashtags(t *Todo) string {
+	matches := hashtagPattern.FindAllStringSubmatch(t.Title, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	original := t.Title
+	for _, m := range matches {
+		t.Tags = append(t.Tags, m[2])
+	}
+	t.Tags = normalizeTags(t.Tags)
+	t.Title = strings.Join(strings.Fields(hashtagPattern.ReplaceAllString(t.Title, "$1")), " ")
+
+	return original
+}
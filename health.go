@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const healthCheckTimeout time.Duration = 2 * time.Second
+
+var startedAt = time.Now()
+
+// healthHandler reports whether the server is up and MongoDB is reachable,
+// for use by liveness and readiness probes.
+//
+// @Summary Report whether the server and MongoDB are up
+// @Tags health
+// @Produce json
+// @Success 200 {object} object{status=string,mongodb=string,uptime=int} "uptime is in seconds"
+// @Failure 503 {object} object{status=string,mongodb=string} "MongoDB is down"
+// @Router /health [get]
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	s := sess.Copy()
+	defer s.Close()
+
+	s.SetSyncTimeout(healthCheckTimeout)
+	s.SetSocketTimeout(healthCheckTimeout)
+
+	err := s.Ping()
+	if err == nil {
+		_, err = s.DB(cfg.DBName).C(cfg.CollectionName).Find(nil).Select(bson.M{"_id": 1}).Limit(1).Count()
+	}
+
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("health check failed")
+		jsonErr := rnd.JSON(w, http.StatusServiceUnavailable, renderer.M{
+			"status": "degraded",
+			"mongodb": "down",
+		})
+
+		utils.CheckErr(jsonErr)
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"status": "ok",
+		"mongodb": "up",
+		"uptime": int(time.Since(startedAt).Seconds()),
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	defaultHeatmapDays	int = 90
+	maxHeatmapDays		int = 365
+)
+
+// HeatmapCell counts the todos created or completed at an hour of a day of
+// the week. Days are numbered as MongoDB does, from 1 for Sunday to 7 for
+// Saturday.
+type HeatmapCell struct {
+	DayOfWeek		int `json:"dayOfWeek"`
+	Hour			int `json:"hour"`
+	Count			int `json:"count"`
+}
+
+// getTodoHeatmap returns when the user was active over the last days: every
+// todo created and every todo completed counts once, at the day of the week
+// and hour it happened in the user's time zone. All 168 cells are returned,
+// those without activity with a count of 0.
+//
+// @Summary Get the activity heatmap
+// @Tags todos
+// @Produce json
+// @Param days query int false "The days to cover, 90 by default and at most 365"
+// @Success 200 {object} object{data=[]HeatmapCell,days=int,timezone=string}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/heatmap [get]
+func getTodoHeatmap(w http.ResponseWriter, r *http.Request) {
+	days := defaultHeatmapDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxHeatmapDays {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The days must be an integer between 1 and 365", ""))
+			return
+		}
+		days = n
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	// MongoDB applies the zone's UTC offset at the time of each event, so
+	// that hours stay right across daylight saving changes.
+	tz := userLocation(r).String()
+
+	var groups []struct {
+		ID struct {
+			DayOfWeek	int `bson:"dayOfWeek"`
+			Hour		int `bson:"hour"`
+		} `bson:"_id"`
+		Count			int `bson:"count"`
+	}
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".aggregate", func() error {
+		return dbFor(r).C(cfg.CollectionName).Pipe([]bson.M{
+			{"$match": ownedBy(r, bson.M{"$or": []bson.M{
+				{"createdAt": bson.M{"$gte": since}},
+				{"completedAt": bson.M{"$gte": since}},
+			}})},
+			{"$project": bson.M{"events": []string{"$createdAt", "$completedAt"}}},
+			{"$unwind": "$events"},
+			{"$match": bson.M{"events": bson.M{"$gte": since}}},
+			{"$group": bson.M{
+				"_id": bson.M{
+					"dayOfWeek": bson.M{"$dayOfWeek": bson.M{"date": "$events", "timezone": tz}},
+					"hour": bson.M{"$hour": bson.M{"date": "$events", "timezone": tz}},
+				},
+				"count": bson.M{"$sum": 1},
+			}},
+		}).All(&groups)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to compute todo heatmap")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo heatmap", err.Error()))
+		return
+	}
+
+	counts := map[[2]int]int{}
+	for _, g := range groups {
+		counts[[2]int{g.ID.DayOfWeek, g.ID.Hour}] = g.Count
+	}
+
+	cells := []HeatmapCell{}
+	for day := 1; day <= 7; day++ {
+		for hour := 0; hour < 24; hour++ {
+			cells = append(cells, HeatmapCell{DayOfWeek: day, Hour: hour, Count: counts[[2]int{day, hour}]})
+		}
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": cells,
+		"days": days,
+		"timezone": tz,
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	idempotencyCollectionName	string = "IdempotencyKey"
+	idempotencyKeyTTL			time.Duration = 24 * time.Hour
+	maxIdempotencyKeyLength		int = 255
+)
+
+// IdempotencyKeyModel holds the response to the first request sent with an
+// Idempotency-Key. ResponseStatus is 0 while that request is in flight.
+type IdempotencyKeyModel struct {
+	ID				bson.ObjectId `bson:"_id,omitempty"`
+	Key				string `bson:"key"`
+	UserID			bson.ObjectId `bson:"userID"`
+	TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+	ResponseStatus	int `bson:"responseStatus"`
+	ContentType		string `bson:"contentType,omitempty"`
+	ResponseBody	[]byte `bson:"responseBody,omitempty"`
+	CreatedAt		time.Time `bson:"createdAt"`
+}
+
+// idempotencyMiddleware runs requests sent with an Idempotency-Key header
+// once per key and user: retries get the stored response without the handler
+// running again, and requests made while the first is still in flight are
+// rejected with 409. Server errors are not stored, so they can be retried.
+func idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The Idempotency-Key must not exceed " + strconv.Itoa(maxIdempotencyKeyLength) + " characters", ""))
+			return
+		}
+
+		c := dbFor(r).C(idempotencyCollectionName)
+		entry := IdempotencyKeyModel{
+			ID: bson.NewObjectId(),
+			Key: key,
+			UserID: currentUserID(r),
+			TenantID: currentTenantID(r),
+			CreatedAt: time.Now(),
+		}
+
+		// The unique index on key and user lets only the first request claim
+		// the key.
+		if err := c.Insert(&entry); err != nil {
+			if !store.IsDup(err) {
+				logFor(r).Error().Err(err).Msg("failed to store idempotency key")
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to store the idempotency key", ""))
+				return
+			}
+
+			replayIdempotentResponse(w, r, key)
+			return
+		}
+
+		body := &bytes.Buffer{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(body)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status >= http.StatusInternalServerError {
+			if err := c.RemoveId(entry.ID); err != nil {
+				logFor(r).Warn().Err(err).Msg("failed to release idempotency key")
+			}
+			return
+		}
+
+		if err := c.UpdateId(entry.ID, bson.M{"$set": bson.M{
+			"responseStatus": status,
+			"contentType": ww.Header().Get("Content-Type"),
+			"responseBody": body.Bytes(),
+		}}); err != nil {
+			logFor(r).Warn().Err(err).Msg("failed to store idempotent response")
+		}
+	})
+}
+
+// replayIdempotentResponse writes the stored response for the key, or a 409
+// when the request that claimed it has not finished.
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, key string) {
+	var entry IdempotencyKeyModel
+
+	if err := timedOp(r.Context(), idempotencyCollectionName + ".find", func() error {
+		return dbFor(r).C(idempotencyCollectionName).Find(ownedBy(r, bson.M{"key": key})).One(&entry)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch idempotency key")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch the idempotency key", ""))
+		return
+	}
+
+	if entry.ResponseStatus == 0 {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "A request with the same Idempotency-Key is in progress", ""))
+		return
+	}
+
+	if entry.ContentType != "" {
+		w.Header().Set("Content-Type", entry.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(entry.ResponseStatus)
+	w.Write(entry.ResponseBody)
+}
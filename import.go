@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// maxImportBytes is the largest accepted import upload.
+const maxImportBytes int64 = 10 << 20
+
+// ImportIssue describes a todo of an import that was skipped, or imported
+// with a warning. Line is only set for CSV imports.
+type ImportIssue struct {
+	Index		int `json:"index"`
+	Line		int `json:"line,omitempty"`
+	Title		string `json:"title,omitempty"`
+	Reason		string `json:"reason"`
+	Errors		[]ValidationError `json:"errors,omitempty"`
+}
+
+// importTodosJSON imports the todos of a JSON array uploaded as the file
+// field of a multipart form. Invalid todos are skipped, and titles repeated
+// within the upload are imported with a warning.
+//
+// @Summary Import todos from a JSON file
+// @Tags todos
+// @Accept mpfd
+// @Produce json
+// @Param file formData file true "A JSON array of todos"
+// @Success 200 {object} object{imported=int,skipped=int,errors=[]ImportIssue,warnings=[]ImportIssue}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 413 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/import [post]
+func importTodosJSON(w http.ResponseWriter, r *http.Request) {
+	file, ok := importFile(w, r)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	var todos []Todo
+
+	if err := json.NewDecoder(file).Decode(&todos); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The file must contain a JSON array of todos", err.Error()))
+		return
+	}
+
+	positions := make([]ImportIssue, len(todos))
+	for i := range todos {
+		positions[i] = ImportIssue{Index: i}
+	}
+
+	saveImport(w, r, todos, positions, []ImportIssue{})
+}
+
+// importFile opens the file field of the multipart upload, limited to
+// maxImportBytes. When it is missing or too large, the error response is
+// written and ok is false.
+func importFile(w http.ResponseWriter, r *http.Request) (multipart.File, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeBodyTooLarge(w, r, maxImportBytes)
+			return nil, false
+		}
+
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The file field is required", err.Error()))
+		return nil, false
+	}
+
+	return file, true
+}
+
+// saveImport validates the imported todos, saves the valid ones and writes
+// the summary response. positions locates each todo in the upload, and errs
+// holds the entries that could not be read at all.
+func saveImport(w http.ResponseWriter, r *http.Request, todos []Todo, positions []ImportIssue, errs []ImportIssue) {
+	position, err := nextPosition(r, nil)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to import todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to import todos", ""))
+		return
+	}
+
+	now := time.Now()
+	var docs []TodoModel
+	warnings := []ImportIssue{}
+	seen := map[string]int{}
+
+	for i, t := range todos {
+		issue := positions[i]
+		issue.Title = t.Title
+
+		if fieldErrs := validateInput(t); len(fieldErrs) > 0 {
+			issue.Reason, issue.Errors = "The todo is invalid", fieldErrs
+			errs = append(errs, issue)
+			continue
+		}
+
+		priority, ok := parsePriority(t.Priority)
+		if !ok {
+			issue.Reason = "The priority is invalid"
+			errs = append(errs, issue)
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(t.Title))
+		if first, ok := seen[key]; ok {
+			issue.Reason = "The title is the same as the todo at index " + strconv.Itoa(first)
+			warnings = append(warnings, issue)
+		} else {
+			seen[key] = issue.Index
+		}
+
+		tm := TodoModel{
+			ID: bson.NewObjectId(),
+			UserID: currentUserID(r),
+			TenantID: currentTenantID(r),
+			Title: t.Title,
+			Completed: t.Completed,
+			Status: completionStatus(TodoModel{}, t.Completed),
+			CreatedAt: now,
+			UpdatedAt: now,
+			DueDate: t.DueDate,
+			Tags: normalizeTags(t.Tags),
+			Priority: priority,
+			PriorityOrder: priorityOrder[priority],
+			Description: t.Description,
+			Position: position,
+		}
+		position += positionSpacing
+		if tm.Completed {
+			tm.CompletedAt = &now
+		}
+
+		docs = append(docs, tm)
+	}
+
+	if len(docs) > 0 {
+		span := startMongoSpan(r, "mongo.insertMany", nil)
+		_, err := insertTodos(r.Context(), docs)
+		endSpan(span, err)
+		if err != nil {
+			logFor(r).Error().Err(err).Msg("failed to import todos")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to import todos", ""))
+			return
+		}
+
+		invalidateTodoCache(r)
+		recordCreated(r, docs)
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"imported": len(docs),
+		"skipped": len(errs),
+		"errors": errs,
+		"warnings": warnings,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// csvDateLayouts are the date formats accepted in CSV imports.
+var csvDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"01/02/2006",
+	"1/2/2006",
+	"02.01.2006",
+}
+
+// importTodosCSV imports the todos of a CSV file uploaded as the file field of
+// a multipart form. The header row names the columns, in any case and order,
+// using the export's column names; ID and CreatedAt are ignored.
+//
+// @Summary Import todos from a CSV file
+// @Tags todos
+// @Accept mpfd
+// @Produce json
+// @Param file formData file true "A CSV file, as exported"
+// @Success 200 {object} object{imported=int,skipped=int,errors=[]ImportIssue,warnings=[]ImportIssue}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 413 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/import/csv [post]
+func importTodosCSV(w http.ResponseWriter, r *http.Request) {
+	file, ok := importFile(w, r)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The file must be a CSV file with a header row", err.Error()))
+		return
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The file must have a Title column", ""))
+		return
+	}
+
+	var todos []Todo
+	var positions []ImportIssue
+	errs := []ImportIssue{}
+
+	for row := 0; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				errs = append(errs, ImportIssue{Index: row, Line: parseErr.Line, Reason: "The row could not be read"})
+				continue
+			}
+
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The file could not be read", err.Error()))
+			return
+		}
+
+		line, _ := cr.FieldPos(0)
+		position := ImportIssue{Index: row, Line: line}
+
+		t, err := csvTodo(record, columns)
+		if err != nil {
+			position.Reason = err.Error()
+			errs = append(errs, position)
+			continue
+		}
+
+		todos = append(todos, t)
+		positions = append(positions, position)
+	}
+
+	saveImport(w, r, todos, positions, errs)
+}
+
+// csvTodo reads a todo from a CSV record.
+func csvTodo(record []string, columns map[string]int) (Todo, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	t := Todo{
+		Title: unescapeCSV(field("title")),
+		Priority: field("priority"),
+		Description: unescapeCSV(field("description")),
+	}
+
+	switch strings.ToLower(field("completed")) {
+	case "", "false", "no", "n", "0":
+	case "true", "yes", "y", "1", "x", "done":
+		t.Completed = true
+	default:
+		return t, errors.New("The Completed column must be true or false")
+	}
+
+	if v := field("duedate"); v != "" {
+		dueDate, ok := parseLenientDate(v)
+		if !ok {
+			return t, errors.New("The DueDate column must be a date")
+		}
+		t.DueDate = &dueDate
+	}
+
+	if v := unescapeCSV(field("tags")); v != "" {
+		t.Tags = strings.FieldsFunc(v, func(c rune) bool { return c == ',' || c == ';' })
+	}
+
+	return t, nil
+}
+
+func parseLenientDate(v string) (time.Time, bool) {
+	for _, layout := range csvDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// unescapeCSV undoes csvSafe, so that exported files import unchanged.
+func unescapeCSV(v string) string {
+	if len(v) > 1 && v[0] == '\'' && strings.ContainsAny(v[1:2], "=+-@") {
+		return v[1:]
+	}
+	return v
+}
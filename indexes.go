@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+)
+
+// indexNotFoundCode is the MongoDB error code for dropping a missing index.
+const indexNotFoundCode int = 27
+
+// todoIndexes are the indexes backing the todo queries.
+var todoIndexes = []store.Index{
+	{Key: []string{"userID", "completed"}, Background: true},
+	{Key: []string{"userID", "title"}, Background: true},
+	{Key: []string{"userID", "listID", "position"}, Background: true},
+	{Key: []string{"createdAt"}, Background: true},
+	{Key: []string{"dueDate"}, Background: true},
+	{Key: []string{"$text:title", "$text:description"}, Background: true},
+	{Key: []string{"tags"}, Sparse: true, Background: true},
+	{Key: []string{"blockedBy"}, Sparse: true, Background: true},
+	{Key: []string{"nextOccurrence"}, Sparse: true, Background: true},
+}
+
+// deliveryIndexes back the delivery worker and the delivery history.
+var deliveryIndexes = []store.Index{
+	{Key: []string{"status", "nextRetryAt"}, Background: true},
+	{Key: []string{"webhookID", "-createdAt"}, Background: true},
+}
+
+// auditIndexes back the todo history.
+var auditIndexes = []store.Index{
+	{Key: []string{"todoID", "-_id"}, Background: true},
+}
+
+// viewIndexes back the recently viewed todos; each user has one view per
+// todo.
+var viewIndexes = []store.Index{
+	{Key: []string{"userID", "todoID"}, Unique: true, Background: true},
+	{Key: []string{"userID", "-viewedAt"}, Background: true},
+}
+
+// shareIndexes back the todos shared with each user; a todo is shared once
+// with each recipient.
+var shareIndexes = []store.Index{
+	{Key: []string{"todoID", "recipientID"}, Unique: true, Background: true},
+	{Key: []string{"recipientID"}, Background: true},
+}
+
+// tenantIndexes keep tenant slugs, which select tenants by subdomain, unique.
+var tenantIndexes = []store.Index{
+	{Key: []string{"slug"}, Unique: true, Background: true},
+}
+
+// dataExportIndexes back looking up the exports of each user.
+var dataExportIndexes = []store.Index{
+	{Key: []string{"userID"}, Background: true},
+}
+
+// idempotencyIndexes claim each key once per user, and drop the keys after
+// idempotencyKeyTTL.
+var idempotencyIndexes = []store.Index{
+	{Key: []string{"key", "userID"}, Unique: true, Background: true},
+	{Key: []string{"createdAt"}, ExpireAfter: idempotencyKeyTTL, Background: true},
+}
+
+// commentIndexes back the comment threads and comment counts of todos.
+var commentIndexes = []store.Index{
+	{Key: []string{"todoID", "createdAt"}, Background: true},
+}
+
+// notificationIndexes back listing each user's notifications, unread first.
+var notificationIndexes = []store.Index{
+	{Key: []string{"recipientID", "read", "-createdAt"}, Background: true},
+}
+
+// userIndexes back logging in and resolving @mentions.
+var userIndexes = []store.Index{
+	{Key: []string{"email"}, Background: true},
+	{Key: []string{"username"}, Sparse: true, Background: true},
+}
+
+// preferencesIndexes keep one preferences document per user.
+var preferencesIndexes = []store.Index{
+	{Key: []string{"userID"}, Unique: true, Background: true},
+}
+
+// refreshTokenIndexes look refresh tokens up by their value, which is unique.
+var refreshTokenIndexes = []store.Index{
+	{Key: []string{"token"}, Unique: true, Background: true},
+}
+
+// apiKeyIndexes look API keys up by their value, which is unique.
+var apiKeyIndexes = []store.Index{
+	{Key: []string{"key"}, Unique: true, Background: true},
+}
+
+// userEmailIndex keeps the emails of the accounts not deleted unique within
+// each tenant, so that concurrent registrations cannot both take an email.
+// It was added by the fourth migration.
+var userEmailIndex = store.Index{
+	Key: []string{"tenantID", "email"},
+	Unique: true,
+	PartialFilter: bson.M{"deletedAt": nil},
+	Background: true,
+}
+
+// featureFlagIndex keeps flag names unique, and backs looking flags up by
+// name. It was added by the second migration.
+var featureFlagIndex = store.Index{Key: []string{"name"}, Unique: true, Background: true}
+
+// collectionIndexes maps each collection to the indexes its queries rely on,
+// as created by the first migration. Indexes added or changed later have
+// migrations of their own.
+func collectionIndexes() map[string][]store.Index {
+	return map[string][]store.Index{
+		cfg.CollectionName: todoIndexes,
+		deliveryCollectionName: deliveryIndexes,
+		auditCollectionName: auditIndexes,
+		viewCollectionName: viewIndexes,
+		shareCollectionName: shareIndexes,
+		tenantCollectionName: tenantIndexes,
+		dataExportCollectionName: dataExportIndexes,
+		idempotencyCollectionName: idempotencyIndexes,
+		commentCollectionName: commentIndexes,
+		notificationCollectionName: notificationIndexes,
+		userCollectionName: userIndexes,
+		preferencesCollectionName: preferencesIndexes,
+	}
+}
+
+// authIndexes are the indexes added by the third migration.
+func authIndexes() map[string][]store.Index {
+	return map[string][]store.Index{
+		refreshTokenCollectionName: refreshTokenIndexes,
+		apiKeyCollectionName: apiKeyIndexes,
+	}
+}
+
+// createIndexes creates the indexes of collectionIndexes, keeping those that
+// already exist.
+func createIndexes(db *store.Database) error {
+	for name, indexes := range collectionIndexes() {
+		for _, index := range indexes {
+			ensureIndex(db, name, index)
+		}
+	}
+
+	return nil
+}
+
+// ensureIndex creates the index on the collection. A failure only makes the
+// queries using it slower, so it is logged rather than failing the migration
+// and stopping startup.
+func ensureIndex(db *store.Database, name string, index store.Index) {
+	if err := db.C(name).EnsureIndex(index); err != nil {
+		log.Warn().Err(err).Str("collection", name).Strs("key", index.Key).Msg("failed to create index")
+	}
+}
+
+// dropIndexes drops the indexes of collectionIndexes that exist.
+func dropIndexes(db *store.Database) error {
+	for name, indexes := range collectionIndexes() {
+		for _, index := range indexes {
+			if err := dropIndex(db, name, index); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropIndex drops the index from the collection if it exists.
+func dropIndex(db *store.Database, name string, index store.Index) error {
+	err := db.C(name).DropIndex(index.Key...)
+	if qe, ok := err.(*store.QueryError); ok && qe.Code == indexNotFoundCode {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to drop index %v on %s: %w", index.Key, name, err)
+	}
+
+	return nil
+}
+
+// createFeatureFlagIndex is the second migration.
+func createFeatureFlagIndex(db *store.Database) error {
+	ensureIndex(db, featureFlagCollectionName, featureFlagIndex)
+	return nil
+}
+
+// dropFeatureFlagIndex drops featureFlagIndex if it exists.
+func dropFeatureFlagIndex(db *store.Database) error {
+	return dropIndex(db, featureFlagCollectionName, featureFlagIndex)
+}
+
+// createAuthIndexes is the third migration.
+func createAuthIndexes(db *store.Database) error {
+	for name, indexes := range authIndexes() {
+		for _, index := range indexes {
+			ensureIndex(db, name, index)
+		}
+	}
+
+	return nil
+}
+
+// dropAuthIndexes drops the indexes of authIndexes that exist.
+func dropAuthIndexes(db *store.Database) error {
+	for name, indexes := range authIndexes() {
+		for _, index := range indexes {
+			if err := dropIndex(db, name, index); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// createUserEmailIndex is the fourth migration. Emails already registered
+// twice make it fail, which is logged like for the other indexes.
+func createUserEmailIndex(db *store.Database) error {
+	ensureIndex(db, userCollectionName, userEmailIndex)
+	return nil
+}
+
+// dropUserEmailIndex drops userEmailIndex if it exists.
+func dropUserEmailIndex(db *store.Database) error {
+	return dropIndex(db, userCollectionName, userEmailIndex)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const jsonAPIContentType = "application/vnd.api+json"
+
+// jsonAPIMiddleware rewrites the JSON responses of clients accepting
+// application/vnd.api+json into JSON:API 1.0 documents whose resources have
+// the given type. Other clients get the plain JSON responses.
+func jsonAPIMiddleware(resourceType string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.Header.Get("Accept"), jsonAPIContentType) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &jsonAPIRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			body := rec.body.Bytes()
+			contentType := w.Header().Get("Content-Type")
+
+			var doc map[string]interface{}
+			if (strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, utils.ProblemContentType)) &&
+				json.Unmarshal(body, &doc) == nil {
+				if converted, err := json.Marshal(toJSONAPI(doc, resourceType, rec.status)); err == nil {
+					body = converted
+					w.Header().Set("Content-Type", jsonAPIContentType)
+					w.Header().Del("Content-Length")
+				}
+			}
+
+			w.WriteHeader(rec.status)
+			w.Write(body)
+		})
+	}
+}
+
+// toJSONAPI converts a response document. Errors become a JSON:API error,
+// each object in data with an id becomes a resource, and the other top-level
+// members, such as the pagination, move to meta. Payloads that are not
+// resources are kept whole in meta.
+func toJSONAPI(doc map[string]interface{}, resourceType string, status int) map[string]interface{} {
+	if status >= http.StatusBadRequest {
+		apiErr := map[string]interface{}{"status": strconv.Itoa(status)}
+		if title, ok := doc["title"]; ok {
+			apiErr["title"] = title
+		}
+		if detail, ok := doc["detail"]; ok {
+			apiErr["detail"] = detail
+		}
+		return map[string]interface{}{"errors": []interface{}{apiErr}}
+	}
+
+	data, hasData := doc["data"]
+	if !hasData {
+		return map[string]interface{}{"meta": doc}
+	}
+
+	meta := map[string]interface{}{}
+	for k, v := range doc {
+		if k != "data" {
+			meta[k] = v
+		}
+	}
+
+	var converted interface{}
+	switch data := data.(type) {
+	case []interface{}:
+		resources := []interface{}{}
+		for _, item := range data {
+			resource, ok := toResource(item, resourceType)
+			if !ok {
+				return map[string]interface{}{"meta": doc}
+			}
+			resources = append(resources, resource)
+		}
+		converted = resources
+	case nil:
+		converted = []interface{}{}
+	default:
+		resource, ok := toResource(data, resourceType)
+		if !ok {
+			return map[string]interface{}{"meta": doc}
+		}
+		converted = resource
+	}
+
+	out := map[string]interface{}{"data": converted}
+	if len(meta) > 0 {
+		out["meta"] = meta
+	}
+	return out
+}
+
+// toResource turns a JSON object with an id into a resource object. A todo's
+// listId becomes its list relationship.
+func toResource(item interface{}, resourceType string) (map[string]interface{}, bool) {
+	obj, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	id, ok := obj["id"].(string)
+	if !ok {
+		return nil, false
+	}
+
+	attributes := map[string]interface{}{}
+	relationships := map[string]interface{}{}
+
+	for k, v := range obj {
+		switch k {
+		case "id":
+		case "listId":
+			relationships["list"] = map[string]interface{}{
+				"data": map[string]interface{}{"type": "lists", "id": v},
+			}
+		default:
+			attributes[k] = v
+		}
+	}
+
+	resource := map[string]interface{}{
+		"type": resourceType,
+		"id": id,
+		"attributes": attributes,
+	}
+	if len(relationships) > 0 {
+		resource["relationships"] = relationships
+	}
+
+	return resource, true
+}
+
+// jsonAPIRecorder holds back the response so it can be converted.
+type jsonAPIRecorder struct {
+	http.ResponseWriter
+	status		int
+	body		bytes.Buffer
+	wroteHeader	bool
+}
+
+func (j *jsonAPIRecorder) WriteHeader(code int) {
+	if !j.wroteHeader {
+		j.status = code
+		j.wroteHeader = true
+	}
+}
+
+func (j *jsonAPIRecorder) Write(p []byte) (int, error) {
+	j.wroteHeader = true
+	return j.body.Write(p)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const listCollectionName string = "List"
+
+var listColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type(
+	ListModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Name			string `bson:"name"`
+		Color			string `bson:"color"`
+		CreatedAt		time.Time `bson:"createdAt"`
+	}
+
+	List struct {
+		ID				string `json:"id"`
+		Name			string `json:"name" validate:"required,max=100"`
+		Color			string `json:"color"`
+		CreatedAt		time.Time `json:"createdAt"`
+	}
+)
+
+func toList(l ListModel) List {
+	return List{
+		ID: l.ID.Hex(),
+		Name: l.Name,
+		Color: l.Color,
+		CreatedAt: l.CreatedAt,
+	}
+}
+
+// @Summary List the lists
+// @Tags lists
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=[]List}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /lists [get]
+func fetchLists(w http.ResponseWriter, r *http.Request) {
+	var lists []ListModel
+
+	if err := timedOp(r.Context(), listCollectionName + ".find", func() error {
+		return dbFor(r).C(listCollectionName).Find(ownedBy(r, bson.M{})).Sort("name").All(&lists)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch lists")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch lists", err.Error()))
+		return
+	}
+
+	listList := []List{}
+	for _, l := range lists {
+		listList = append(listList, toList(l))
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": listList,
+	})
+}
+
+// @Summary Get a list
+// @Tags lists
+// @Produce json
+// @Param listId path string true "The list id"
+// @Success 200 {object} DataEnvelope{data=List}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /lists/{listId} [get]
+func getList(w http.ResponseWriter, r *http.Request) {
+	list, ok := findList(w, r, chi.URLParam(r, "listId"))
+	if !ok {
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toList(list),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// decodeList reads and validates the list in the request body. When it is
+// invalid, the error response is written and ok is false.
+func decodeList(w http.ResponseWriter, r *http.Request) (l List, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return l, false
+	}
+
+	l.Name = strings.TrimSpace(l.Name)
+	if !checkInput(w, r, l) {
+		return l, false
+	}
+
+	if l.Color != "" && !listColorPattern.MatchString(l.Color) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The color must be a hex color such as #1e90ff", ""))
+		return l, false
+	}
+
+	return l, true
+}
+
+// @Summary Create a list
+// @Tags lists
+// @Accept json
+// @Produce json
+// @Param body body List true "The list"
+// @Success 201 {object} DataEnvelope{data=List}
+// @Failure 401 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /lists [post]
+func createList(w http.ResponseWriter, r *http.Request) {
+	l, ok := decodeList(w, r)
+	if !ok {
+		return
+	}
+
+	list := ListModel{
+		ID: bson.NewObjectId(),
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		Name: l.Name,
+		Color: l.Color,
+		CreatedAt: time.Now(),
+	}
+
+	if err := timedOp(r.Context(), listCollectionName + ".insert", func() error {
+		return dbFor(r).C(listCollectionName).Insert(&list)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save list")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save list", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": toList(list),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Replace a list
+// @Tags lists
+// @Accept json
+// @Produce json
+// @Param listId path string true "The list id"
+// @Param body body List true "The list"
+// @Success 200 {object} DataEnvelope{data=List}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /lists/{listId} [put]
+func updateList(w http.ResponseWriter, r *http.Request) {
+	list, ok := findList(w, r, chi.URLParam(r, "listId"))
+	if !ok {
+		return
+	}
+
+	l, ok := decodeList(w, r)
+	if !ok {
+		return
+	}
+
+	list.Name, list.Color = l.Name, l.Color
+
+	if err := timedOp(r.Context(), listCollectionName + ".update", func() error {
+		return dbFor(r).C(listCollectionName).UpdateId(list.ID, bson.M{
+			"$set": bson.M{"name": list.Name, "color": list.Color},
+		})
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to update list")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update list", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toList(list),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// deleteList removes the list. Its todos are kept and no longer belong to any
+// list.
+//
+// @Summary Delete a list
+// @Tags lists
+// @Produce json
+// @Param listId path string true "The list id"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /lists/{listId} [delete]
+func deleteList(w http.ResponseWriter, r *http.Request) {
+	list, ok := findList(w, r, chi.URLParam(r, "listId"))
+	if !ok {
+		return
+	}
+
+	if _, err := updateAllTodos(r, bson.M{"listID": list.ID}, bson.M{
+		"$unset": bson.M{"listID": ""},
+		"$set": bson.M{"updatedAt": time.Now()},
+		"$inc": bson.M{"__v": 1},
+	}, "moved"); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to detach todos from list")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete list", ""))
+		return
+	}
+
+	if err := timedOp(r.Context(), listCollectionName + ".remove", func() error {
+		return dbFor(r).C(listCollectionName).RemoveId(list.ID)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to delete list")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete list", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "List deleted successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// moveTodo moves the todo identified by {id} into the list identified by
+// {listId}.
+//
+// @Summary Move a todo to a list
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param listId path string true "The list id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/move/{listId} [post]
+func moveTodo(w http.ResponseWriter, r *http.Request) {
+	// Lists belong to one user, so only the owner moves the todo between them.
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	list, ok := findList(w, r, chi.URLParam(r, "listId"))
+	if !ok {
+		return
+	}
+
+	// The todo goes to the end of its new list.
+	position, err := nextPosition(r, &list.ID)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to move todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to move todo", ""))
+		return
+	}
+
+	applyTodoUpdate(w, r, "moved", bson.M{"_id": todo.ID}, bson.M{
+		"$set": bson.M{"listID": list.ID, "position": position},
+	})
+}
+
+// findList loads the user's list with the given id. Lists of other users are
+// reported as not found, so todos can only be attached to the user's own
+// lists. When no list matches, the error response is written and ok is false.
+func findList(w http.ResponseWriter, r *http.Request, id string) (list ListModel, ok bool) {
+	id = strings.TrimSpace(id)
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The list id is invalid", ""))
+		return list, false
+	}
+
+	if err := timedOp(r.Context(), listCollectionName + ".find", func() error {
+		return dbFor(r).C(listCollectionName).Find(ownedBy(r, bson.M{"_id": bson.ObjectIdHex(id)})).One(&list)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "List not found", ""))
+			return list, false
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch list")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch list", err.Error()))
+		return list, false
+	}
+
+	return list, true
+}
+
+func listHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(authMiddleware)
+	rg.Use(jsonAPIMiddleware("lists"))
+
+	rg.Group(func(r chi.Router) {
+		r.Get("/", fetchLists)
+		r.Post("/", createList)
+		r.Get("/{listId}", getList)
+		r.Put("/{listId}", updateList)
+		r.Delete("/{listId}", deleteList)
+		r.Get("/{listId}/burndown", fetchBurndown)
+		r.Get("/{listId}/velocity", fetchVelocity)
+	})
+
+	return rg
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// setupLogging configures the global logger for the given level name.
+func setupLogging(levelName string) {
+	level, err := zerolog.ParseLevel(levelName)
+	if err != nil || level == zerolog.NoLevel {
+		level = zerolog.InfoLevel
+	}
+
+	zerolog.SetGlobalLevel(level)
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	zerolog.DefaultContextLogger = &log.Logger
+}
+
+// requestLogger writes a structured log entry for every request. Handlers get
+// a logger carrying the request id through logFor.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logger := log.With().Str("requestId", utils.GetRequestID(r.Context())).Logger()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(logger.WithContext(r.Context())))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		logger.Info().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Int("statusCode", status).
+			Float64("latencyMs", float64(time.Since(start).Microseconds())/1000).
+			Str("userAgent", r.UserAgent()).
+			Msg("request")
+	})
+}
+
+// maxLoggedBodySize caps how much of each body bodyLoggingMiddleware keeps.
+const maxLoggedBodySize int = 4096
+
+// maskedFields are the JSON fields whose values are never logged.
+var maskedFields = map[string]bool{"password": true, "token": true, "refreshToken": true}
+
+// bodyLoggingMiddleware logs the request and response bodies at debug level,
+// with secrets masked. It does nothing unless debug logging is enabled.
+func bodyLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if zerolog.GlobalLevel() > zerolog.DebugLevel {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		reqBody := &cappedBuffer{}
+		respBody := &cappedBuffer{}
+
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(respBody)
+
+		next.ServeHTTP(ww, r)
+
+		logFor(r).Debug().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			RawJSON("requestBody", reqBody.masked()).
+			RawJSON("responseBody", respBody.masked()).
+			Int("statusCode", ww.Status()).
+			Float64("latencyMs", float64(time.Since(start).Microseconds())/1000).
+			Msg("request body")
+	})
+}
+
+// cappedBuffer keeps the first maxLoggedBodySize bytes written to it.
+type cappedBuffer struct {
+	buf			[]byte
+	truncated	bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if n := maxLoggedBodySize - len(b.buf); n < len(p) {
+		b.buf = append(b.buf, p[:n]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+
+	return len(p), nil
+}
+
+// masked returns the body as JSON with the masked fields replaced. Bodies that
+// are not complete JSON documents cannot be masked, so they are described
+// rather than logged.
+func (b *cappedBuffer) masked() []byte {
+	if len(b.buf) == 0 {
+		return []byte("null")
+	}
+
+	var v interface{}
+	if b.truncated || json.Unmarshal(b.buf, &v) != nil {
+		desc, _ := json.Marshal(map[string]interface{}{"omitted": true, "bytes": len(b.buf), "truncated": b.truncated})
+		return desc
+	}
+
+	masked, err := json.Marshal(maskFields(v))
+	if err != nil {
+		return []byte("null")
+	}
+	return masked
+}
+
+func maskFields(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, field := range v {
+			if maskedFields[k] {
+				v[k] = "***"
+			} else {
+				v[k] = maskFields(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = maskFields(item)
+		}
+	}
+
+	return v
+}
+
+// logFor returns the logger of the request r belongs to.
+func logFor(r *http.Request) *zerolog.Logger {
+	return zerolog.Ctx(r.Context())
+}
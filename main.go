@@ -2,253 +2,2304 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 	"context"
+	"crypto/tls"
 	"os"
 	"os/signal"
+	"regexp"
+	"syscall"
 
+	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog/log"
+	httpSwagger "github.com/swaggo/http-swagger/v2"
 	"github.com/thedevsaddam/renderer"
-	mgo "gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	_ "github.com/nkpremices/go-chi-mongodb-simple-todo/docs"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/config"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/notifications"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
 	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
 )
 
 var rnd *renderer.Render
-var db *mgo.Database
+var sess *store.Session
+var db *store.Database
+var cfg config.Config
 
 const (
-	hostName				string = "localhost:27017"
-	dbName					string = "demo_todo"
-	collectionName			string = "Todo"
-	port					string = ":9000"
+	defaultConfigFile		string = "config.yaml"
+	defaultPageLimit		int = 20
+	maxPageLimit			int = 100
+	maxBatchSize			int = 500
+	maxPinnedTodos			int = 10
+	defaultPriority			string = "medium"
+	maxDescriptionLength	int = 4000
+	minSearchLength			int = 2
+	autocompleteLimit		int = 10
 )
 
+// priorityOrder ranks each allowed priority, most urgent first. The rank is
+// stored alongside the priority so that sorting by it can use an index.
+var priorityOrder = map[string]int{
+	"critical": 0,
+	"high": 1,
+	"medium": 2,
+	"low": 3,
+}
+
+var priorities = []string{"low", "medium", "high", "critical"}
+
 type(
 	TodoModel struct {
-		ID				bson.ObjectId `bson:"_id,omitempty"`
-		Title			string `bson:"title"`
-		Completed		bool `bson:"completed"`
-		CreatedAt		time.Time `bson:"createdAt"`
+		ID				bson.ObjectId `bson:"_id,omitempty" msgpack:"_id,omitempty"`
+		UserID			bson.ObjectId `bson:"userID" msgpack:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty" msgpack:"tenantID,omitempty"`
+		Title			string `bson:"title" msgpack:"title"`
+		Completed		bool `bson:"completed" msgpack:"completed"`
+		CreatedAt		time.Time `bson:"createdAt" msgpack:"createdAt"`
+		Archived		bool `bson:"archived" msgpack:"archived"`
+		ArchivedAt		time.Time `bson:"archivedAt,omitempty" msgpack:"archivedAt,omitempty"`
+		DueDate			*time.Time `bson:"dueDate,omitempty" msgpack:"dueDate,omitempty"`
+		Tags			[]string `bson:"tags" msgpack:"tags"`
+		Priority		string `bson:"priority" msgpack:"priority"`
+		PriorityOrder	int `bson:"priorityOrder" msgpack:"priorityOrder"`
+		Description		string `bson:"description" msgpack:"description"`
+		Subtasks		[]SubtaskModel `bson:"subtasks" msgpack:"subtasks"`
+		UpdatedAt		time.Time `bson:"updatedAt" msgpack:"updatedAt"`
+		CompletedAt		*time.Time `bson:"completedAt,omitempty" msgpack:"completedAt,omitempty"`
+		ListID			*bson.ObjectId `bson:"listID,omitempty" msgpack:"listID,omitempty"`
+		Score			float64 `bson:"score,omitempty" msgpack:"score,omitempty"`
+		// Version is bumped by every update, so that updateTodo can detect
+		// concurrent changes. Todos saved before it was added have none,
+		// which counts as version 0.
+		Version			int64 `bson:"__v" msgpack:"__v"`
+		Position		float64 `bson:"position" msgpack:"position"`
+		Pinned			bool `bson:"pinned" msgpack:"pinned"`
+		PinnedAt		*time.Time `bson:"pinnedAt,omitempty" msgpack:"pinnedAt,omitempty"`
+		FromTemplate	*bson.ObjectId `bson:"fromTemplate,omitempty" msgpack:"fromTemplate,omitempty"`
+		BlockedBy		[]bson.ObjectId `bson:"blockedBy,omitempty" msgpack:"blockedBy,omitempty"`
+		// Status is one of statuses; completed is stored alongside it, true
+		// when the status is done.
+		Status			string `bson:"status,omitempty" msgpack:"status,omitempty"`
+		EstimatedMinutes	*int `bson:"estimatedMinutes,omitempty" msgpack:"estimatedMinutes,omitempty"`
+		ActualMinutes	*int `bson:"actualMinutes,omitempty" msgpack:"actualMinutes,omitempty"`
+		TimerStartedAt	*time.Time `bson:"timerStartedAt,omitempty" msgpack:"timerStartedAt,omitempty"`
+		Recurrence		string `bson:"recurrence,omitempty" msgpack:"recurrence,omitempty"`
+		NextOccurrence	*time.Time `bson:"nextOccurrence,omitempty" msgpack:"nextOccurrence,omitempty"`
+	}
+
+	SubtaskModel struct {
+		ID				bson.ObjectId `bson:"_id" msgpack:"_id"`
+		Title			string `bson:"title" msgpack:"title"`
+		Completed		bool `bson:"completed" msgpack:"completed"`
+	}
+
+	Todo struct {
+		ID				string `json:"id" msgpack:"id"`
+		Title			string `json:"title" msgpack:"title" validate:"required,max=200"`
+	    Completed		bool `json:"completed" msgpack:"completed"`
+		CreatedAt		time.Time `json:"createdAt" msgpack:"createdAt"`
+		// CreatedAtLocal is createdAt in the user's time zone.
+		CreatedAtLocal	string `json:"createdAtLocal,omitempty" msgpack:"createdAtLocal,omitempty"`
+		ArchivedAt		*time.Time `json:"archivedAt,omitempty" msgpack:"archivedAt,omitempty"`
+		DueDate			*time.Time `json:"dueDate,omitempty" msgpack:"dueDate,omitempty"`
+		Tags			[]string `json:"tags" msgpack:"tags"`
+		Priority		string `json:"priority" msgpack:"priority"`
+		Description		string `json:"description,omitempty" msgpack:"description,omitempty" validate:"max=4000"`
+		Subtasks		[]Subtask `json:"subtasks" msgpack:"subtasks"`
+		SubtaskProgress	int `json:"subtaskProgress" msgpack:"subtaskProgress"`
+		UpdatedAt		time.Time `json:"updatedAt" msgpack:"updatedAt"`
+		CompletedAt		*time.Time `json:"completedAt,omitempty" msgpack:"completedAt,omitempty"`
+		ListID			string `json:"listId,omitempty" msgpack:"listId,omitempty"`
+		Score			float64 `json:"score,omitempty" msgpack:"score,omitempty"`
+		Version			int64 `json:"version" msgpack:"version"`
+		Position		float64 `json:"position" msgpack:"position"`
+		Pinned			bool `json:"pinned" msgpack:"pinned"`
+		PinnedAt		*time.Time `json:"pinnedAt,omitempty" msgpack:"pinnedAt,omitempty"`
+		FromTemplate	string `json:"fromTemplate,omitempty" msgpack:"fromTemplate,omitempty"`
+		BlockedBy		[]string `json:"blockedBy,omitempty" msgpack:"blockedBy,omitempty"`
+		IsBlocked		bool `json:"isBlocked" msgpack:"isBlocked"`
+		Status			string `json:"status" msgpack:"status"`
+		EstimatedMinutes	*int `json:"estimatedMinutes,omitempty" msgpack:"estimatedMinutes,omitempty" validate:"omitempty,min=0"`
+		ActualMinutes	*int `json:"actualMinutes,omitempty" msgpack:"actualMinutes,omitempty" validate:"omitempty,min=0"`
+		TimerRunning	bool `json:"timerRunning" msgpack:"timerRunning"`
+		Recurrence		string `json:"recurrence,omitempty" msgpack:"recurrence,omitempty"`
+		NextOccurrence	*time.Time `json:"nextOccurrence,omitempty" msgpack:"nextOccurrence,omitempty"`
+		// Permission is only set on the todos shared with the user.
+		Permission		string `json:"permission,omitempty" msgpack:"permission,omitempty"`
+		CommentCount	int `json:"commentCount" msgpack:"commentCount"`
+		// OriginalTitle is only set in the response to the request that
+		// created or updated the todo, when hashtags were moved out of the
+		// title into the tags.
+		OriginalTitle	string `json:"originalTitle,omitempty" msgpack:"originalTitle,omitempty"`
+	}
+
+	TodoSuggestion struct {
+		ID				string `json:"id"`
+		Title			string `json:"title"`
+	}
+
+	Subtask struct {
+		ID				string `json:"id" msgpack:"id"`
+		Title			string `json:"title" msgpack:"title" validate:"required,max=200"`
+		Completed		bool `json:"completed" msgpack:"completed"`
+	}
+
+	BatchResult struct {
+		Index			int `json:"index"`
+		Status			string `json:"status"`
+		ID				string `json:"id,omitempty"`
+		Reason			string `json:"reason,omitempty"`
+		Errors			[]ValidationError `json:"errors,omitempty"`
+	}
+)
+
+func init() {
+	rnd = renderer.New()
+}
+
+// connect opens the MongoDB session described by cfg.
+func connect() {
+	var err error
+	sess, err = store.DialWithRetry(store.DialInfo{
+		HostName: cfg.HostName,
+		Timeout: cfg.MongoTimeout,
+		SocketTimeout: cfg.MongoSocketTimeout,
+		PoolLimit: cfg.MongoPoolLimit,
+	}, cfg.MongoMaxRetries)
+	if err != nil {
+		log.Fatal().Err(err).Str("host", cfg.HostName).Msg("giving up connecting to MongoDB")
+	}
+	log.Info().Str("host", cfg.HostName).Msg("connected to MongoDB")
+
+	db = sess.DB(cfg.DBName)
+}
+
+// dbFor returns the database for the operations of the request, which stop
+// when it is canceled.
+func dbFor(r *http.Request) *store.Database {
+	return db.WithContext(r.Context())
+}
+
+// @Summary Home page
+// @Tags pages
+// @Produce html
+// @Success 200 {string} string "The HTML page"
+// @Router / [get]
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	err := rnd.Template(w, http.StatusOK, []string{"static/home.tpl"}, nil)
+	utils.CheckErr(err)
+}
+
+// fetchTodos lists the todos the user can see. The user's preferences fill in
+// the sort, page size and filters the request leaves out. With Redis, pages
+// are cached until the user changes a todo, or for todoCacheTTL.
+//
+// @Summary List todos
+// @Tags todos
+// @Produce json
+// @Description Lists the user's todos and those shared with them, pinned first. Pages are resumed from nextCursor, or selected by offset with page, in which case the response is a PageEnvelope.
+// @Param cursor query string false "The nextCursor of the previous page"
+// @Param page query int false "The page, to page by offset instead"
+// @Param limit query int false "The page size, at most 100"
+// @Param sort query string false "Comma separated fields, descending when prefixed with -, or score"
+// @Param completed query bool false "Only completed, or only open, todos"
+// @Param listId query string false "Only the todos of the list"
+// @Param tags query string false "Comma separated tags the todos have"
+// @Param tagMatch query string false "all, the default, or any of the tags"
+// @Param priority query string false "Only the todos of the priority"
+// @Param completedAfter query string false "Completed after the date or time"
+// @Param completedBefore query string false "Completed before the date or time"
+// @Param createdAfter query string false "Created after the date or time"
+// @Param createdBefore query string false "Created before the date or time"
+// @Param overdue query bool false "Only overdue, or only not overdue, todos"
+// @Param fields query string false "Comma separated fields of the todos to return"
+// @Success 200 {object} CursorEnvelope{data=[]Todo}
+// @Success 304 "Not Modified"
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo [get]
+func fetchTodos(w http.ResponseWriter, r *http.Request) {
+	r = withPreferredDefaults(r)
+
+	cacheKey := todoCacheKey(r)
+	if cached, ok := cachedTodoPage(r, cacheKey); ok {
+		rnd.JSON(w, http.StatusOK, cached)
+		return
+	}
+
+	filter, err := todoFilter(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return
+	}
+
+	if r.URL.Query().Get("sort") == "score" {
+		fetchScoredTodos(w, r, filter)
+		return
+	}
+
+	sort, err := todoSort(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return
+	}
+
+	// Pinned todos come first, the most recently pinned at the top. Others
+	// have no pinnedAt, which sorts last.
+	sort = append([]string{"-pinnedAt"}, sort...)
+
+	filter, err = visibleTo(r, filter)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return
+	}
+
+	load := loadTodoCursorPage
+	if r.URL.Query().Get("page") != "" {
+		if r.URL.Query().Get("cursor") != "" {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The page and cursor parameters cannot be combined", ""))
+			return
+		}
+		load = loadTodoPage
+	}
+
+	page, ok := load(w, r, filter, sort...)
+	if !ok {
+		return
+	}
+
+	cacheTodoPage(r, cacheKey, page)
+	rnd.JSON(w, http.StatusOK, page)
+}
+
+// fetchScoredTodos lists the todos matching filter ranked by todoScore, paged
+// by offset, as the scores cannot be resumed from a cursor.
+func fetchScoredTodos(w http.ResponseWriter, r *http.Request, filter bson.M) {
+	if r.URL.Query().Get("cursor") != "" {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The cursor parameter cannot be combined with sort=score", ""))
+		return
+	}
+
+	filter, err := visibleTo(r, filter)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return
+	}
+
+	page, ok := loadScoredTodoPage(w, r, filter)
+	if !ok {
+		return
+	}
+
+	cacheTodoPage(r, todoCacheKey(r), page)
+	rnd.JSON(w, http.StatusOK, page)
+}
+
+// @Summary Search todos
+// @Tags todos
+// @Produce json
+// @Param q query string true "The words to search for"
+// @Param page query int false "The page, from 1"
+// @Param limit query int false "The page size, at most 100"
+// @Success 200 {object} PageEnvelope{data=[]Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/search [get]
+func searchTodos(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if utf8.RuneCountInString(q) < minSearchLength {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The search query must be at least " + strconv.Itoa(minSearchLength) + " characters", ""))
+		return
+	}
+
+	filter, err := todoFilter(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return
+	}
+
+	filter["$text"] = bson.M{"$search": q}
+
+	page, ok := loadProjectedTodoPage(w, r, ownedBy(r, filter), bson.M{
+		"score": bson.M{"$meta": "textScore"},
+	}, "$textScore:score")
+	if !ok {
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, page)
+}
+
+// @Summary Suggest todo titles
+// @Tags todos
+// @Produce json
+// @Param q query string true "The start of the title"
+// @Success 200 {object} DataEnvelope{data=[]TodoSuggestion}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 429 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/autocomplete [get]
+func autocompleteTodos(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The search query is required", ""))
+		return
+	}
+
+	var todos []TodoModel
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{
+			"title": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"},
+			"archived": bson.M{"$ne": true},
+		})).Select(bson.M{"title": 1}).Limit(autocompleteLimit).All(&todos)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return
+	}
+
+	suggestions := []TodoSuggestion{}
+	for _, t := range todos {
+		suggestions = append(suggestions, TodoSuggestion{ID: t.ID.Hex(), Title: t.Title})
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": suggestions,
+	})
+}
+
+// @Summary List deleted todos
+// @Tags todos
+// @Produce json
+// @Param page query int false "The page, from 1"
+// @Param limit query int false "The page size, at most 100"
+// @Success 200 {object} PageEnvelope{data=[]Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/archived [get]
+func fetchArchivedTodos(w http.ResponseWriter, r *http.Request) {
+	page, ok := loadTodoPage(w, r, ownedBy(r, bson.M{"archived": true}))
+	if !ok {
+		return
+	}
+
+	rnd.JSON(w, http.StatusOK, page)
+}
+
+// @Summary List overdue todos
+// @Tags todos
+// @Produce json
+// @Param page query int false "The page, from 1"
+// @Param limit query int false "The page size, at most 100"
+// @Success 200 {object} PageEnvelope{data=[]Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/overdue [get]
+func fetchOverdueTodos(w http.ResponseWriter, r *http.Request) {
+	page, ok := loadTodoPage(w, r, ownedBy(r, bson.M{
+		"dueDate": bson.M{"$lte": time.Now()},
+		"completed": false,
+		"archived": bson.M{"$ne": true},
+	}), "dueDate")
+	if !ok {
+		return
+	}
+
+	page["count"] = page["total"]
+
+	rnd.JSON(w, http.StatusOK, page)
+}
+
+// loadTodoPage fetches the page of the todos matching filter, which limits them
+// to those the user can see, selected by the request's page and limit query
+// parameters, ordered by the given sort fields.
+// It returns the response envelope, or writes the error response and returns
+// false.
+func loadTodoPage(w http.ResponseWriter, r *http.Request, filter bson.M, sort ...string) (renderer.M, bool) {
+	return loadProjectedTodoPage(w, r, filter, nil, sort...)
+}
+
+// loadProjectedTodoPage is loadTodoPage with extra fields, such as a text
+// search score, projected into each todo.
+func loadProjectedTodoPage(w http.ResponseWriter, r *http.Request, filter, projection bson.M, sort ...string) (renderer.M, bool) {
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return nil, false
+	}
+
+	query := dbFor(r).C(cfg.CollectionName).Find(filter)
+
+	var total int
+
+	span := startMongoSpan(r, "mongo.count", filter)
+	err = timedOp(r.Context(), cfg.CollectionName + ".count", func() (err error) {
+		total, err = query.Count()
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	if projection != nil {
+		query = query.Select(projection)
+	}
+
+	if len(sort) > 0 {
+		query = query.Sort(sort...)
+	}
+
+	var todos []TodoModel
+
+	span = startMongoSpan(r, "mongo.find", filter)
+	err = timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return query.Skip((page - 1) * limit).Limit(limit).All(&todos)
+	})
+	endSpan(span, err)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	list := listedTodos(todos)
+	if err := decorateTodos(r, todos, list); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	return renderer.M{
+		"data": projectTodos(r, list),
+		"total": total,
+		"page": page,
+		"limit": limit,
+	}, true
+}
+
+// decorateTodos sets the fields of list, converted from todos, that depend on
+// other documents.
+func decorateTodos(r *http.Request, todos []TodoModel, list []Todo) error {
+	if err := setBlocked(r, todos, list); err != nil {
+		return err
+	}
+
+	if err := setCommentCounts(r, todos, list); err != nil {
+		return err
+	}
+
+	setLocalTimes(r, list)
+
+	return setPermissions(r, todos, list)
+}
+
+// listedTodos maps todos to their JSON representation in lists.
+func listedTodos(todos []TodoModel) []Todo {
+	var todoList []Todo
+
+	for _, t := range todos {
+		todo := toTodo(t)
+		// Descriptions can be long, so lists leave them out; GET /todo/{id}
+		// returns the full todo.
+		todo.Description = ""
+		todoList = append(todoList, todo)
+	}
+
+	return todoList
+}
+
+// @Summary Get a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param fields query string false "Comma separated fields to return"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Success 304 "Not Modified"
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id} [get]
+func getTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, false)
+	if !ok {
+		return
+	}
+
+	list := []Todo{toTodo(todo)}
+	if err := decorateTodos(r, []TodoModel{todo}, list); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todo", err.Error()))
+		return
+	}
+
+	// Blockers being completed changes isBlocked without touching the todo,
+	// so the todo only counts as modified when it is not blocked.
+	if len(todo.BlockedBy) == 0 {
+		setLastModified(w, todo.UpdatedAt)
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": projectTodo(r, list[0]),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// findTodo loads the user's todo identified by the {id} URL parameter. When the id is
+// invalid or no todo matches, the error response is written and ok is false.
+func findTodo(w http.ResponseWriter, r *http.Request) (todo TodoModel, ok bool) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id is invalid", ""))
+		return todo, false
+	}
+
+	filter := ownedBy(r, bson.M{"_id": bson.ObjectIdHex(id)})
+
+	span := startMongoSpan(r, "mongo.findOne", filter)
+	err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(filter).One(&todo)
+	})
+	endSpan(span, err)
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return todo, false
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todo", err.Error()))
+		return todo, false
+	}
+
+	return todo, true
+}
+
+// toTodo maps a stored TodoModel to its JSON representation.
+func toTodo(t TodoModel) Todo {
+	status := todoStatus(t)
+
+	todo := Todo{
+		ID: t.ID.Hex(),
+		Title: t.Title,
+		Completed: status == statusDone,
+		Status: status,
+		CreatedAt: t.CreatedAt,
+		DueDate: t.DueDate,
+		Tags: t.Tags,
+		Priority: t.Priority,
+		Description: t.Description,
+		Subtasks: []Subtask{},
+		UpdatedAt: t.UpdatedAt,
+		CompletedAt: t.CompletedAt,
+		Score: t.Score,
+		Version: t.Version,
+		Position: t.Position,
+		Pinned: t.Pinned,
+		PinnedAt: t.PinnedAt,
+		EstimatedMinutes: t.EstimatedMinutes,
+		ActualMinutes: t.ActualMinutes,
+		TimerRunning: t.TimerStartedAt != nil,
+		Recurrence: t.Recurrence,
+		NextOccurrence: t.NextOccurrence,
+	}
+
+	if t.ListID != nil {
+		todo.ListID = t.ListID.Hex()
+	}
+
+	if t.FromTemplate != nil {
+		todo.FromTemplate = t.FromTemplate.Hex()
+	}
+
+	for _, id := range t.BlockedBy {
+		todo.BlockedBy = append(todo.BlockedBy, id.Hex())
+	}
+
+	completed := 0
+	for _, st := range t.Subtasks {
+		todo.Subtasks = append(todo.Subtasks, Subtask{
+			ID: st.ID.Hex(),
+			Title: st.Title,
+			Completed: st.Completed,
+		})
+		if st.Completed {
+			completed++
+		}
+	}
+
+	if len(t.Subtasks) > 0 {
+		todo.SubtaskProgress = completed * 100 / len(t.Subtasks)
+	}
+
+	if t.Archived {
+		archivedAt := t.ArchivedAt
+		todo.ArchivedAt = &archivedAt
+	}
+
+	return todo
+}
+
+// parsePriority validates a requested priority, falling back to
+// defaultPriority when none is given.
+func parsePriority(p string) (string, bool) {
+	if p == "" {
+		return defaultPriority, true
+	}
+
+	_, ok := priorityOrder[p]
+	return p, ok
+}
+
+// sortFields maps the fields todos can be sorted by to the stored fields
+// sorted on.
+var sortFields = map[string]string{
+	"createdAt": "createdAt",
+	"updatedAt": "updatedAt",
+	"priority": "priorityOrder",
+	"title": "title",
+	"position": "position",
+}
+
+// todoSort returns the sort fields requested by the sort query parameter, a
+// comma separated list such as createdAt,-priority. A leading "-" sorts in
+// descending order. Without the parameter the newest todos come first.
+func todoSort(r *http.Request) ([]string, error) {
+	v := r.URL.Query().Get("sort")
+	if v == "" {
+		return []string{"-createdAt"}, nil
+	}
+
+	return parseSort(v)
+}
+
+func parseSort(v string) ([]string, error) {
+	var sort []string
+	seen := map[string]bool{}
+
+	for _, field := range strings.Split(v, ",") {
+		field = strings.TrimSpace(field)
+
+		prefix := ""
+		if strings.HasPrefix(field, "-") {
+			prefix, field = "-", field[1:]
+		}
+
+		stored, ok := sortFields[field]
+		if !ok {
+			return nil, errors.New("The sort fields must be among createdAt, updatedAt, priority, title, position, or the sort must be score")
+		}
+		if seen[field] {
+			return nil, errors.New("The sort field " + field + " is repeated")
+		}
+		seen[field] = true
+
+		sort = append(sort, prefix + stored)
+	}
+
+	return sort, nil
+}
+
+// setCompletion records a change of the completed flag in an update
+// document: completedAt is stamped when a todo becomes completed and removed
+// when it is reopened.
+func setCompletion(set, unset bson.M, was, completed bool) {
+	if completed == was {
+		return
+	}
+
+	if completed {
+		set["completedAt"] = time.Now()
+	} else {
+		unset["completedAt"] = ""
+	}
+}
+
+// parseDateParam parses a date query parameter given either as RFC 3339 or as
+// a bare 2006-01-02 date, which is read as midnight in loc.
+func parseDateParam(v string, loc *time.Location) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02", v, loc)
+	}
+
+	return t, err
+}
+
+// normalizeTags trims the given tags and drops empty and duplicate entries.
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+// todoFilter builds the query used by fetchTodos from the request's query
+// parameters. Archived todos are always left out.
+func todoFilter(r *http.Request) (bson.M, error) {
+	filter := bson.M{"archived": bson.M{"$ne": true}}
+
+	switch v := r.URL.Query().Get("completed"); v {
+	case "":
+	case "true":
+		filter["completed"] = true
+	case "false":
+		filter["completed"] = false
+	default:
+		return nil, errors.New("The completed filter must be either true or false")
+	}
+
+	if v := r.URL.Query().Get("listId"); v != "" {
+		if !bson.IsObjectIdHex(v) {
+			return nil, errors.New("The listId filter is invalid")
+		}
+		filter["listID"] = bson.ObjectIdHex(v)
+	}
+
+	if v := r.URL.Query().Get("tags"); v != "" {
+		tags := normalizeTags(strings.Split(v, ","))
+
+		switch r.URL.Query().Get("tagMatch") {
+		case "", "all":
+			filter["tags"] = bson.M{"$all": tags}
+		case "any":
+			filter["tags"] = bson.M{"$in": tags}
+		default:
+			return nil, errors.New("The tagMatch filter must be either all or any")
+		}
+	}
+
+	if v := r.URL.Query().Get("priority"); v != "" {
+		if _, ok := priorityOrder[v]; !ok {
+			return nil, errors.New("The priority filter must be one of " + strings.Join(priorities, ", "))
+		}
+		filter["priority"] = v
+	}
+
+	completedAt := bson.M{}
+
+	if v := r.URL.Query().Get("completedAfter"); v != "" {
+		after, err := parseDateParam(v, userLocation(r))
+		if err != nil {
+			return nil, errors.New("The completedAfter filter must be a date")
+		}
+		completedAt["$gte"] = after
+	}
+
+	if v := r.URL.Query().Get("completedBefore"); v != "" {
+		before, err := parseDateParam(v, userLocation(r))
+		if err != nil {
+			return nil, errors.New("The completedBefore filter must be a date")
+		}
+		completedAt["$lte"] = before
+	}
+
+	if len(completedAt) > 0 {
+		filter["completedAt"] = completedAt
+	}
+
+	createdAt := bson.M{}
+	var createdAfter time.Time
+
+	if v := r.URL.Query().Get("createdAfter"); v != "" {
+		after, err := parseDateParam(v, userLocation(r))
+		if err != nil {
+			return nil, errors.New("The createdAfter filter must be a date")
+		}
+		createdAt["$gte"], createdAfter = after, after
+	}
+
+	if v := r.URL.Query().Get("createdBefore"); v != "" {
+		before, err := parseDateParam(v, userLocation(r))
+		if err != nil {
+			return nil, errors.New("The createdBefore filter must be a date")
+		}
+		if before.Before(createdAfter) {
+			return nil, errors.New("The createdBefore filter must not be earlier than createdAfter")
+		}
+		createdAt["$lte"] = before
+	}
+
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	switch v := r.URL.Query().Get("overdue"); v {
+	case "", "false":
+	case "true":
+		filter["dueDate"] = bson.M{"$lt": time.Now()}
+		filter["completed"] = false
+	default:
+		return nil, errors.New("The overdue filter must be either true or false")
+	}
+
+	return filter, nil
+}
+
+// parsePagination reads the page and limit query parameters. Missing values
+// fall back to the first page and defaultPageLimit, and limit is capped at
+// maxPageLimit.
+func parsePagination(r *http.Request) (int, int, error) {
+	page, limit := 1, defaultPageLimit
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("The page must be a positive integer")
+		}
+		page = n
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, errors.New("The limit must be a positive integer")
+		}
+		limit = n
+	}
+
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	return page, limit, nil
+}
+
+// createTodo saves a new todo. Hashtags in its title are stored as tags
+// instead, and the response then holds both titles. A due date written as a
+// phrase is echoed back with the date it was read as.
+//
+// @Summary Create a todo
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param Idempotency-Key header string false "Replays the response of the first request with the same key"
+// @Param body body Todo true "The todo"
+// @Success 201 {object} object{message=string,todo_id=string,createdAtLocal=string}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo [post]
+func createTodo(w http.ResponseWriter, r *http.Request) {
+	var t Todo
+
+	parsedFrom, ok := decodeTodoBody(w, r, &t)
+	if !ok {
+		return
+	}
+
+	originalTitle := extractHashtags(&t)
+
+	tm, ok := saveNewTodo(w, r, t)
+	if !ok {
+		return
+	}
+
+	response := renderer.M{
+		"message": "todo created successfully",
+		"todo_id": tm.ID.Hex(),
+		"createdAtLocal": localTime(r, tm.CreatedAt),
+	}
+	if originalTitle != "" {
+		response["title"], response["originalTitle"], response["tags"] = tm.Title, originalTitle, tm.Tags
+	}
+	if parsedFrom != "" {
+		response["dueDate"], response["parsed_from"] = tm.DueDate, parsedFrom
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, response)
+
+	utils.CheckErr(jsonErr)
+	return
+}
+
+// saveNewTodo validates and saves a new todo of the user. When it is invalid
+// or cannot be saved, the error response is written and ok is false.
+func saveNewTodo(w http.ResponseWriter, r *http.Request, t Todo) (tm TodoModel, ok bool) {
+	if !checkInput(w, r, t) {
+		return tm, false
+	}
+
+	priority, ok := parsePriority(t.Priority)
+	if !ok {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The priority is invalid", "").
+			With("allowed", priorities))
+		return tm, false
+	}
+
+	status := t.Status
+	if status == "" {
+		status = statusBacklog
+	}
+	if !validStatus(status) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The status is invalid", "").
+			With("allowed", statuses))
+		return tm, false
+	}
+
+	now := time.Now()
+
+	tm = TodoModel{
+		ID: bson.NewObjectId(),
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		Title: t.Title,
+		Completed: status == statusDone,
+		Status: status,
+		CreatedAt: now,
+		UpdatedAt: now,
+		DueDate: t.DueDate,
+		Tags: normalizeTags(t.Tags),
+		Priority: priority,
+		PriorityOrder: priorityOrder[priority],
+		Description: t.Description,
+		EstimatedMinutes: t.EstimatedMinutes,
+		ActualMinutes: t.ActualMinutes,
+	}
+	if tm.Completed {
+		tm.CompletedAt = &now
+	}
+
+	if t.Recurrence != "" {
+		next, err := nextOccurrence(t.Recurrence, now, now)
+		if err != nil {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The recurrence is invalid", err.Error()))
+			return tm, false
+		}
+		tm.Recurrence, tm.NextOccurrence = t.Recurrence, next
+	}
+
+	if t.ListID != "" {
+		list, ok := findList(w, r, t.ListID)
+		if !ok {
+			return tm, false
+		}
+		tm.ListID = &list.ID
+	}
+
+	position, err := nextPosition(r, tm.ListID)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save todo", ""))
+		return tm, false
+	}
+	tm.Position = position
+
+	span := startMongoSpan(r, "mongo.insert", nil)
+	err = timedOp(r.Context(), cfg.CollectionName + ".insert", func() error {
+		return dbFor(r).C(cfg.CollectionName).Insert(&tm)
+	})
+	endSpan(span, err)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save todo", ""))
+		return tm, false
+	}
+
+	invalidateTodoCache(r, tm.UserID)
+	publishTodoEvent(r, "created", tm, toTodo(tm))
+	recordAudit(r, "created", nil, &tm)
+	notifySlackOfTodo(logFor(r), tm)
+
+	return tm, true
+}
+
+// @Summary Create several todos
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param body body []Todo true "The todos"
+// @Success 207 {object} DataEnvelope{data=[]BatchResult}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 413 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/batch [post]
+func createTodos(w http.ResponseWriter, r *http.Request) {
+	var todos []Todo
+
+	if err := json.NewDecoder(r.Body).Decode(&todos); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if len(todos) == 0 {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "At least one todo is required", ""))
+		return
+	}
+
+	if len(todos) > maxBatchSize {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusRequestEntityTooLarge, "A batch can contain at most " + strconv.Itoa(maxBatchSize) + " todos", ""))
+		return
+	}
+
+	position, err := nextPosition(r, nil)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save todos", ""))
+		return
+	}
+
+	now := time.Now()
+	results := make([]BatchResult, len(todos))
+	var docs []TodoModel
+	var created []int
+
+	for i, t := range todos {
+		results[i] = BatchResult{Index: i}
+
+		if errs := validateInput(t); len(errs) > 0 {
+			results[i].Status = "failed"
+			results[i].Reason = "The todo is invalid"
+			results[i].Errors = errs
+			continue
+		}
+
+		priority, ok := parsePriority(t.Priority)
+		if !ok {
+			results[i].Status = "failed"
+			results[i].Reason = "The priority is invalid"
+			continue
+		}
+
+		tm := TodoModel{
+			ID: bson.NewObjectId(),
+			UserID: currentUserID(r),
+			TenantID: currentTenantID(r),
+			Title: t.Title,
+			Completed: false,
+			Status: statusBacklog,
+			CreatedAt: now,
+			UpdatedAt: now,
+			DueDate: t.DueDate,
+			Tags: normalizeTags(t.Tags),
+			Priority: priority,
+			PriorityOrder: priorityOrder[priority],
+			Description: t.Description,
+			Position: position,
+		}
+		position += positionSpacing
+
+		docs = append(docs, tm)
+		created = append(created, i)
+		results[i].ID = tm.ID.Hex()
+	}
+
+	status := "created"
+	reason := ""
+
+	if len(docs) > 0 {
+		span := startMongoSpan(r, "mongo.insertMany", nil)
+		failed, err := insertTodos(r.Context(), docs)
+		endSpan(span, err)
+		if err != nil {
+			logFor(r).Error().Err(err).Msg("failed to save todos")
+
+			// Inside a transaction nothing was saved, so report the todo
+			// that caused the rollback.
+			if failed >= 0 {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "Failed to save todo", "").
+					With("index", created[failed]))
+				return
+			}
+
+			status = "failed"
+			reason = "Failed to save todo"
+		} else {
+			invalidateTodoCache(r)
+			recordCreated(r, docs)
+		}
+	}
+
+	for _, i := range created {
+		results[i].Status = status
+		results[i].Reason = reason
+		if status == "failed" {
+			results[i].ID = ""
+		}
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusMultiStatus, renderer.M{
+		"data": results,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Delete a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id} [delete]
+func deleteTodo(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id is invalid", ""))
+		return
+	}
+
+	now := time.Now()
+
+	filter := ownedBy(r, bson.M{
+		"_id": bson.ObjectIdHex(id), "archived": bson.M{"$ne": true},
+	})
+
+	var before TodoModel
+
+	span := startMongoSpan(r, "mongo.update", filter)
+	err := timedOp(r.Context(), cfg.CollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(cfg.CollectionName).Find(filter).Apply(store.Change{
+			Update: bson.M{
+				"$set": bson.M{"archived": true, "archivedAt": now, "updatedAt": now},
+				"$inc": bson.M{"__v": 1},
+			},
+		}, &before)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
+
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "Failed to delete todo", err.Error()))
+		return
+	}
+
+	after := before
+	after.Archived, after.ArchivedAt, after.UpdatedAt = true, now, now
+	after.Version++
+
+	invalidateTodoCache(r, before.UserID)
+	publishTodoEvent(r, "deleted", before, nil)
+	recordAudit(r, "deleted", &before, &after)
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Todo deleted successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+	return
+}
+
+// @Summary Delete several todos
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param body body object{ids=[]string} true "The ids of the todos"
+// @Success 200 {object} object{deleted=int,invalid=[]string}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 413 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/batch [delete]
+func deleteTodos(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if len(body.IDs) == 0 {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "At least one id is required", ""))
+		return
 	}
 
-	Todo struct {
-		ID				string `json:"id"`
-		Title			string `json:"title"`
-	    Completed		bool `json:"completed"`
-		CreatedAt		time.Time `json:"createdAt"`
+	if len(body.IDs) > maxBatchSize {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusRequestEntityTooLarge, "A batch can contain at most " + strconv.Itoa(maxBatchSize) + " ids", ""))
+		return
 	}
-)
 
-func init() {
-	rnd = renderer.New()
-	sess, err := mgo.Dial(hostName)
-	utils.CheckErr(err)
-	sess.SetMode(mgo.Monotonic, true)
+	var objectIds []bson.ObjectId
+	invalid := []string{}
+
+	for _, id := range body.IDs {
+		id = strings.TrimSpace(id)
+		if !bson.IsObjectIdHex(id) {
+			invalid = append(invalid, id)
+			continue
+		}
+		objectIds = append(objectIds, bson.ObjectIdHex(id))
+	}
+
+	deleted := 0
+
+	if len(objectIds) > 0 {
+		now := time.Now()
+		n, err := updateAllTodos(r, bson.M{
+			"_id": bson.M{"$in": objectIds}, "archived": bson.M{"$ne": true},
+		}, bson.M{
+			"$set": bson.M{"archived": true, "archivedAt": now, "updatedAt": now},
+			"$inc": bson.M{"__v": 1},
+		}, "deleted")
+		if err != nil {
+			logFor(r).Error().Err(err).Msg("failed to delete todos")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete todos", err.Error()))
+			return
+		}
+		deleted = n
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"deleted": deleted,
+		"invalid": invalid,
+	})
 
-	db = sess.DB(dbName)
+	utils.CheckErr(jsonErr)
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	err := rnd.Template(w, http.StatusOK, []string{"static/home.tpl"}, nil)
-	utils.CheckErr(err)
+// updateTodo replaces the todo, which can be one shared with the user with
+// the edit permission, and responds with the todo saved. As in createTodo,
+// hashtags in the title are stored as tags and due dates can be phrases.
+//
+// @Summary Replace a todo
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body Todo true "The todo, with the version it was read at"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id} [put]
+func updateTodo(w http.ResponseWriter, r *http.Request) {
+	current, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	// The client sends back the version it read, which must still be current.
+	var body struct {
+		Todo
+		Version			*int64 `json:"version" validate:"required"`
+	}
+
+	parsedFrom, ok := decodeTodoBody(w, r, &body)
+	if !ok {
+		return
+	}
+
+	originalTitle := extractHashtags(&body.Todo)
+
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	t := body.Todo
+	version := *body.Version
+
+	priority, ok := parsePriority(t.Priority)
+	if !ok {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The priority is invalid", "").
+			With("allowed", priorities))
+		return
+	}
+
+	// Without a status, the completed flag decides whether the todo is done.
+	status := t.Status
+	if status == "" {
+		status = completionStatus(current, t.Completed)
+	} else if !writeStatusProblem(w, r, current, status) {
+		return
+	}
+
+	set := bson.M{
+		"title": t.Title,
+		"tags": normalizeTags(t.Tags),
+		"priority": priority,
+		"priorityOrder": priorityOrder[priority],
+		"description": t.Description,
+		"updatedAt": time.Now(),
+		"__v": version + 1,
+	}
+	unset := bson.M{}
+
+	if t.DueDate != nil {
+		set["dueDate"] = t.DueDate
+	} else {
+		unset["dueDate"] = ""
+	}
+
+	if t.EstimatedMinutes != nil {
+		set["estimatedMinutes"] = *t.EstimatedMinutes
+	} else {
+		unset["estimatedMinutes"] = ""
+	}
+
+	if t.ActualMinutes != nil {
+		set["actualMinutes"] = *t.ActualMinutes
+	} else {
+		unset["actualMinutes"] = ""
+	}
+
+	switch {
+	case t.Recurrence == "":
+		unset["recurrence"] = ""
+		unset["nextOccurrence"] = ""
+	case t.Recurrence != current.Recurrence:
+		next, err := nextOccurrence(t.Recurrence, current.CreatedAt, time.Now())
+		if err != nil {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The recurrence is invalid", err.Error()))
+			return
+		}
+		set["recurrence"] = t.Recurrence
+		if next != nil {
+			set["nextOccurrence"] = next
+		} else {
+			unset["nextOccurrence"] = ""
+		}
+	}
+
+	setStatus(set, unset, current, status)
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := bson.M{"_id": current.ID, "__v": version}
+	if version == 0 {
+		filter["__v"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+
+	var after TodoModel
+
+	span := startMongoSpan(r, "mongo.update", filter)
+	err := timedOp(r.Context(), cfg.CollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(cfg.CollectionName).Find(filter).Apply(store.Change{
+			Update: update,
+			ReturnNew: true,
+		}, &after)
+		return err
+	})
+	endSpan(span, err)
+	if err == store.ErrNotFound {
+		writeVersionConflict(w, r, current.ID)
+		return
+	}
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to update todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update todo", ""))
+		return
+	}
+
+	invalidateTodoCache(r, current.UserID)
+	publishTodoEvent(r, "updated", after, toTodo(after))
+	recordAudit(r, "updated", &current, &after)
+	continueRecurrence(r, current, after)
+
+	result := toLocalTodo(r, after)
+	result.OriginalTitle = originalTitle
+
+	response := renderer.M{"data": projectTodo(r, result)}
+	if parsedFrom != "" {
+		response["parsed_from"] = parsedFrom
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, response)
+
+	utils.CheckErr(jsonErr)
 }
 
-func fetchTodos(w http.ResponseWriter, r *http.Request) {
-	var todos []TodoModel
+// writeVersionConflict reports that the todo changed since the client read
+// it, along with its current version.
+func writeVersionConflict(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	var current TodoModel
 
-	if err := db.C(collectionName).Find(bson.M{}).All(&todos); err != nil {
-		jsonErr := rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to fetch Todo",
-			"error": err,
-		})
+	if err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).FindId(id).Select(bson.M{"__v": 1}).One(&current)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
 
-		utils.CheckErr(jsonErr)
+		logFor(r).Error().Err(err).Msg("failed to fetch todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todo", err.Error()))
 		return
 	}
-	var todoList []Todo
 
-	for _, t := range todos {
-		todoList = append(todoList, Todo{
-			ID: t.ID.Hex(),
-			Title: t.Title,
-			Completed: t.Completed,
-			CreatedAt: t.CreatedAt,
-		})
+	utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The todo was modified by another request", "").
+		With("currentVersion", current.Version))
+}
+
+// @Summary Change some fields of a todo
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body object true "Any of title, completed, status, dueDate, tags, priority and description"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id} [patch]
+func patchTodo(w http.ResponseWriter, r *http.Request) {
+	current, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
 	}
 
-	rnd.JSON(w, http.StatusOK, renderer.M{
-		"data": todoList,
+	var body map[string]interface{}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if len(body) == 0 {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "At least one of title, completed, status, dueDate, tags, priority or description is required", ""))
+		return
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	status := ""
+
+	for key, value := range body {
+		switch key {
+		case "title":
+			title, ok := value.(string)
+			if !ok || strings.TrimSpace(title) == "" {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The title must be a non-empty string", ""))
+				return
+			}
+			// The same rules as PUT.
+			if !checkInput(w, r, Todo{Title: title}, "Title") {
+				return
+			}
+			set["title"] = title
+		case "completed":
+			completed, ok := value.(bool)
+			if !ok {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The completed field must be a boolean", ""))
+				return
+			}
+			// An explicit status takes precedence.
+			if _, ok := body["status"]; !ok {
+				status = completionStatus(current, completed)
+			}
+		case "status":
+			v, _ := value.(string)
+			if !writeStatusProblem(w, r, current, v) {
+				return
+			}
+			status = v
+		case "dueDate":
+			if value == nil {
+				unset["dueDate"] = ""
+				continue
+			}
+			v, ok := value.(string)
+			dueDate, err := time.Parse(time.RFC3339, v)
+			if !ok || err != nil {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The dueDate must be an ISO-8601 date", ""))
+				return
+			}
+			set["dueDate"] = dueDate
+		case "tags":
+			values, ok := value.([]interface{})
+			var tags []string
+			for _, v := range values {
+				tag, isString := v.(string)
+				ok = ok && isString
+				tags = append(tags, tag)
+			}
+			if !ok {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The tags must be an array of strings", ""))
+				return
+			}
+			set["tags"] = normalizeTags(tags)
+		case "priority":
+			v, _ := value.(string)
+			priority, ok := parsePriority(v)
+			if !ok || v == "" {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The priority is invalid", "").
+					With("allowed", priorities))
+				return
+			}
+			set["priority"] = priority
+			set["priorityOrder"] = priorityOrder[priority]
+		case "description":
+			description, ok := value.(string)
+			if !ok {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The description must be a string", ""))
+				return
+			}
+			if n := utf8.RuneCountInString(description); n > maxDescriptionLength {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The description is too long", "").
+					With("length", n).
+					With("max", maxDescriptionLength))
+				return
+			}
+			set["description"] = description
+		default:
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "Unknown field " + key, ""))
+			return
+		}
+	}
+
+	if status != "" {
+		setStatus(set, unset, current, status)
+	}
+	set["updatedAt"] = time.Now()
+
+	update := bson.M{"$set": set, "$inc": bson.M{"__v": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var after TodoModel
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(cfg.CollectionName).FindId(current.ID).Apply(store.Change{
+			Update: update,
+			ReturnNew: true,
+		}, &after)
+		return err
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to update todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update todo", ""))
+		return
+	}
+
+	invalidateTodoCache(r, current.UserID)
+	publishTodoEvent(r, "updated", after, toTodo(after))
+	recordAudit(r, "updated", &current, &after)
+	continueRecurrence(r, current, after)
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Todo updated successfully",
 	})
+
+	utils.CheckErr(jsonErr)
 }
 
-func createTodo(w http.ResponseWriter, r *http.Request) {
-	var t Todo
+// @Summary Toggle whether a todo is completed
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/toggle [post]
+func toggleTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	unset := bson.M{}
+	setStatus(set, unset, todo, completionStatus(todo, !todo.Completed))
+
+	update := bson.M{"$set": set, "$inc": bson.M{"__v": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	// Toggling the version read keeps two concurrent toggles from cancelling
+	// each other out.
+	filter := bson.M{"_id": todo.ID, "__v": todo.Version}
+	if todo.Version == 0 {
+		filter["__v"] = bson.M{"$in": []interface{}{0, nil}}
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		jsonErr := rnd.JSON(w, http.StatusProcessing, err)
-		utils.CheckErr(jsonErr)
+	var after TodoModel
+
+	err := timedOp(r.Context(), cfg.CollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(cfg.CollectionName).Find(filter).Apply(store.Change{
+			Update: update,
+			ReturnNew: true,
+		}, &after)
+		return err
+	})
+	if err == store.ErrNotFound {
+		writeVersionConflict(w, r, todo.ID)
+		return
+	}
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to update todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update todo", ""))
 		return
 	}
 
-	if t.Title == "" {
-		jsonErr := rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "The title is required",
-		})
-		utils.CheckErr(jsonErr)
+	invalidateTodoCache(r, after.UserID)
+	publishTodoEvent(r, "updated", after, toTodo(after))
+	recordAudit(r, "toggled", &todo, &after)
+	continueRecurrence(r, todo, after)
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": projectTodo(r, toLocalTodo(r, after)),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Pin a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/pin [post]
+func pinTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
 		return
 	}
 
-	tm := TodoModel{
-		ID: bson.NewObjectId(),
-		Title: t.Title,
-		Completed: false,
-		CreatedAt: time.Now(),
+	if !todo.Pinned {
+		// Counting the pinned todos and pinning another are two operations,
+		// so the user's pins take turns under a lock.
+		until, err := lockPins(r)
+		if store.IsDup(err) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "Another todo is being pinned", ""))
+			return
+		}
+		if err != nil {
+			logFor(r).Error().Err(err).Msg("failed to pin todo")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to pin todo", ""))
+			return
+		}
+		defer unlockPins(r, until)
+
+		var n int
+
+		if err := timedOp(r.Context(), cfg.CollectionName + ".count", func() (err error) {
+			n, err = dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{
+				"pinned": true, "archived": bson.M{"$ne": true},
+			})).Count()
+			return err
+		}); err != nil {
+			logFor(r).Error().Err(err).Msg("failed to pin todo")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to pin todo", ""))
+			return
+		}
+
+		if n >= maxPinnedTodos {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "At most " + strconv.Itoa(maxPinnedTodos) + " todos can be pinned", ""))
+			return
+		}
+	}
+
+	applyTodoUpdate(w, r, "pinned", bson.M{"_id": todo.ID}, bson.M{
+		"$set": bson.M{"pinned": true, "pinnedAt": time.Now()},
+	})
+}
+
+// @Summary Unpin a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/unpin [post]
+func unpinTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	applyTodoUpdate(w, r, "unpinned", bson.M{"_id": todo.ID}, bson.M{
+		"$set": bson.M{"pinned": false},
+		"$unset": bson.M{"pinnedAt": ""},
+	})
+}
+
+// @Summary Restore a deleted todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/restore [post]
+func restoreTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	if !todo.Archived {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The todo is not archived", ""))
+		return
 	}
 
-	if err := db.C(collectionName).Insert(&tm); err != nil {
-		jsonErr := rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to save todo",
+	before := todo
+	todo.UpdatedAt = time.Now()
+	todo.Version++
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".update", func() error {
+		return dbFor(r).C(cfg.CollectionName).UpdateId(todo.ID, bson.M{
+			"$set": bson.M{"archived": false, "updatedAt": todo.UpdatedAt},
+			"$unset": bson.M{"archivedAt": ""},
+			"$inc": bson.M{"__v": 1},
 		})
-		utils.CheckErr(jsonErr)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to restore todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to restore todo", ""))
 		return
 	}
 
-	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
-		"message": "todo created successfully",
-		"todo_id": tm.ID.Hex(),
+	todo.Archived = false
+	todo.ArchivedAt = time.Time{}
+
+	invalidateTodoCache(r, todo.UserID)
+	publishTodoEvent(r, "updated", todo, toTodo(todo))
+	recordAudit(r, "restored", &before, &todo)
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": projectTodo(r, toLocalTodo(r, todo)),
 	})
 
 	utils.CheckErr(jsonErr)
-	return
 }
 
-func deleteTodo(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimSpace(chi.URLParam(r, "id"))
+// @Summary Add tags to a todo
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body object{tags=[]string} true "The tags"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/tags [post]
+func addTodoTags(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
 
-	if !bson.IsObjectIdHex(id) {
-		jsonErr := rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "The id is invalid",
-		})
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	tags := normalizeTags(body.Tags)
+	if len(tags) == 0 {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "At least one tag is required", ""))
+		return
+	}
 
-		utils.CheckErr(jsonErr)
+	applyTodoUpdate(w, r, "tagged", bson.M{"_id": todo.ID}, bson.M{
+		"$addToSet": bson.M{"tags": bson.M{"$each": tags}},
+	})
+}
+
+// @Summary Remove a tag from a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param tag path string true "The tag"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/tags/{tag} [delete]
+func removeTodoTag(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
 		return
 	}
 
-	if err := db.C(collectionName).RemoveId(bson.ObjectIdHex(id)); err != nil {
-		jsonErr := rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "Failed to delete todo",
-			"error": err,
+	applyTodoUpdate(w, r, "untagged", bson.M{"_id": todo.ID}, bson.M{
+		"$pull": bson.M{"tags": chi.URLParam(r, "tag")},
+	})
+}
+
+// applyTodoUpdate applies update to the todo matching selector, which the
+// caller has checked the user may edit, bumping its updatedAt and version,
+// records the change as action and responds with the updated todo.
+func applyTodoUpdate(w http.ResponseWriter, r *http.Request, action string, selector bson.M, update bson.M) {
+	var before, todo TodoModel
+
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["updatedAt"] = time.Now()
+
+	inc, _ := update["$inc"].(bson.M)
+	if inc == nil {
+		inc = bson.M{}
+		update["$inc"] = inc
+	}
+	inc["__v"] = 1
+
+	err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(inTenant(r, selector)).One(&before)
+	})
+	if err == nil {
+		err = timedOp(r.Context(), cfg.CollectionName + ".findAndModify", func() error {
+			_, err := dbFor(r).C(cfg.CollectionName).Find(inTenant(r, selector)).Apply(store.Change{
+				Update: update,
+				ReturnNew: true,
+			}, &todo)
+			return err
 		})
+	}
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return
+		}
 
-		utils.CheckErr(jsonErr)
+		logFor(r).Error().Err(err).Msg("failed to update todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update todo", ""))
 		return
 	}
 
+	invalidateTodoCache(r, todo.UserID)
+	publishTodoEvent(r, "updated", todo, toTodo(todo))
+	recordAudit(r, action, &before, &todo)
+	continueRecurrence(r, before, todo)
+
 	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
-		"message": "Todo deleted successfully",
+		"data": projectTodo(r, toLocalTodo(r, todo)),
 	})
 
 	utils.CheckErr(jsonErr)
-	return
 }
 
-func updateTodo(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimSpace(chi.URLParam(r, "id"))
+// @Summary Add a subtask to a todo
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body Subtask true "The subtask"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/subtasks [post]
+func addSubtask(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
 
-	if !bson.IsObjectIdHex(id) {
-		jsonErr := rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "The id is invalid",
-		})
+	var st Subtask
 
-		utils.CheckErr(jsonErr)
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
 		return
 	}
 
-	var t Todo
+	if !checkInput(w, r, st) {
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-		jsonErr := rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "The body is invalid",
-			"error": err,
-		})
+	applyTodoUpdate(w, r, "subtask_added", bson.M{"_id": todo.ID}, bson.M{
+		"$push": bson.M{"subtasks": SubtaskModel{
+			ID: bson.NewObjectId(),
+			Title: st.Title,
+			Completed: st.Completed,
+		}},
+	})
+}
 
-		utils.CheckErr(jsonErr)
+// @Summary Replace a subtask
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param subId path string true "The subtask id"
+// @Param body body Subtask true "The subtask"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/subtasks/{subId} [put]
+func updateSubtask(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
 		return
 	}
 
-	if t.Title == "" {
-		jsonErr := rnd.JSON(w, http.StatusBadRequest, renderer.M{
-			"message": "The title field is required",
-		})
+	subID := strings.TrimSpace(chi.URLParam(r, "subId"))
 
-		utils.CheckErr(jsonErr)
+	if !bson.IsObjectIdHex(subID) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The subtask id is invalid", ""))
 		return
 	}
 
-	if err := db.C(collectionName).Update(bson.M{
-		"_id": bson.ObjectIdHex(id),
-	},
-	bson.M{
-		"title": t.Title, "completed": t.Completed,
-	});
-	err != nil {
-		jsonErr := rnd.JSON(w, http.StatusProcessing, renderer.M{
-			"message": "Failed to update todo",
-		})
+	var st Subtask
+
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, st) {
+		return
+	}
+
+	applyTodoUpdate(w, r, "subtask_updated", bson.M{
+		"_id": todo.ID,
+		"subtasks._id": bson.ObjectIdHex(subID),
+	}, bson.M{
+		"$set": bson.M{
+			"subtasks.$.title": st.Title,
+			"subtasks.$.completed": st.Completed,
+		},
+	})
+}
 
-		utils.CheckErr(jsonErr)
+// @Summary Delete a subtask
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param subId path string true "The subtask id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/subtasks/{subId} [delete]
+func deleteSubtask(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
 		return
 	}
+
+	subID := strings.TrimSpace(chi.URLParam(r, "subId"))
+
+	if !bson.IsObjectIdHex(subID) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The subtask id is invalid", ""))
+		return
+	}
+
+	applyTodoUpdate(w, r, "subtask_deleted", bson.M{
+		"_id": todo.ID,
+		"subtasks._id": bson.ObjectIdHex(subID),
+	}, bson.M{
+		"$pull": bson.M{"subtasks": bson.M{"_id": bson.ObjectIdHex(subID)}},
+	})
 }
 
 func main()  {
-	stopChan := make(chan os.Signal)
-	signal.Notify(stopChan, os.Interrupt)
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+
+	configPath := flag.String("config", "", "path to a YAML config file")
+	migrate := flag.String("migrate", "", "roll back the latest migration with down, or migrate to version N with to=N, then exit")
+	flag.Parse()
+
+	cfg = loadConfig(*configPath)
+	setupLogging(cfg.LogLevel)
+	setupAuth(cfg.JWTSecret)
+	slack = notifications.NewSlackNotifier(cfg.SlackWebhookURL)
+	shutdownTracing := setupTracing(cfg.OTLPEndpoint)
+	connect()
+	connectRedis()
+	if !runMigrations(*migrate) {
+		return
+	}
 
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(recoveryMiddleware)
+	r.Use(inFlightMiddleware)
+	if cfg.MetricsEnabled {
+		registerMongoMetrics()
+		r.Use(metricsMiddleware)
+	}
+	r.Use(utils.RequestID)
+	r.Use(newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, clientIP).Except("/todo/autocomplete").Middleware)
+	r.Use(requestLogger)
+	r.Use(corsMiddleware(cfg.CORSAllowedOrigins, cfg.CORSAllowCredentials))
+	r.Use(compressionMiddleware)
+	r.Use(bodyLimitMiddleware(cfg.MaxBodyBytes, "/todo/import", "/todo/import/csv"))
+	r.Use(serializationMiddleware)
+	r.Use(bodyLoggingMiddleware)
 
 	r.Get("/", homeHandler)
+	r.Get("/health", healthHandler)
+	r.Get("/playground", playground.Handler("Todo GraphQL", "/graphql"))
+	r.Get("/docs/*", httpSwagger.Handler(httpSwagger.URL("/docs/doc.json")))
+	if cfg.MetricsEnabled {
+		r.Method(http.MethodGet, "/metrics", metricsHandler(cfg.MetricsAllowedCIDR))
+		r.Method(http.MethodGet, "/metrics/mongodb", mongoMetricsHandler(cfg.MetricsAllowedCIDR))
+	}
 
-	r.Mount("/todo", todoHandlers())
+	r.Mount("/tenants", tenantHandlers())
+
+	r.Group(func(r chi.Router) {
+		r.Use(tenantMiddleware)
+
+		r.Mount("/auth", authHandlers())
+		todosV1, todosV2 := todoHandlersV1(), todoHandlers()
+		r.Mount("/v1/todo", todosV1)
+		r.Mount("/v2/todo", todosV2)
+		r.Mount("/todo", versionedHandler(todosV1, todosV2))
+		r.Mount("/lists", listHandlers())
+		r.Mount("/templates", templateHandlers())
+		r.Mount("/webhooks", webhookHandlers())
+		r.Mount("/user", userHandlers())
+		r.Mount("/notifications", notificationHandlers())
+		r.Mount("/admin", adminHandlers())
+		r.Handle("/graphql", graphqlHandler())
+	})
 
 	srv := &http.Server{
-		Addr: port,
-		Handler: r,
+		Addr: cfg.Port,
+		Handler: otelhttp.NewHandler(r, "http.server"),
 		ReadTimeout: 60 * time.Second,
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout: 60 * time.Second,
 	}
 
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
+
+	tlsEnabled := cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+	if tlsEnabled {
+		certs, err := newCertManager(cfg.TLSCertFile, cfg.TLSKeyFile)
+		utils.CheckErr(err)
+
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	}
+
+	grpcSrv := newGRPCServer(srv.TLSConfig)
+	go serveGRPC(grpcSrv)
+
 	go func() {
-		log.Println("listening on port", port)
-		if err:=srv.ListenAndServe(); err!=nil {
-			log.Printf("listen:%s\n", err)
+		log.Info().Str("port", cfg.Port).Bool("tls", tlsEnabled).Msg("listening")
+
+		var err error
+		if tlsEnabled {
+			// The certificate comes from TLSConfig.GetCertificate, so that
+			// reloads are picked up, rather than from the files directly.
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err!=nil && err!=http.ErrServerClosed {
+			log.Error().Err(err).Msg("listen")
 		}
 	}()
 
-	<-stopChan
-	log.Println("Shutting down the server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	srv.Shutdown(ctx)
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	go startChangeStreamWatcher(watchCtx)
+	go runDeliveryWorker(watchCtx)
+	go runOverdueNotifier(watchCtx)
+	go runRecurrenceScheduler(watchCtx)
+	go runAccountCleanupWorker(watchCtx)
 
+	<-stopChan
+	stopWatching()
+	log.Info().Msg("shutting down the server")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-		log.Println("server gracefully stopped")
+
+	// Stop accepting connections, then give the requests still running until
+	// the timeout to finish before the session they use is closed.
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to shut down the server")
+	}
+	stopGRPC(ctx, grpcSrv)
+	if err := waitForInFlight(ctx); err != nil {
+		log.Warn().Err(err).Msg("in-flight requests did not finish")
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to flush traces")
+	}
+
+	sess.Close()
+	log.Info().Msg("server gracefully stopped")
+}
+
+// loadConfig reads the config file at path, or config.yaml when no path is
+// given and that file exists, and otherwise the environment alone.
+func loadConfig(path string) config.Config {
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return config.Load()
+		}
+		path = defaultConfigFile
+	}
+
+	c, err := config.LoadFromFile(path)
+	utils.CheckErr(err)
+
+	return c
 }
 
 func todoHandlers() http.Handler {
+	return todoRouter(updateTodo)
+}
+
+// todoRouter serves the todo routes, replacing todos with update, the only
+// handler that differs between the API versions.
+func todoRouter(update http.HandlerFunc) http.Handler {
 	rg := chi.NewRouter()
+	rg.Use(authMiddleware)
+	rg.Use(jsonAPIMiddleware("todos"))
+
+	// Previews never touch the database, so they skip loading preferences.
+	rg.With(newRateLimiter(previewRateLimitRPS, previewRateLimitBurst, userKey).Middleware).
+		Post("/preview", previewMarkdown)
 
 	rg.Group(func(r chi.Router) {
-		r.Get("/", fetchTodos)
-		r.Post("/", createTodo)
-		r.Put("/{id}", updateTodo)
+		r.Use(preferencesMiddleware)
+		r.Use(featureFlagMiddleware)
+
+		r.With(etagMiddleware).Get("/", fetchTodos)
+		r.Get("/archived", fetchArchivedTodos)
+		r.Get("/overdue", fetchOverdueTodos)
+		r.Get("/search", searchTodos)
+		r.Get("/stats", getTodoStats)
+		r.Get("/heatmap", getTodoHeatmap)
+		r.Get("/export.csv", exportTodosCSV)
+		r.Get("/export.ics", exportTodosICS)
+		r.Get("/ws", todoWebSocket)
+		r.Get("/events", streamTodoEvents)
+		// Autocomplete fires as the user types, so it gets a limit of its own.
+		r.With(newRateLimiter(cfg.AutocompleteRateLimitRPS, cfg.AutocompleteRateLimitBurst, clientIP).Middleware).
+			Get("/autocomplete", autocompleteTodos)
+		r.With(idempotencyMiddleware).Post("/", createTodo)
+		r.Post("/quick", quickCaptureTodo)
+		r.Post("/batch", createTodos)
+		r.Post("/import", importTodosJSON)
+		r.Post("/import/csv", importTodosCSV)
+		r.Get("/recent", fetchRecentTodos)
+		r.With(etagMiddleware, recentViewMiddleware).Get("/{id}", getTodo)
+		r.Get("/{id}/history", fetchTodoHistory)
+		r.Get("/{id}/blockers", fetchBlockers)
+		r.Get("/{id}/unblocks", fetchUnblocks)
+		r.Delete("/batch", deleteTodos)
+		r.Put("/{id}", update)
+		r.Patch("/{id}", patchTodo)
+		r.Post("/{id}/toggle", toggleTodo)
+		r.Post("/{id}/restore", restoreTodo)
+		r.Post("/{id}/move/{listId}", moveTodo)
+		r.Put("/{id}/reorder", reorderTodo)
+		r.Put("/{id}/dependencies", setDependencies)
+		r.Put("/{id}/status", updateTodoStatus)
+		r.Post("/{id}/share", shareTodo)
+		r.Post("/{id}/time/start", startTimer)
+		r.Post("/{id}/time/stop", stopTimer)
+		r.Post("/{id}/pin", pinTodo)
+		r.Post("/{id}/unpin", unpinTodo)
+		r.Post("/{id}/tags", addTodoTags)
+		r.Delete("/{id}/tags/{tag}", removeTodoTag)
+		r.Post("/{id}/subtasks", addSubtask)
+		r.Put("/{id}/subtasks/{subId}", updateSubtask)
+		r.Delete("/{id}/subtasks/{subId}", deleteSubtask)
 		r.Delete("/{id}", deleteTodo)
+		r.Mount("/{id}/comments", commentHandlers())
 	})
 
 	return rg
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+func TestTodoFilterCompleted(t *testing.T) {
+	tests := []struct {
+		name		string
+		query		string
+		completed	interface{}
+	}{
+		{"absent returns every todo", "", nil},
+		{"true returns completed todos", "?completed=true", true},
+		{"false returns active todos", "?completed=false", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/todo" + tt.query, nil)
+
+			filter, err := todoFilter(r)
+			if err != nil {
+				t.Fatalf("todoFilter: %v", err)
+			}
+
+			completed, ok := filter["completed"]
+			if tt.completed == nil && ok {
+				t.Errorf("filter = %v, want no completed condition", filter)
+			}
+			if tt.completed != nil && completed != tt.completed {
+				t.Errorf("completed = %v, want %v", completed, tt.completed)
+			}
+		})
+	}
+}
+
+func TestFetchTodosRejectsInvalidCompleted(t *testing.T) {
+	for _, v := range []string{"yes", "1", "TRUE"} {
+		w := httptest.NewRecorder()
+		fetchTodos(w, httptest.NewRequest(http.MethodGet, "/todo?completed=" + v, nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("completed=%s: status = %d, want %d", v, w.Code, http.StatusBadRequest)
+		}
+		if body := w.Body.String(); !strings.Contains(body, "The completed filter must be either true or false") {
+			t.Errorf("completed=%s: body = %s, want the completed filter message", v, body)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		name		string
+		sort		string
+		want		[]string
+	}{
+		{"one field", "title", []string{"title"}},
+		{"descending", "-createdAt", []string{"-createdAt"}},
+		{"several fields in order", "createdAt,-priority,title", []string{"createdAt", "-priorityOrder", "title"}},
+		{"spaces around fields", " updatedAt , -position ", []string{"updatedAt", "-position"}},
+		{"priority sorts on its order", "priority", []string{"priorityOrder"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSort(tt.sort)
+			if err != nil {
+				t.Fatalf("parseSort(%q): %v", tt.sort, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSort(%q) = %v, want %v", tt.sort, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSortRejectsInvalidFields(t *testing.T) {
+	for _, sort := range []string{
+		"userID",
+		"-$where",
+		"createdAt,password",
+		"title,-title",
+		"createdAt,",
+		"--createdAt",
+	} {
+		if got, err := parseSort(sort); err == nil {
+			t.Errorf("parseSort(%q) = %v, want an error", sort, got)
+		}
+	}
+}
+
+func TestTodoSortDefaultsToNewestFirst(t *testing.T) {
+	got, err := todoSort(httptest.NewRequest(http.MethodGet, "/todo", nil))
+	if err != nil {
+		t.Fatalf("todoSort: %v", err)
+	}
+	if want := []string{"-createdAt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("todoSort = %v, want %v", got, want)
+	}
+}
+
+// storedTodo is a todo of userID saved in the fake database, with subtasks
+// and the times only the server sets.
+func storedTodo(f *fakeMongo, t *testing.T, userID bson.ObjectId) TodoModel {
+	t.Helper()
+
+	created := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	todo := TodoModel{
+		ID: bson.NewObjectId(),
+		UserID: userID,
+		Title: "Write the report",
+		CreatedAt: created,
+		UpdatedAt: created,
+		Tags: []string{"work"},
+		Priority: "high",
+		PriorityOrder: priorityOrder["high"],
+		Subtasks: []SubtaskModel{{ID: bson.NewObjectId(), Title: "Outline"}},
+		Status: statusBacklog,
+		Version: 3,
+	}
+	f.insert(t, cfg.CollectionName, todo)
+
+	return todo
+}
+
+// serveTodoRoute serves the request to the route pattern as userID.
+func serveTodoRoute(method, pattern, path, body string, handler http.HandlerFunc, userID bson.ObjectId) *httptest.ResponseRecorder {
+	router := chi.NewRouter()
+	router.MethodFunc(method, pattern, handler)
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, withUserID(r, userID))
+
+	return w
+}
+
+func TestUpdateTodoPublishesStoredTodo(t *testing.T) {
+	if changeStreamEnabled {
+		t.Skip("the change stream watcher publishes the events")
+	}
+
+	f := useFakeMongo(t)
+	userID := bson.NewObjectId()
+	current := storedTodo(f, t, userID)
+
+	sub := hub.Subscribe(userID.Hex())
+	defer hub.Unsubscribe(sub)
+
+	// The body carries fields only the server sets, which must not reach
+	// the subscribers.
+	body := `{"title": "Write the final report", "priority": "low", "version": 3, "score": 99, "subtaskProgress": 1, "permission": "edit"}`
+	w := serveTodoRoute(http.MethodPut, "/{id}", "/" + current.ID.Hex(), body, updateTodo, userID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var after TodoModel
+	if !f.find(t, cfg.CollectionName, bson.M{"_id": current.ID}, &after) {
+		t.Fatal("the todo is no longer stored")
+	}
+
+	select {
+	case event := <-sub.Events:
+		if event.Type != "updated" || event.TodoID != current.ID.Hex() {
+			t.Errorf("event = %s for %s, want updated for %s", event.Type, event.TodoID, current.ID.Hex())
+		}
+		if want := toTodo(after); !reflect.DeepEqual(event.Payload, want) {
+			t.Errorf("payload = %+v, want the stored todo %+v", event.Payload, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event was published")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "path", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "http_request_duration_seconds",
+		Help: "Time taken to handle HTTP requests, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	panicsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "panics_total",
+		Help: "Number of handler panics recovered.",
+	})
+)
+
+// registerMongoMetrics exposes the MongoDB backed metrics. It must be called
+// once the session is connected.
+func registerMongoMetrics() {
+	store.SetStats(true)
+
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "mongodb_operations_total",
+		Help: "Number of operations sent to MongoDB.",
+	}, func() float64 {
+		return float64(store.GetStats().SentOps)
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "todos_total",
+		Help: "Number of todos stored.",
+	}, func() float64 {
+		s := sess.Copy()
+		defer s.Close()
+
+		n, err := s.DB(cfg.DBName).C(cfg.CollectionName).Count()
+		if err != nil {
+			return 0
+		}
+		return float64(n)
+	})
+}
+
+// metricsMiddleware records the count and duration of every request, labelled
+// by its route pattern rather than its raw path to keep cardinality bounded.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		path := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			path = rctx.RoutePattern()
+		}
+
+		httpRequestsTotal.WithLabelValues(r.Method, path, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricsHandler serves the Prometheus metrics to clients within the allowed
+// CIDR ranges, or to everyone when no range is configured.
+//
+// @Summary Prometheus metrics
+// @Tags metrics
+// @Produce plain
+// @Success 200 {string} string "The metrics, in the text exposition format"
+// @Failure 403 {object} ProblemDetails
+// @Router /metrics [get]
+func metricsHandler(cidrs []string) http.Handler {
+	return restrictToCIDRs(cidrs, promhttp.Handler())
+}
+
+// mongoMetricsHandler serves the state of the MongoDB connection pool, limited
+// to clients within cidrs, like metricsHandler.
+//
+// @Summary MongoDB connection pool and server metrics
+// @Tags metrics
+// @Produce json
+// @Success 200 {object} object
+// @Failure 403 {object} ProblemDetails
+// @Router /metrics/mongodb [get]
+func mongoMetricsHandler(cidrs []string) http.Handler {
+	return restrictToCIDRs(cidrs, http.HandlerFunc(getMongoMetrics))
+}
+
+// restrictToCIDRs only lets clients within cidrs reach next, or every client
+// when cidrs is empty.
+func restrictToCIDRs(cidrs []string, next http.Handler) http.Handler {
+	var allowed []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		utils.CheckErr(err)
+		allowed = append(allowed, n)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(allowed) > 0 && !ipAllowed(net.ParseIP(clientIP(r)), allowed) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, "Access to the metrics is not allowed", ""))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getMongoMetrics reports the servers the session reaches, their version and
+// the use of the connection pool.
+func getMongoMetrics(w http.ResponseWriter, r *http.Request) {
+	s := sess.Copy()
+	defer s.Close()
+
+	info, err := s.BuildInfo()
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch MongoDB build info")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch MongoDB metrics", err.Error()))
+		return
+	}
+
+	stats := store.GetStats()
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"liveServers": s.LiveServers(),
+		"buildInfo": renderer.M{
+			"version": info.Version,
+			"gitVersion": info.GitVersion,
+			"maxObjectSize": info.MaxObjectSize,
+		},
+		"pool": renderer.M{
+			"limit": cfg.MongoPoolLimit,
+			"socketsAlive": stats.SocketsAlive,
+			"socketsInUse": stats.SocketsInUse,
+			"socketRefs": stats.SocketRefs,
+			"masterConns": stats.MasterConns,
+			"slaveConns": stats.SlaveConns,
+			"sentOps": stats.SentOps,
+			"receivedOps": stats.ReceivedOps,
+		},
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+func ipAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	for _, n := range allowed {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsRouter is a router recording metrics, with a route per outcome.
+func metricsRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(metricsMiddleware)
+
+	r.Get("/metrics-test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	r.Post("/metrics-test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	})
+
+	return r
+}
+
+func TestMetricsMiddlewareCountsRequests(t *testing.T) {
+	router := metricsRouter()
+
+	tests := []struct {
+		name		string
+		method		string
+		path		string
+		route		string
+		status		string
+		times		int
+	}{
+		{"by route pattern", http.MethodGet, "/metrics-test/1", "/metrics-test/{id}", "200", 3},
+		{"by status", http.MethodPost, "/metrics-test/2", "/metrics-test/{id}", "422", 2},
+		{"unmatched routes together", http.MethodGet, "/metrics-missing/3", "unmatched", "404", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := httpRequestsTotal.WithLabelValues(tt.method, tt.route, tt.status)
+			before := testutil.ToFloat64(counter)
+
+			for i := 0; i < tt.times; i++ {
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+			}
+
+			if got := testutil.ToFloat64(counter) - before; got != float64(tt.times) {
+				t.Errorf("http_requests_total{%s,%s,%s} grew by %v, want %d", tt.method, tt.route, tt.status, got, tt.times)
+			}
+		})
+	}
+}
+
+// observations returns how many durations the histogram of the method and
+// route holds.
+func observations(t *testing.T, method, route string) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := httpRequestDuration.WithLabelValues(method, route).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetricsMiddlewareObservesDuration(t *testing.T) {
+	router := metricsRouter()
+	before := observations(t, http.MethodGet, "/metrics-test/{id}")
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics-test/1", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics-test/2", nil))
+
+	if got := observations(t, http.MethodGet, "/metrics-test/{id}") - before; got != 2 {
+		t.Errorf("http_request_duration_seconds observed %d requests, want 2", got)
+	}
+}
+
+func TestMetricsHandlerRestrictsClients(t *testing.T) {
+	handler := metricsHandler([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		remoteAddr	string
+		status		int
+	}{
+		{"10.1.2.3:4000", http.StatusOK},
+		{"192.168.1.1:4000", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("from %s: status = %d, want %d", tt.remoteAddr, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/migrations"
+)
+
+// schemaMigrations are the changes to the database schema, applied in version
+// order on startup. Each keeps its version once released.
+var schemaMigrations = []migrations.Migration{
+	migrations.Func{V: 1, UpFunc: createIndexes, DownFunc: dropIndexes},
+	migrations.Func{V: 2, UpFunc: createFeatureFlagIndex, DownFunc: dropFeatureFlagIndex},
+	migrations.Func{V: 3, UpFunc: createAuthIndexes, DownFunc: dropAuthIndexes},
+	migrations.Func{V: 4, UpFunc: createUserEmailIndex, DownFunc: dropUserEmailIndex},
+}
+
+// runMigrations applies the pending migrations, or runs the -migrate command
+// given instead: down rolls back the latest migration, and to=N migrates up
+// or down to version N. It reports whether the server should start, which
+// it only does without a command.
+func runMigrations(command string) bool {
+	runner, err := migrations.NewRunner(db, schemaMigrations...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid migrations")
+	}
+
+	switch {
+	case command == "":
+		err = runner.Up()
+	case command == "down":
+		err = runner.Down()
+	case strings.HasPrefix(command, "to="):
+		version, convErr := strconv.Atoi(strings.TrimPrefix(command, "to="))
+		if convErr != nil {
+			log.Fatal().Str("migrate", command).Msg("the migration version must be an integer")
+		}
+		err = runner.To(version)
+	default:
+		err = errors.New("the -migrate command must be down or to=N")
+	}
+	if err != nil {
+		log.Fatal().Err(err).Str("migrate", command).Msg("failed to migrate the database")
+	}
+
+	return command == ""
+}
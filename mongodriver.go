@@ -0,0 +1,41 @@
+//go:build mongo_driver
+
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// insertTodos saves all the todos in one transaction, or none of them. On
+// failure it returns the position of the todo that could not be inserted.
+// Transactions need MongoDB to run as a replica set, or on Atlas.
+func insertTodos(ctx context.Context, todos []TodoModel) (int, error) {
+	database := db.Mongo()
+
+	session, err := database.Client().StartSession()
+	if err != nil {
+		return -1, err
+	}
+	defer session.EndSession(ctx)
+
+	failed := -1
+	collection := database.Collection(cfg.CollectionName)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// The callback is retried on transient errors, so start clean.
+		failed = -1
+
+		for i := range todos {
+			if _, err := collection.InsertOne(sc, &todos[i]); err != nil {
+				failed = i
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	return failed, err
+}
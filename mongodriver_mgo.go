@@ -0,0 +1,22 @@
+//go:build !mongo_driver
+
+package main
+
+import "context"
+
+// changeStreamEnabled is false as mgo does not support change streams.
+const changeStreamEnabled bool = false
+
+// insertTodos saves the todos in a single insert. mgo has no transactions, so
+// the position of a failing todo is unknown and -1 is returned for it.
+func insertTodos(ctx context.Context, todos []TodoModel) (int, error) {
+	docs := make([]interface{}, len(todos))
+	for i := range todos {
+		docs[i] = &todos[i]
+	}
+
+	return -1, db.WithContext(ctx).C(cfg.CollectionName).Insert(docs...)
+}
+
+// startChangeStreamWatcher is a no-op unless built with the mongo_driver tag.
+func startChangeStreamWatcher(ctx context.Context) {}
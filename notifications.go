@@ -0,0 +1,271 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/broadcast"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const notificationCollectionName string = "Notification"
+
+const notificationMention string = "mention"
+
+// mentionPattern matches the @username mentions of comments. Usernames use
+// the same characters.
+var mentionPattern = regexp.MustCompile(`@([\w.-]+)`)
+
+type(
+	NotificationModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		RecipientID		bson.ObjectId `bson:"recipientID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		TodoID			bson.ObjectId `bson:"todoID"`
+		CommentID		bson.ObjectId `bson:"commentID"`
+		Type			string `bson:"type"`
+		Read			bool `bson:"read"`
+		CreatedAt		time.Time `bson:"createdAt"`
+	}
+
+	Notification struct {
+		ID				string `json:"id"`
+		TodoID			string `json:"todoId"`
+		CommentID		string `json:"commentId"`
+		Type			string `json:"type"`
+		Read			bool `json:"read"`
+		CreatedAt		time.Time `json:"createdAt"`
+	}
+)
+
+func toNotification(n NotificationModel) Notification {
+	return Notification{
+		ID: n.ID.Hex(),
+		TodoID: n.TodoID.Hex(),
+		CommentID: n.CommentID.Hex(),
+		Type: n.Type,
+		Read: n.Read,
+		CreatedAt: n.CreatedAt,
+	}
+}
+
+// mentionedUsernames returns the usernames mentioned in body, once each.
+func mentionedUsernames(body string) []string {
+	var usernames []string
+	seen := map[string]bool{}
+
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		username := strings.ToLower(m[1])
+		if !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+
+	return usernames
+}
+
+// notifyMentions notifies, in the background, the users of the tenant
+// mentioned in a new comment, leaving out its author, and sends each
+// notification to their connected clients. Failures are only logged.
+func notifyMentions(r *http.Request, comment CommentModel) {
+	usernames := mentionedUsernames(comment.Body)
+	if len(usernames) == 0 {
+		return
+	}
+
+	filter := inTenant(r, bson.M{
+		"username": bson.M{"$in": usernames},
+		"_id": bson.M{"$ne": comment.UserID},
+		"deletedAt": nil,
+	})
+	logger := logFor(r)
+
+	go func() {
+		s := sess.Copy()
+		defer s.Close()
+
+		if err := saveMentions(s.DB(cfg.DBName), filter, comment, logger); err != nil {
+			logger.Error().Err(err).Str("commentId", comment.ID.Hex()).Msg("failed to notify mentioned users")
+		}
+	}()
+}
+
+func saveMentions(database *store.Database, filter bson.M, comment CommentModel, logger *zerolog.Logger) error {
+	var users []UserModel
+
+	if err := database.C(userCollectionName).Find(filter).Select(bson.M{"_id": 1}).All(&users); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, u := range users {
+		n := NotificationModel{
+			ID: bson.NewObjectId(),
+			RecipientID: u.ID,
+			TenantID: comment.TenantID,
+			TodoID: comment.TodoID,
+			CommentID: comment.ID,
+			Type: notificationMention,
+			CreatedAt: now,
+		}
+
+		if err := database.C(notificationCollectionName).Insert(&n); err != nil {
+			logger.Error().Err(err).Str("recipientId", u.ID.Hex()).Msg("failed to save mention notification")
+			continue
+		}
+
+		hub.Broadcast(u.ID.Hex(), broadcast.Event{
+			Type: "notification",
+			TodoID: n.TodoID.Hex(),
+			Payload: toNotification(n),
+		})
+	}
+
+	return nil
+}
+
+// recipientOf restricts filter to the notifications of the authenticated
+// user.
+func recipientOf(r *http.Request, filter bson.M) bson.M {
+	filter["recipientID"] = currentUserID(r)
+	return inTenant(r, filter)
+}
+
+// unreadNotificationCount returns the number of notifications the user has
+// not read.
+func unreadNotificationCount(r *http.Request) (n int, err error) {
+	err = timedOp(r.Context(), notificationCollectionName + ".count", func() error {
+		n, err = dbFor(r).C(notificationCollectionName).Find(recipientOf(r, bson.M{"read": false})).Count()
+		return err
+	})
+
+	return n, err
+}
+
+// fetchNotifications lists the user's notifications, unread ones first, the
+// newest first within each.
+//
+// @Summary List notifications
+// @Tags notifications
+// @Produce json
+// @Param page query int false "The page, from 1"
+// @Param limit query int false "The page size, at most 100"
+// @Success 200 {object} PageEnvelope{data=[]Notification}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /notifications [get]
+func fetchNotifications(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return
+	}
+
+	query := dbFor(r).C(notificationCollectionName).Find(recipientOf(r, bson.M{}))
+
+	var total int
+
+	if err := timedOp(r.Context(), notificationCollectionName + ".count", func() (err error) {
+		total, err = query.Count()
+		return err
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch notifications")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch notifications", err.Error()))
+		return
+	}
+
+	var notifications []NotificationModel
+
+	if err := timedOp(r.Context(), notificationCollectionName + ".find", func() error {
+		return query.Sort("read", "-createdAt", "-_id").Skip((page - 1) * limit).Limit(limit).All(&notifications)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch notifications")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch notifications", err.Error()))
+		return
+	}
+
+	notificationList := []Notification{}
+	for _, n := range notifications {
+		notificationList = append(notificationList, toNotification(n))
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": notificationList,
+		"total": total,
+		"page": page,
+		"limit": limit,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Mark a notification as read
+// @Tags notifications
+// @Produce json
+// @Param id path string true "The notification id"
+// @Success 200 {object} DataEnvelope{data=Notification}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /notifications/{id}/read [post]
+func markNotificationRead(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id is invalid", ""))
+		return
+	}
+
+	var n NotificationModel
+
+	if err := timedOp(r.Context(), notificationCollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(notificationCollectionName).Find(recipientOf(r, bson.M{
+			"_id": bson.ObjectIdHex(id),
+		})).Apply(store.Change{
+			Update: bson.M{"$set": bson.M{"read": true}},
+			ReturnNew: true,
+		}, &n)
+		return err
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Notification not found", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to mark notification read")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to mark notification read", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toNotification(n),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+func notificationHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(authMiddleware)
+	rg.Use(jsonAPIMiddleware("notifications"))
+
+	rg.Group(func(r chi.Router) {
+		r.Get("/", fetchNotifications)
+		r.Post("/{id}/read", markNotificationRead)
+	})
+
+	return rg
+}
@@ -0,0 +1,58 @@
+// @title			go-chi-mongodb-simple-todo
+// @version		2.0
+// @description	A todo list API with go, chi and MongoDB. The todo routes are
+// @description	those of /v2/todo, which /todo serves too.
+// @BasePath		/
+//
+// @securityDefinitions.apikey	BearerAuth
+// @in							header
+// @name						Authorization
+// @description				An access token from /auth/login, as "Bearer <token>".
+//
+// @securityDefinitions.apikey	APIKeyAuth
+// @in							header
+// @name						X-API-Key
+package main
+
+//go:generate go tool swag init -g openapi.go -o docs --outputTypes go,json
+
+// The envelopes of the JSON responses, for the spec in docs. Handlers build
+// them as renderer.M.
+type(
+	// DataEnvelope wraps the resource a route returns.
+	DataEnvelope struct {
+		Data			interface{} `json:"data"`
+	}
+
+	// PageEnvelope wraps a page of a list paged by offset.
+	PageEnvelope struct {
+		Data			interface{} `json:"data"`
+		Total			int `json:"total"`
+		Page			int `json:"page"`
+		Limit			int `json:"limit"`
+	}
+
+	// CursorEnvelope wraps a page of GET /todo paged by cursor. Only full
+	// pages have a nextCursor.
+	CursorEnvelope struct {
+		Data			interface{} `json:"data"`
+		NextCursor		string `json:"nextCursor,omitempty"`
+	}
+
+	// MessageResponse is the response of the routes that only confirm they
+	// succeeded.
+	MessageResponse struct {
+		Message			string `json:"message"`
+	}
+
+	// ProblemDetails is the RFC 7807 body of every error response, as written
+	// by utils.WriteProblem. Some problems have extension members too, such
+	// as the allowed values of an invalid field.
+	ProblemDetails struct {
+		Type			string `json:"type"`
+		Title			string `json:"title"`
+		Status			int `json:"status"`
+		Detail			string `json:"detail,omitempty"`
+		Instance		string `json:"instance,omitempty"`
+	}
+)
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"time"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+)
+
+const (
+	// pinLockCollectionName holds a lock per user pinning a todo.
+	pinLockCollectionName	string = "PinLock"
+	// pinLease is how long a pin lock lasts, should its holder not release it.
+	pinLease				time.Duration = 10 * time.Second
+)
+
+// lockPins takes the current user's pin lock and returns when it expires.
+// While it is held, other pins of the user fail with a duplicate key error:
+// the filter only matches an expired lock, so the upsert inserts a second
+// document with the id of the lock held.
+func lockPins(r *http.Request) (time.Time, error) {
+	now := time.Now()
+	until := now.Add(pinLease)
+
+	err := timedOp(r.Context(), pinLockCollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(pinLockCollectionName).Find(bson.M{
+			"_id": currentUserID(r),
+			"lockedUntil": bson.M{"$lte": now},
+		}).Apply(store.Change{
+			Update: bson.M{"$set": bson.M{"lockedUntil": until}},
+			Upsert: true,
+		}, nil)
+		return err
+	})
+
+	return until, err
+}
+
+// unlockPins releases the pin lock taken by lockPins, unless it expired and
+// another pin took it since.
+func unlockPins(r *http.Request, until time.Time) {
+	err := timedOp(r.Context(), pinLockCollectionName + ".remove", func() error {
+		return dbFor(r).C(pinLockCollectionName).Remove(bson.M{"_id": currentUserID(r), "lockedUntil": until})
+	})
+	if err != nil && err != store.ErrNotFound {
+		logFor(r).Warn().Err(err).Msg("failed to release pin lock")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	// positionSpacing is the gap left between todos, so that a todo can be
+	// moved between two others by taking the midpoint of their positions.
+	positionSpacing		float64 = 1000
+	// minPositionGap is the smallest gap split before the positions of the
+	// list are spread out again.
+	minPositionGap		float64 = 1e-6
+)
+
+// listScope returns the filter value matching the todos of the list, or the
+// todos without a list when listID is nil.
+func listScope(listID *bson.ObjectId) interface{} {
+	if listID == nil {
+		return nil
+	}
+	return *listID
+}
+
+// nextPosition returns the position that places a new todo last in its list.
+func nextPosition(r *http.Request, listID *bson.ObjectId) (float64, error) {
+	var last TodoModel
+
+	err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{"listID": listScope(listID)})).
+			Sort("-position").Select(bson.M{"position": 1}).One(&last)
+	})
+	if err == store.ErrNotFound {
+		return positionSpacing, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return last.Position + positionSpacing, nil
+}
+
+// reorderTodo moves the todo between the todos identified by beforeId and
+// afterId, either of which can be left out to move it to the start or the
+// end of its list.
+//
+// @Summary Move a todo between two others
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body object{beforeId=string,afterId=string} true "The todos to put it between"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/reorder [put]
+func reorderTodo(w http.ResponseWriter, r *http.Request) {
+	// Positions order the owner's lists, so only the owner reorders them.
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		BeforeID	string `json:"beforeId"`
+		AfterID		string `json:"afterId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if body.BeforeID == "" && body.AfterID == "" {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "At least one of beforeId or afterId is required", ""))
+		return
+	}
+
+	before, ok := findNeighbour(w, r, todo, body.BeforeID)
+	if !ok {
+		return
+	}
+	after, ok := findNeighbour(w, r, todo, body.AfterID)
+	if !ok {
+		return
+	}
+
+	var position float64
+	switch {
+	case before != nil && after != nil:
+		if after.Position < before.Position {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The todo identified by beforeId must come before the one identified by afterId", ""))
+			return
+		}
+		position = (before.Position + after.Position) / 2
+		if after.Position - before.Position < minPositionGap {
+			p, err := rebalancePositions(r, todo, before)
+			if err != nil {
+				logFor(r).Error().Err(err).Msg("failed to rebalance todo positions")
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to reorder todo", ""))
+				return
+			}
+			position = p
+		}
+	case before != nil:
+		position = before.Position + positionSpacing
+	default:
+		position = after.Position - positionSpacing
+	}
+
+	applyTodoUpdate(w, r, "reordered", bson.M{"_id": todo.ID}, bson.M{
+		"$set": bson.M{"position": position},
+	})
+}
+
+// findNeighbour loads the todo with the given id, which must be in the same
+// list as todo. An empty id returns nil. When the id is invalid or no todo
+// matches, the error response is written and ok is false.
+func findNeighbour(w http.ResponseWriter, r *http.Request, todo TodoModel, id string) (neighbour *TodoModel, ok bool) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil, true
+	}
+
+	if !bson.IsObjectIdHex(id) || bson.ObjectIdHex(id) == todo.ID {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id " + id + " is invalid", ""))
+		return nil, false
+	}
+
+	var t TodoModel
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{
+			"_id": bson.ObjectIdHex(id),
+			"listID": listScope(todo.ListID),
+		})).One(&t)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "No todo with id " + id + " in the same list", ""))
+			return nil, false
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todo", err.Error()))
+		return nil, false
+	}
+
+	return &t, true
+}
+
+// rebalancePositions spreads the positions of the todos in todo's list out
+// evenly again, leaving a gap right after before, and returns the position
+// of that gap.
+func rebalancePositions(r *http.Request, todo TodoModel, before *TodoModel) (float64, error) {
+	c := dbFor(r).C(cfg.CollectionName)
+
+	var todos []TodoModel
+	if err := c.Find(ownedBy(r, bson.M{
+		"listID": listScope(todo.ListID),
+		"_id": bson.M{"$ne": todo.ID},
+	})).Sort("position", "_id").Select(bson.M{"_id": 1}).All(&todos); err != nil {
+		return 0, err
+	}
+
+	bulk := c.Bulk()
+	gap := positionSpacing
+	position := positionSpacing
+
+	for _, t := range todos {
+		bulk.Update(bson.M{"_id": t.ID}, bson.M{"$set": bson.M{"position": position}})
+		if t.ID == before.ID {
+			position += positionSpacing
+			gap = position
+		}
+		position += positionSpacing
+	}
+
+	if _, err := bulk.Run(); err != nil {
+		return 0, err
+	}
+
+	return gap, nil
+}
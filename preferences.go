@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	preferencesCollectionName	string = "Preferences"
+	preferencesKey				contextKey = "preferences"
+)
+
+// preferenceFilters are the todoFilter parameters a default filter can set.
+var preferenceFilters = map[string]bool{
+	"completed": true,
+	"listId": true,
+	"tags": true,
+	"tagMatch": true,
+	"priority": true,
+	"overdue": true,
+}
+
+type(
+	// UserPreferencesModel holds a user's defaults for listing todos. Users
+	// have at most one, and those without one get the zero value.
+	UserPreferencesModel struct {
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		DefaultSort		string `bson:"defaultSort,omitempty"`
+		DefaultFilter	map[string]string `bson:"defaultFilter,omitempty"`
+		ItemsPerPage	int `bson:"itemsPerPage,omitempty"`
+		Timezone		string `bson:"timezone,omitempty"`
+		DateFormat		string `bson:"dateFormat,omitempty"`
+	}
+
+	UserPreferences struct {
+		DefaultSort		string `json:"defaultSort"`
+		DefaultFilter	map[string]string `json:"defaultFilter"`
+		ItemsPerPage	int `json:"itemsPerPage" validate:"min=0,max=100"`
+		Timezone		string `json:"timezone"`
+		DateFormat		string `json:"dateFormat" validate:"max=50"`
+	}
+)
+
+func toUserPreferences(p UserPreferencesModel) UserPreferences {
+	filter := p.DefaultFilter
+	if filter == nil {
+		filter = map[string]string{}
+	}
+
+	return UserPreferences{
+		DefaultSort: p.DefaultSort,
+		DefaultFilter: filter,
+		ItemsPerPage: p.ItemsPerPage,
+		Timezone: p.Timezone,
+		DateFormat: p.DateFormat,
+	}
+}
+
+// preferencesMiddleware loads the authenticated user's preferences into the
+// request context, for currentPreferences.
+func preferencesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefs, err := loadPreferences(r)
+		if err != nil {
+			logFor(r).Error().Err(err).Msg("failed to fetch preferences")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch preferences", ""))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), preferencesKey, prefs)))
+	})
+}
+
+// loadPreferences returns the user's stored preferences, or the zero value
+// when they have none.
+func loadPreferences(r *http.Request) (UserPreferencesModel, error) {
+	var prefs UserPreferencesModel
+
+	err := timedOp(r.Context(), preferencesCollectionName + ".find", func() error {
+		return dbFor(r).C(preferencesCollectionName).Find(ownedBy(r, bson.M{})).One(&prefs)
+	})
+	if err == store.ErrNotFound {
+		return UserPreferencesModel{}, nil
+	}
+
+	return prefs, err
+}
+
+// currentPreferences returns the preferences loaded by preferencesMiddleware.
+func currentPreferences(r *http.Request) UserPreferencesModel {
+	prefs, _ := r.Context().Value(preferencesKey).(UserPreferencesModel)
+	return prefs
+}
+
+// withPreferredDefaults returns the request with the user's default sort,
+// page size and filters added to its query where it does not set them, so
+// that the list handlers read them as if they had been sent.
+func withPreferredDefaults(r *http.Request) *http.Request {
+	prefs := currentPreferences(r)
+	query := r.URL.Query()
+
+	setDefault := func(name, value string) {
+		if value != "" && query.Get(name) == "" {
+			query.Set(name, value)
+		}
+	}
+
+	setDefault("sort", prefs.DefaultSort)
+	if prefs.ItemsPerPage > 0 {
+		setDefault("limit", strconv.Itoa(prefs.ItemsPerPage))
+	}
+	for name, value := range prefs.DefaultFilter {
+		setDefault(name, value)
+	}
+
+	u := *r.URL
+	u.RawQuery = query.Encode()
+
+	r = r.Clone(r.Context())
+	r.URL = &u
+	return r
+}
+
+// @Summary Get the user's preferences
+// @Tags user
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=UserPreferences}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/preferences [get]
+func getPreferences(w http.ResponseWriter, r *http.Request) {
+	prefs, err := loadPreferences(r)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch preferences")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch preferences", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toUserPreferences(prefs),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// updatePreferences replaces the user's preferences. The defaults are checked
+// the way fetchTodos checks its query parameters, so that they cannot make
+// every listing fail.
+//
+// @Summary Replace the user's preferences
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param body body UserPreferences true "The preferences"
+// @Success 200 {object} DataEnvelope{data=UserPreferences}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Router /user/preferences [put]
+func updatePreferences(w http.ResponseWriter, r *http.Request) {
+	var p UserPreferences
+
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	p.DefaultSort, p.Timezone = strings.TrimSpace(p.DefaultSort), strings.TrimSpace(p.Timezone)
+	if !checkInput(w, r, p) {
+		return
+	}
+
+	if p.DefaultSort != "" && p.DefaultSort != "score" {
+		if _, err := parseSort(p.DefaultSort); err != nil {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), "").With("field", "defaultSort"))
+			return
+		}
+	}
+
+	query := url.Values{}
+	for name, value := range p.DefaultFilter {
+		if !preferenceFilters[name] {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The default filter " + name + " is not supported", "").With("field", "defaultFilter"))
+			return
+		}
+		query.Set(name, value)
+	}
+	if _, err := todoFilter(&http.Request{URL: &url.URL{RawQuery: query.Encode()}}); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), "").With("field", "defaultFilter"))
+		return
+	}
+
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The timezone must be an IANA time zone such as Europe/Paris", "").With("field", "timezone"))
+			return
+		}
+	}
+
+	prefs := UserPreferencesModel{
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		DefaultSort: p.DefaultSort,
+		DefaultFilter: p.DefaultFilter,
+		ItemsPerPage: p.ItemsPerPage,
+		Timezone: p.Timezone,
+		DateFormat: p.DateFormat,
+	}
+
+	if err := timedOp(r.Context(), preferencesCollectionName + ".upsert", func() error {
+		_, err := dbFor(r).C(preferencesCollectionName).Upsert(ownedBy(r, bson.M{}), &prefs)
+		return err
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save preferences")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save preferences", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toUserPreferences(prefs),
+	})
+
+	utils.CheckErr(jsonErr)
+}
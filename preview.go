@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday/v2"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	// Previews are rendered on every keystroke pause, so each user gets 10 a
+	// minute on top of the global limit.
+	previewRateLimitRPS		float64 = 10.0 / 60
+	previewRateLimitBurst	int = 10
+)
+
+// previewPolicy is the safelist of the HTML blackfriday produces for
+// Markdown. Raw HTML in the Markdown is dropped unless it is on it too.
+var previewPolicy = newPreviewPolicy()
+
+func newPreviewPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+
+	p.AllowElements("p", "br", "hr", "strong", "em", "del", "code", "pre", "blockquote",
+		"ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6",
+		"table", "thead", "tbody", "tr", "th", "td")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	return p
+}
+
+// userKey rate limits requests by the authenticated user.
+func userKey(r *http.Request) string {
+	return currentUserID(r).Hex()
+}
+
+// previewMarkdown renders a todo description written in Markdown to safe
+// HTML, without saving anything.
+//
+// @Summary Render a description to HTML
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param body body object{markdown=string} true "The Markdown, of up to 4000 characters"
+// @Success 200 {object} object{html=string}
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Failure 429 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/preview [post]
+func previewMarkdown(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Markdown string `json:"markdown" validate:"max=4000"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	html := previewPolicy.SanitizeBytes(blackfriday.Run([]byte(body.Markdown)))
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"html": string(html),
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requestedFields parses the fields query parameter, a comma separated list of
+// the top-level todo keys to return. It returns nil when all are wanted.
+// Version 1 of the API always returns the v1Fields.
+func requestedFields(r *http.Request) []string {
+	if apiVersion(r) == 1 {
+		return v1Fields
+	}
+
+	v := r.URL.Query().Get("fields")
+	if v == "" {
+		return nil
+	}
+
+	var fields []string
+	for _, f := range strings.Split(v, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// applyProjection returns only the given top-level keys of the todo's JSON
+// representation. Unknown keys are ignored.
+func applyProjection(todo Todo, fields []string) map[string]interface{} {
+	var all map[string]interface{}
+
+	data, _ := json.Marshal(todo)
+	json.Unmarshal(data, &all)
+
+	projected := map[string]interface{}{}
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			projected[f] = v
+		}
+	}
+
+	return projected
+}
+
+// projectTodo returns the todo limited to the fields the request asked for.
+func projectTodo(r *http.Request, todo Todo) interface{} {
+	fields := requestedFields(r)
+	if fields == nil {
+		return todo
+	}
+
+	return applyProjection(todo, fields)
+}
+
+// projectTodos returns the todos limited to the fields the request asked for.
+func projectTodos(r *http.Request, todos []Todo) interface{} {
+	fields := requestedFields(r)
+	if fields == nil {
+		return todos
+	}
+
+	projected := []map[string]interface{}{}
+	for _, t := range todos {
+		projected = append(projected, applyProjection(t, fields))
+	}
+
+	return projected
+}
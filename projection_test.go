@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testTodo is a todo with most of its fields set.
+func testTodo() Todo {
+	return Todo{
+		ID: "64b7f0c2e4b0a1a2b3c4d5e6",
+		Title: "Write the report",
+		Completed: true,
+		CreatedAt: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+		Tags: []string{"work"},
+		Priority: "high",
+		Description: "A long description",
+		Subtasks: []Subtask{{ID: "64b7f0c2e4b0a1a2b3c4d5e7", Title: "Outline"}},
+	}
+}
+
+// renderedKeys returns the keys of v rendered as a JSON object, sorted.
+func renderedKeys(t *testing.T, v interface{}) []string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	keys := []string{}
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func TestProjectTodoKeepsOnlyRequestedFields(t *testing.T) {
+	tests := []struct {
+		name		string
+		fields		string
+		want		[]string
+	}{
+		{"some fields", "id,title,completed", []string{"completed", "id", "title"}},
+		{"spaces and empty entries", "%20id%20,%20,title", []string{"id", "title"}},
+		{"unknown fields are ignored", "id,secret,title", []string{"id", "title"}},
+		{"only unknown fields", "secret", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/todo/1?fields=" + tt.fields, nil)
+
+			if got := renderedKeys(t, projectTodo(r, testTodo())); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectTodoWithoutFieldsReturnsWholeTodo(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/todo/1", nil)
+
+	got := renderedKeys(t, projectTodo(r, testTodo()))
+	if want := renderedKeys(t, testTodo()); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	present := map[string]bool{}
+	for _, key := range got {
+		present[key] = true
+	}
+	for _, key := range []string{"description", "subtasks", "tags"} {
+		if !present[key] {
+			t.Errorf("%s is missing from the whole todo %v", key, got)
+		}
+	}
+}
+
+func TestProjectTodosExcludesFieldsFromEveryTodo(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/todo?fields=id,title", nil)
+
+	data, err := json.Marshal(projectTodos(r, []Todo{testTodo(), testTodo()}))
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+
+	var list []map[string]interface{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	if len(list) != 2 {
+		t.Fatalf("got %d todos, want 2", len(list))
+	}
+	for i, todo := range list {
+		for _, excluded := range []string{"description", "subtasks", "tags", "completed", "createdAt"} {
+			if _, ok := todo[excluded]; ok {
+				t.Errorf("todo %d has %s, which was not requested", i, excluded)
+			}
+		}
+		if todo["title"] != "Write the report" {
+			t.Errorf("todo %d title = %v, want Write the report", i, todo["title"])
+		}
+	}
+}
+
+func TestRequestedFieldsVersion1(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/todo?fields=id", nil)
+	r = r.WithContext(context.WithValue(r.Context(), apiVersionKey, 1))
+
+	if got := requestedFields(r); !reflect.DeepEqual(got, v1Fields) {
+		t.Errorf("requestedFields = %v, want %v", got, v1Fields)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// maxQuickCaptureLength is the most characters a quick capture can hold.
+const maxQuickCaptureLength int = 500
+
+// maxDueDateWords is the length of the longest due date phrase, in 3 days.
+const maxDueDateWords int = 3
+
+// quickCaptureTodo creates a todo from a plain text body, such as
+// "Buy milk tomorrow #shopping". Hashtags become tags, and the first due date
+// found, any that utils.ParseDueDate reads, the due date; both are stripped
+// from the title. The body's content type is ignored, so curl -d works as is.
+//
+// @Summary Create a todo from a line of text
+// @Tags todos
+// @Accept plain
+// @Produce json
+// @Param body body string true "The text, with #tags, !priority and a due date phrase"
+// @Success 201 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/quick [post]
+func quickCaptureTodo(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "Failed to read the body", ""))
+		return
+	}
+
+	text := strings.TrimSpace(string(data))
+	if !utf8.ValidString(text) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The text must be UTF-8", ""))
+		return
+	}
+	if utf8.RuneCountInString(text) > maxQuickCaptureLength {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The text must not exceed 500 characters", ""))
+		return
+	}
+
+	t := Todo{Title: text}
+	extractHashtags(&t)
+	t.Title, t.DueDate = extractDueDate(t.Title)
+
+	tm, ok := saveNewTodo(w, r, t)
+	if !ok {
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": projectTodo(r, toLocalTodo(r, tm)),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// extractDueDate finds the first due date of text, preferring the longest
+// phrase at each word, and returns text without it.
+func extractDueDate(text string) (string, *time.Time) {
+	words := strings.Fields(text)
+
+	for i := range words {
+		for n := maxDueDateWords; n > 0; n-- {
+			if i+n > len(words) {
+				continue
+			}
+
+			phrase := strings.TrimRight(strings.Join(words[i:i+n], " "), ".,;:!?")
+			date, err := utils.ParseDueDate(phrase)
+			if err != nil {
+				continue
+			}
+
+			rest := append(words[:i:i], words[i+n:]...)
+			return strings.Join(rest, " "), date
+		}
+	}
+
+	return text, nil
+}
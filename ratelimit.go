@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	rateLimiterIdleTTL		time.Duration = 3 * time.Minute
+)
+
+// rateLimiter hands out a token bucket per client key.
+type rateLimiter struct {
+	limiters	sync.Map
+	rps			rate.Limit
+	burst		int
+	key			func(r *http.Request) string
+	exempt		map[string]bool
+}
+
+type clientLimiter struct {
+	limiter		*rate.Limiter
+	mu			sync.Mutex
+	lastSeen	time.Time
+}
+
+// newRateLimiter creates a limiter allowing rps requests per second with the
+// given burst for each key, and starts the cleanup of idle keys.
+func newRateLimiter(rps float64, burst int, key func(r *http.Request) string) *rateLimiter {
+	l := &rateLimiter{
+		rps: rate.Limit(rps),
+		burst: burst,
+		key: key,
+	}
+
+	go l.cleanup()
+
+	return l
+}
+
+// clientIP keys requests by the client's IP address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Except exempts the given paths from the limiter, for routes that enforce a
+// limit of their own. The paths are matched under every API version prefix.
+func (l *rateLimiter) Except(paths ...string) *rateLimiter {
+	if l.exempt == nil {
+		l.exempt = map[string]bool{}
+	}
+	for _, p := range paths {
+		l.exempt[p] = true
+	}
+
+	return l
+}
+
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.exempt[unversionedPath(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v, _ := l.limiters.LoadOrStore(l.key(r), &clientLimiter{
+			limiter: rate.NewLimiter(l.rps, l.burst),
+		})
+		c := v.(*clientLimiter)
+
+		c.mu.Lock()
+		c.lastSeen = time.Now()
+		c.mu.Unlock()
+
+		res := c.limiter.Reserve()
+		if delay := res.Delay(); !res.OK() || delay > 0 {
+			res.Cancel()
+
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if !res.OK() || retryAfter < 1 {
+				retryAfter = 1
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusTooManyRequests, "Too many requests", ""))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cleanup drops the buckets of clients that have been idle for a while.
+func (l *rateLimiter) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.limiters.Range(func(key, v interface{}) bool {
+			c := v.(*clientLimiter)
+
+			c.mu.Lock()
+			idle := time.Since(c.lastSeen) > rateLimiterIdleTTL
+			c.mu.Unlock()
+
+			if idle {
+				l.limiters.Delete(key)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	viewCollectionName	string = "View"
+	recentTodosLimit	int = 20
+	maxRecentViews		int = 100
+)
+
+// ViewModel records when a user last viewed one of their todos.
+type ViewModel struct {
+	ID				bson.ObjectId `bson:"_id,omitempty"`
+	UserID			bson.ObjectId `bson:"userID"`
+	TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+	TodoID			bson.ObjectId `bson:"todoID"`
+	ViewedAt		time.Time `bson:"viewedAt"`
+}
+
+// recentViewMiddleware records a view of the todo identified by {id} when
+// the request succeeds.
+func recentViewMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		id := strings.TrimSpace(chi.URLParam(r, "id"))
+		if (ww.Status() == http.StatusOK || ww.Status() == http.StatusNotModified) && bson.IsObjectIdHex(id) {
+			recordView(r, bson.ObjectIdHex(id))
+		}
+	})
+}
+
+// recordView stores the view in the background, so the request is not held
+// up, keeping the maxRecentViews most recent views of each user. Failures
+// are only logged.
+func recordView(r *http.Request, todoID bson.ObjectId) {
+	userID := currentUserID(r)
+	selector := ownedBy(r, bson.M{"todoID": todoID})
+	logger := logFor(r)
+
+	go func() {
+		s := sess.Copy()
+		defer s.Close()
+
+		c := s.DB(cfg.DBName).C(viewCollectionName)
+
+		if _, err := c.Upsert(selector, bson.M{
+			"$set": bson.M{"viewedAt": time.Now()},
+		}); err != nil {
+			logger.Warn().Err(err).Str("todoId", todoID.Hex()).Msg("failed to record todo view")
+			return
+		}
+
+		var oldest ViewModel
+		if err := c.Find(bson.M{"userID": userID}).Sort("-viewedAt").Skip(maxRecentViews).One(&oldest); err != nil {
+			return
+		}
+
+		if _, err := c.RemoveAll(bson.M{"userID": userID, "viewedAt": bson.M{"$lte": oldest.ViewedAt}}); err != nil {
+			logger.Warn().Err(err).Msg("failed to trim todo views")
+		}
+	}()
+}
+
+// fetchRecentTodos lists the todos the user viewed most recently, the latest
+// first.
+//
+// @Summary List recently viewed todos
+// @Tags todos
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=[]Todo}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/recent [get]
+func fetchRecentTodos(w http.ResponseWriter, r *http.Request) {
+	var views []ViewModel
+
+	if err := timedOp(r.Context(), viewCollectionName + ".find", func() error {
+		return dbFor(r).C(viewCollectionName).Find(ownedBy(r, bson.M{})).Sort("-viewedAt").Limit(recentTodosLimit).All(&views)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch recent todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch recent todos", err.Error()))
+		return
+	}
+
+	ids := make([]bson.ObjectId, len(views))
+	for i, v := range views {
+		ids[i] = v.TodoID
+	}
+
+	var found []TodoModel
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(ownedBy(r, bson.M{
+			"_id": bson.M{"$in": ids}, "archived": bson.M{"$ne": true},
+		})).All(&found)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch recent todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch recent todos", err.Error()))
+		return
+	}
+
+	byID := map[bson.ObjectId]TodoModel{}
+	for _, t := range found {
+		byID[t.ID] = t
+	}
+
+	// Deleted todos drop out of the list.
+	todos := []TodoModel{}
+	for _, id := range ids {
+		if t, ok := byID[id]; ok {
+			todos = append(todos, t)
+		}
+	}
+
+	list := listedTodos(todos)
+	if err := decorateTodos(r, todos, list); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch recent todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch recent todos", err.Error()))
+		return
+	}
+
+	if list == nil {
+		list = []Todo{}
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": projectTodos(r, list),
+	})
+
+	utils.CheckErr(jsonErr)
+}
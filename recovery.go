@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// recoveryMiddleware turns a panicking handler into an HTTP 500 response
+// instead of letting it crash the server. The panic value and stack trace are
+// logged but never sent to the client.
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			panicsTotal.Inc()
+
+			// The request id middleware runs inside this one, so its id is
+			// only visible on the response.
+			requestID := w.Header().Get(utils.RequestIDHeader)
+			log.Error().
+				Str("requestId", requestID).
+				Interface("panic", rec).
+				Str("stack", string(debug.Stack())).
+				Msg("recovered from panic")
+
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusInternalServerError, "internal server error", "").
+				With("requestId", requestID))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
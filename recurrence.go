@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/teambition/rrule-go"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+)
+
+const recurrenceCheckInterval time.Duration = 24 * time.Hour
+
+// nextOccurrence returns the first occurrence of the recurrence rule, such as
+// FREQ=WEEKLY;BYDAY=MO, after the given time. Rules without a DTSTART start
+// from start, which sets the time of day of the occurrences. It returns nil
+// when the rule has no more occurrences.
+func nextOccurrence(rule string, start, after time.Time) (*time.Time, error) {
+	opt, err := rrule.StrToROption(rule)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Dtstart.IsZero() {
+		opt.Dtstart = start
+	}
+
+	rr, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return nil, err
+	}
+
+	next := rr.After(after, false)
+	if next.IsZero() {
+		return nil, nil
+	}
+
+	return &next, nil
+}
+
+// continueRecurrence creates the next occurrence of a recurring todo that the
+// write from before to after completed. The completion has already been
+// saved, so failures are only logged.
+func continueRecurrence(r *http.Request, before, after TodoModel) {
+	if before.Completed || !after.Completed || after.Recurrence == "" || after.NextOccurrence == nil {
+		return
+	}
+
+	next, err := spawnOccurrence(dbFor(r).C(cfg.CollectionName), after)
+	if err != nil {
+		logFor(r).Error().Err(err).Str("todoId", after.ID.Hex()).Msg("failed to create the next occurrence")
+		return
+	}
+
+	if next != nil {
+		invalidateTodoCache(r, next.UserID)
+		publishTodoEvent(r, "created", *next, toTodo(*next))
+		recordAudit(r, "created", nil, next)
+	}
+}
+
+// spawnOccurrence creates the occurrence of t due at t.NextOccurrence and
+// hands the recurrence over to it, so that each occurrence is created once.
+// It returns nil when another request already created it.
+func spawnOccurrence(c *store.Collection, t TodoModel) (*TodoModel, error) {
+	if _, err := c.Find(bson.M{
+		"_id": t.ID,
+		"recurrence": t.Recurrence,
+		"nextOccurrence": t.NextOccurrence,
+	}).Apply(store.Change{
+		Update: bson.M{
+			"$unset": bson.M{"recurrence": "", "nextOccurrence": ""},
+			"$inc": bson.M{"__v": 1},
+		},
+	}, &TodoModel{}); err != nil {
+		if err == store.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	at := *t.NextOccurrence
+	now := time.Now()
+
+	next, err := nextOccurrence(t.Recurrence, at, at)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrence := TodoModel{
+		ID: bson.NewObjectId(),
+		UserID: t.UserID,
+		TenantID: t.TenantID,
+		Title: t.Title,
+		Status: statusBacklog,
+		CreatedAt: at,
+		UpdatedAt: now,
+		Tags: t.Tags,
+		Priority: t.Priority,
+		PriorityOrder: t.PriorityOrder,
+		Description: t.Description,
+		ListID: t.ListID,
+		Position: t.Position,
+		EstimatedMinutes: t.EstimatedMinutes,
+		Recurrence: t.Recurrence,
+		NextOccurrence: next,
+	}
+
+	// The due date keeps the same distance from the start of the occurrence.
+	if t.DueDate != nil {
+		due := t.DueDate.Add(at.Sub(t.CreatedAt))
+		occurrence.DueDate = &due
+	}
+
+	for _, st := range t.Subtasks {
+		occurrence.Subtasks = append(occurrence.Subtasks, SubtaskModel{ID: bson.NewObjectId(), Title: st.Title})
+	}
+
+	if err := c.Insert(&occurrence); err != nil {
+		return nil, err
+	}
+
+	return &occurrence, nil
+}
+
+// runRecurrenceScheduler creates the occurrences that have come due, once on
+// start and then daily, until ctx is done.
+func runRecurrenceScheduler(ctx context.Context) {
+	ticker := time.NewTicker(recurrenceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		createDueOccurrences()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func createDueOccurrences() {
+	s := sess.Copy()
+	defer s.Close()
+
+	c := s.DB(cfg.DBName).C(cfg.CollectionName)
+
+	iter := c.Find(bson.M{
+		"recurrence": bson.M{"$exists": true},
+		"nextOccurrence": bson.M{"$lte": time.Now()},
+		"archived": bson.M{"$ne": true},
+	}).Iter()
+
+	for {
+		var t TodoModel
+		if !iter.Next(&t) {
+			break
+		}
+
+		next, err := spawnOccurrence(c, t)
+		if err != nil {
+			log.Error().Err(err).Str("todoId", t.ID.Hex()).Msg("failed to create the next occurrence")
+			continue
+		}
+		if next != nil {
+			broadcastTodoEvent(&log.Logger, "created", *next, toTodo(*next))
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to fetch recurring todos")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/flags"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// newScoringFlag is the feature flag making overdue todos rank higher the
+// longer they are overdue.
+const newScoringFlag string = "new_scoring"
+
+// scoreWeights weigh the parts of a todo's score. Each can be overridden
+// with a weight<Name> query parameter, such as weightDueDate=2.
+type scoreWeights struct {
+	Priority		float64
+	DueDate			float64
+	Age				float64
+	Estimate		float64
+	Blocked			float64
+}
+
+var defaultScoreWeights = scoreWeights{
+	Priority: 3,
+	DueDate: 4,
+	Age: 1,
+	Estimate: 1,
+	Blocked: 5,
+}
+
+// parseScoreWeights reads the weights of the request, keeping the defaults
+// for those it does not set.
+func parseScoreWeights(r *http.Request) (scoreWeights, error) {
+	weights := defaultScoreWeights
+
+	for name, weight := range map[string]*float64{
+		"weightPriority": &weights.Priority,
+		"weightDueDate": &weights.DueDate,
+		"weightAge": &weights.Age,
+		"weightEstimate": &weights.Estimate,
+		"weightBlocked": &weights.Blocked,
+	} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 || math.IsInf(n, 0) {
+			return weights, errors.New("The " + name + " must be a non-negative number")
+		}
+		*weight = n
+	}
+
+	return weights, nil
+}
+
+// todoScore ranks how pressing the todo is to work on next, higher first:
+//
+//	score = weights.Priority * priority
+//	      + weights.DueDate  * due
+//	      + weights.Age      * age
+//	      + weights.Estimate * estimate
+//	      - weights.Blocked  * blocked
+//
+// where each part is between 0 and 1:
+//   - priority is 1 for critical, 2/3 for high, 1/3 for medium and 0 for low;
+//   - due is 1 once the todo is due and 1 / (1 + days left) before, and 0
+//     without a due date;
+//   - age grows from 0 when the todo is created to 1 after 30 days;
+//   - estimate favours quick wins, 1 / (1 + estimated minutes / 30), and is
+//     0 without an estimate;
+//   - blocked is 1 while a todo it depends on is not done.
+//
+// With newScoring, due keeps growing once the todo is due, up to 2 a week
+// later.
+func todoScore(t Todo, weights scoreWeights, now time.Time, newScoring bool) float64 {
+	order, ok := priorityOrder[t.Priority]
+	if !ok {
+		order = priorityOrder["medium"]
+	}
+	priority := float64(3 - order) / 3
+
+	due := 0.0
+	if t.DueDate != nil {
+		days := t.DueDate.Sub(now).Hours() / 24
+		due = 1 / (1 + math.Max(days, 0))
+		if newScoring && days < 0 {
+			due += math.Min(-days / 7, 1)
+		}
+	}
+
+	age := math.Min(now.Sub(t.CreatedAt).Hours() / 24 / 30, 1)
+
+	estimate := 0.0
+	if t.EstimatedMinutes != nil {
+		estimate = 1 / (1 + float64(*t.EstimatedMinutes) / 30)
+	}
+
+	blocked := 0.0
+	if t.IsBlocked {
+		blocked = 1
+	}
+
+	return weights.Priority * priority +
+		weights.DueDate * due +
+		weights.Age * age +
+		weights.Estimate * estimate -
+		weights.Blocked * blocked
+}
+
+// loadScoredTodoPage is loadTodoPage ordered by todoScore, highest first. The
+// score depends on the time and on other todos, so every matching todo is
+// scored before the page is cut.
+func loadScoredTodoPage(w http.ResponseWriter, r *http.Request, filter bson.M) (renderer.M, bool) {
+	page, limit, err := parsePagination(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return nil, false
+	}
+
+	weights, err := parseScoreWeights(r)
+	if err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, err.Error(), ""))
+		return nil, false
+	}
+
+	var todos []TodoModel
+
+	span := startMongoSpan(r, "mongo.find", filter)
+	err = timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(filter).Sort("_id").All(&todos)
+	})
+	endSpan(span, err)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	list := listedTodos(todos)
+	if err := decorateTodos(r, todos, list); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch Todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo", err.Error()))
+		return nil, false
+	}
+
+	now := time.Now()
+	newScoring := flags.IsEnabled(r.Context(), newScoringFlag)
+	for i := range list {
+		list[i].Score = todoScore(list[i], weights, now, newScoring)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Score > list[j].Score })
+
+	start := (page - 1) * limit
+	if start > len(list) {
+		start = len(list)
+	}
+	end := start + limit
+	if end > len(list) {
+		end = len(list)
+	}
+
+	return renderer.M{
+		"data": projectTodos(r, list[start:end]),
+		"total": len(list),
+		"page": page,
+		"limit": limit,
+	}, true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTodoScoreNewScoringRanksOverdueTodosHigher(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	due := func(days float64) *time.Time {
+		d := now.Add(time.Duration(days * 24 * float64(time.Hour)))
+		return &d
+	}
+	weights := scoreWeights{DueDate: 1}
+
+	justDue := Todo{Priority: "low", CreatedAt: now, DueDate: due(0)}
+	overdue := Todo{Priority: "low", CreatedAt: now, DueDate: due(-3.5)}
+	longOverdue := Todo{Priority: "low", CreatedAt: now, DueDate: due(-30)}
+	upcoming := Todo{Priority: "low", CreatedAt: now, DueDate: due(1)}
+
+	tests := []struct {
+		name		string
+		todo		Todo
+		old			float64
+		new			float64
+	}{
+		{"upcoming", upcoming, 0.5, 0.5},
+		{"just due", justDue, 1, 1},
+		{"half a week overdue", overdue, 1, 1.5},
+		{"capped a week after", longOverdue, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := todoScore(tt.todo, weights, now, false); got != tt.old {
+				t.Errorf("score without new_scoring = %v, want %v", got, tt.old)
+			}
+			if got := todoScore(tt.todo, weights, now, true); got != tt.new {
+				t.Errorf("score with new_scoring = %v, want %v", got, tt.new)
+			}
+		})
+	}
+}
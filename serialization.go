@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const msgpackContentType string = "application/msgpack"
+
+// serializationMiddleware lets clients use MessagePack instead of JSON. Bodies
+// sent as application/msgpack reach the handlers as JSON, and the JSON
+// responses of clients accepting application/msgpack are sent as MessagePack,
+// cached pages and problems included. Documents keep the keys of the JSON,
+// which the msgpack tags of Todo match, and times stay RFC 3339 strings. Other
+// responses, such as CSV exports and event streams, are left alone.
+func serializationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), msgpackContentType) && r.Body != nil {
+			body, err := msgpackToJSON(r.Body)
+			if err != nil {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The body is not valid MessagePack", err.Error()))
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+
+		if !strings.Contains(r.Header.Get("Accept"), msgpackContentType) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mw := &msgpackResponseWriter{ResponseWriter: w}
+		defer mw.Close()
+
+		next.ServeHTTP(mw, r)
+	})
+}
+
+// msgpackToJSON reads a MessagePack document and returns it as JSON.
+func msgpackToJSON(body io.Reader) ([]byte, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return raw, nil
+	}
+
+	var v interface{}
+	if err := msgpack.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// jsonToMsgpack returns the JSON document as MessagePack. Whole numbers stay
+// integers rather than becoming floats.
+func jsonToMsgpack(body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(msgpackValue(v)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func msgpackValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, field := range v {
+			v[k] = msgpackValue(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = msgpackValue(item)
+		}
+	}
+
+	return v
+}
+
+// msgpackResponseWriter holds back JSON responses, problems included, to
+// send them as MessagePack once complete.
+type msgpackResponseWriter struct {
+	http.ResponseWriter
+	status		int
+	buf			bytes.Buffer
+	buffering	bool
+	passthrough	bool
+}
+
+func (m *msgpackResponseWriter) WriteHeader(code int) {
+	if m.status == 0 {
+		m.status = code
+	}
+}
+
+func (m *msgpackResponseWriter) Write(p []byte) (int, error) {
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+
+	if m.buffering {
+		return m.buf.Write(p)
+	}
+	if m.passthrough {
+		return m.ResponseWriter.Write(p)
+	}
+
+	contentType := m.Header().Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, utils.ProblemContentType) {
+		m.buffering = true
+		return m.buf.Write(p)
+	}
+
+	m.startPassthrough()
+	return m.ResponseWriter.Write(p)
+}
+
+func (m *msgpackResponseWriter) startPassthrough() {
+	m.passthrough = true
+	m.ResponseWriter.WriteHeader(m.status)
+}
+
+// Close sends the response held back. Should it not be valid JSON after all,
+// it is sent as it was written.
+func (m *msgpackResponseWriter) Close() error {
+	if m.passthrough || m.status == 0 {
+		return nil
+	}
+	if !m.buffering {
+		m.startPassthrough()
+		return nil
+	}
+
+	body, err := jsonToMsgpack(m.buf.Bytes())
+	if err != nil {
+		body = m.buf.Bytes()
+	} else {
+		m.Header().Set("Content-Type", msgpackContentType)
+	}
+	m.Header().Del("Content-Length")
+
+	m.startPassthrough()
+	_, err = m.ResponseWriter.Write(body)
+
+	return err
+}
+
+// Flush sends responses that are not held back, such as event streams, as
+// they are written.
+func (m *msgpackResponseWriter) Flush() {
+	if m.buffering {
+		return
+	}
+	if !m.passthrough {
+		if m.status == 0 {
+			m.status = http.StatusOK
+		}
+		m.startPassthrough()
+	}
+
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (m *msgpackResponseWriter) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
+func (m *msgpackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := m.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+
+	m.passthrough = true
+	return h.Hijack()
+}
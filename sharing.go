@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	shareCollectionName	string = "Share"
+	permissionView		string = "view"
+	permissionEdit		string = "edit"
+)
+
+type(
+	// ShareModel gives the recipient access to one of the owner's todos.
+	ShareModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		TodoID			bson.ObjectId `bson:"todoID"`
+		OwnerID			bson.ObjectId `bson:"ownerID"`
+		RecipientID		bson.ObjectId `bson:"recipientID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Permission		string `bson:"permission"`
+		Token			string `bson:"token"`
+		CreatedAt		time.Time `bson:"createdAt"`
+	}
+
+	Share struct {
+		ID				string `json:"id"`
+		TodoID			string `json:"todoId"`
+		Email			string `json:"email"`
+		Permission		string `json:"permission"`
+		Token			string `json:"token"`
+		CreatedAt		time.Time `json:"createdAt"`
+	}
+)
+
+// shareTodo shares the todo identified by {id} with the user registered with
+// the given email. Sharing it again with the same user changes the
+// permission.
+//
+// @Summary Share a todo with another user
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body object{email=string,permission=string} true "The user's email, and view or edit"
+// @Success 201 {object} DataEnvelope{data=Share}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/share [post]
+func shareTodo(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findTodo(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Email			string `json:"email" validate:"required,email"`
+		Permission		string `json:"permission" validate:"required,oneof=view edit"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	body.Email = strings.ToLower(strings.TrimSpace(body.Email))
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	var recipient UserModel
+
+	if err := timedOp(r.Context(), userCollectionName + ".find", func() error {
+		return dbFor(r).C(userCollectionName).Find(inTenant(r, bson.M{"email": body.Email, "deletedAt": nil})).One(&recipient)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "No user is registered with this email", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to share todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to share todo", ""))
+		return
+	}
+
+	if recipient.ID == todo.UserID {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "A todo cannot be shared with its owner", ""))
+		return
+	}
+
+	var share ShareModel
+
+	if err := timedOp(r.Context(), shareCollectionName + ".findAndModify", func() error {
+		_, err := dbFor(r).C(shareCollectionName).Find(inTenant(r, bson.M{
+			"todoID": todo.ID, "recipientID": recipient.ID,
+		})).Apply(store.Change{
+			Update: bson.M{
+				"$set": bson.M{"permission": body.Permission},
+				"$setOnInsert": bson.M{
+					"ownerID": todo.UserID,
+					"token": randomToken(),
+					"createdAt": time.Now(),
+				},
+			},
+			Upsert: true,
+			ReturnNew: true,
+		}, &share)
+		return err
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to share todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to share todo", ""))
+		return
+	}
+
+	// The todo now shows in the recipient's lists.
+	invalidateTodoCache(r, recipient.ID)
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": Share{
+			ID: share.ID.Hex(),
+			TodoID: share.TodoID.Hex(),
+			Email: recipient.Email,
+			Permission: share.Permission,
+			Token: share.Token,
+			CreatedAt: share.CreatedAt,
+		},
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// sharedPermissions returns the permission the user has on each todo shared
+// with them.
+func sharedPermissions(r *http.Request) (map[bson.ObjectId]string, error) {
+	var shares []ShareModel
+
+	if err := timedOp(r.Context(), shareCollectionName + ".find", func() error {
+		return dbFor(r).C(shareCollectionName).Find(inTenant(r, bson.M{
+			"recipientID": currentUserID(r),
+		})).Select(bson.M{"todoID": 1, "permission": 1}).All(&shares)
+	}); err != nil {
+		return nil, err
+	}
+
+	permissions := map[bson.ObjectId]string{}
+	for _, s := range shares {
+		permissions[s.TodoID] = s.Permission
+	}
+
+	return permissions, nil
+}
+
+// visibleTo restricts filter to the user's own todos and the todos shared
+// with them.
+func visibleTo(r *http.Request, filter bson.M) (bson.M, error) {
+	permissions, err := sharedPermissions(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(permissions) == 0 {
+		return ownedBy(r, filter), nil
+	}
+
+	shared := make([]bson.ObjectId, 0, len(permissions))
+	for id := range permissions {
+		shared = append(shared, id)
+	}
+
+	filter = inTenant(r, filter)
+	filter["$or"] = []bson.M{
+		{"userID": currentUserID(r)},
+		{"_id": bson.M{"$in": shared}},
+	}
+
+	return filter, nil
+}
+
+// setPermissions sets the permission the user has on the todos in list,
+// converted from todos, that are shared with them.
+func setPermissions(r *http.Request, todos []TodoModel, list []Todo) error {
+	userID := currentUserID(r)
+
+	shared := false
+	for _, t := range todos {
+		shared = shared || t.UserID != userID
+	}
+	if !shared {
+		return nil
+	}
+
+	permissions, err := sharedPermissions(r)
+	if err != nil {
+		return err
+	}
+
+	for i, t := range todos {
+		if t.UserID != userID {
+			list[i].Permission = permissions[t.ID]
+		}
+	}
+
+	return nil
+}
+
+// findAccessibleTodo is findTodo for todos shared with the user as well.
+// Shared todos need the edit permission when edit is true; with only the
+// view permission the error response is written and ok is false.
+func findAccessibleTodo(w http.ResponseWriter, r *http.Request, edit bool) (todo TodoModel, ok bool) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id is invalid", ""))
+		return todo, false
+	}
+
+	var share ShareModel
+
+	err := timedOp(r.Context(), cfg.CollectionName + ".find", func() error {
+		return dbFor(r).C(cfg.CollectionName).Find(inTenant(r, bson.M{"_id": bson.ObjectIdHex(id)})).One(&todo)
+	})
+	if err == nil && todo.UserID != currentUserID(r) {
+		err = timedOp(r.Context(), shareCollectionName + ".find", func() error {
+			return dbFor(r).C(shareCollectionName).Find(bson.M{
+				"todoID": todo.ID, "recipientID": currentUserID(r),
+			}).One(&share)
+		})
+	}
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Todo not found", ""))
+			return todo, false
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch todo")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch todo", err.Error()))
+		return todo, false
+	}
+
+	if edit && share.Permission == permissionView {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, "The todo is only shared with you to view", ""))
+		return todo, false
+	}
+
+	return todo, true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// inFlight tracks the requests being handled so shutdown can wait for them.
+var inFlight sync.WaitGroup
+
+// shuttingDown is closed when shutdown starts, for long-lived streams that
+// would otherwise keep the server waiting until the shutdown timeout.
+var shuttingDown = make(chan struct{})
+
+// inFlightMiddleware counts the request in inFlight while it is handled.
+func inFlightMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Done()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitForInFlight blocks until every in-flight request has finished or ctx is
+// done, whichever comes first.
+func waitForInFlight(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowServer serves a request that takes delay to complete, counted in
+// inFlight. started is closed once the handler is running, finished once it
+// has written the response.
+func slowServer(delay time.Duration) (srv *httptest.Server, started, finished chan struct{}) {
+	started, finished = make(chan struct{}), make(chan struct{})
+
+	srv = httptest.NewServer(inFlightMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(delay)
+		w.Write([]byte("done"))
+		close(finished)
+	})))
+
+	return srv, started, finished
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	srv, started, finished := slowServer(300 * time.Millisecond)
+	defer srv.Close()
+
+	type result struct {
+		status		int
+		body		string
+		err			error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		results <- result{status: resp.StatusCode, body: string(body), err: err}
+	}()
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+
+	// The same sequence as main: stop accepting connections, then wait for
+	// the requests still running.
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := waitForInFlight(ctx); err != nil {
+		t.Fatalf("waitForInFlight: %v", err)
+	}
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("shutdown returned before the slow request completed")
+	}
+
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatalf("the slow request failed: %v", res.err)
+		}
+		if res.status != http.StatusOK || res.body != "done" {
+			t.Errorf("response = %d %q, want 200 \"done\"", res.status, res.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow request got no response")
+	}
+
+	if _, err := http.Get(srv.URL); err == nil {
+		t.Error("the server accepted a request after shutting down")
+	}
+}
+
+func TestWaitForInFlightGivesUpAtTimeout(t *testing.T) {
+	srv, started, _ := slowServer(time.Second)
+	defer srv.Close()
+
+	go http.Get(srv.URL)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+
+	begin := time.Now()
+	if err := waitForInFlight(ctx); err != context.DeadlineExceeded {
+		t.Errorf("waitForInFlight = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(begin); elapsed > 500 * time.Millisecond {
+		t.Errorf("waitForInFlight took %v, want it to stop at the timeout", elapsed)
+	}
+
+	// Let the request finish so it is not counted by other tests.
+	if err := waitForInFlight(context.Background()); err != nil {
+		t.Fatalf("waitForInFlight: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/notifications"
+)
+
+const overdueCheckInterval time.Duration = 24 * time.Hour
+
+var slack *notifications.SlackNotifier
+
+// notifySlackOfTodo posts newly created high and critical priority todos to
+// Slack, in the background so the request is not held up.
+func notifySlackOfTodo(logger *zerolog.Logger, tm TodoModel) {
+	if !slack.Enabled() || priorityOrder[tm.Priority] > priorityOrder["high"] {
+		return
+	}
+
+	go func() {
+		if err := slack.Notify(slackEvent("created", tm)); err != nil {
+			logger.Warn().Err(err).Str("todoId", tm.ID.Hex()).Msg("failed to notify Slack")
+		}
+	}()
+}
+
+// runOverdueNotifier posts the todos that became overdue to Slack once a
+// day until ctx is done. Each run covers the todos that fell due since the
+// previous one, so every todo is posted once.
+func runOverdueNotifier(ctx context.Context) {
+	if !slack.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(overdueCheckInterval)
+	defer ticker.Stop()
+
+	since := time.Now()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			notifyOverdueTodos(since, now)
+			since = now
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func notifyOverdueTodos(since, until time.Time) {
+	s := sess.Copy()
+	defer s.Close()
+
+	iter := s.DB(cfg.DBName).C(cfg.CollectionName).Find(bson.M{
+		"dueDate": bson.M{"$gt": since, "$lte": until},
+		"completed": false,
+		"archived": bson.M{"$ne": true},
+	}).Sort("dueDate").Iter()
+
+	var tm TodoModel
+	for iter.Next(&tm) {
+		if err := slack.Notify(slackEvent("overdue", tm)); err != nil {
+			log.Warn().Err(err).Str("todoId", tm.ID.Hex()).Msg("failed to notify Slack")
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to fetch overdue todos")
+	}
+}
+
+func slackEvent(eventType string, tm TodoModel) notifications.TodoEvent {
+	return notifications.TodoEvent{
+		Type: eventType,
+		TodoID: tm.ID.Hex(),
+		Title: tm.Title,
+		Priority: tm.Priority,
+		DueDate: tm.DueDate,
+	}
+}
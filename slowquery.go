@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// timedOp runs the MongoDB operation fn, named as collection.operation such as
+// "Todo.find". Its duration is added to the request's span as an event, and
+// operations slower than MONGO_SLOW_QUERY_MS are logged as warnings with the
+// request id of ctx's logger.
+func timedOp(ctx context.Context, name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start)
+
+	collection, operation := name, ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		collection, operation = name[:i], name[i+1:]
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("mongo." + operation, trace.WithAttributes(
+		attribute.String("db.collection", collection),
+		attribute.String("db.operation", operation),
+		attribute.Int64("db.duration_ms", elapsed.Milliseconds()),
+	))
+
+	if elapsed > cfg.MongoSlowQuery {
+		zerolog.Ctx(ctx).Warn().
+			Str("collection", collection).
+			Str("operation", operation).
+			Int64("durationMs", elapsed.Milliseconds()).
+			Msg("slow MongoDB operation")
+	}
+
+	return err
+}
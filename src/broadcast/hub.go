@@ -0,0 +1,79 @@
+package broadcast
+
+import "sync"
+
+// subscriptionBuffer is how many events a subscriber can fall behind before
+// further events are dropped for it.
+const subscriptionBuffer = 16
+
+// Event describes a change to one of a user's todos.
+type Event struct {
+	Type		string `json:"type"`
+	TodoID		string `json:"todoId"`
+	Payload		interface{} `json:"payload,omitempty"`
+}
+
+// Subscription receives the events broadcast to one user.
+type Subscription struct {
+	Events		<-chan Event
+
+	userID		string
+	events		chan Event
+}
+
+// Hub fans events out to the subscriptions of the user they belong to.
+type Hub struct {
+	mu			sync.RWMutex
+	subs		map[string]map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[string]map[*Subscription]struct{}{}}
+}
+
+// Subscribe registers a new subscription to the user's events. It must be
+// released with Unsubscribe.
+func (h *Hub) Subscribe(userID string) *Subscription {
+	events := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{Events: events, userID: userID, events: events}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs[userID] == nil {
+		h.subs[userID] = map[*Subscription]struct{}{}
+	}
+	h.subs[userID][sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe removes the subscription and closes its channel.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub.userID][sub]; !ok {
+		return
+	}
+
+	delete(h.subs[sub.userID], sub)
+	if len(h.subs[sub.userID]) == 0 {
+		delete(h.subs, sub.userID)
+	}
+	close(sub.events)
+}
+
+// Broadcast sends the event to every subscription of the user. It never
+// blocks: subscribers that are too far behind miss the event.
+func (h *Hub) Broadcast(userID string, event Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs[userID] {
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+//go:build mongo_driver
+
+package bson
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The types the documents are built from are those of the official driver.
+type (
+	M			= primitive.M
+	ObjectId	= primitive.ObjectID
+	RegEx		= primitive.Regex
+)
+
+// NilObjectId is the zero ObjectId, which documents without an id have.
+var NilObjectId ObjectId
+
+// Registry decodes the values of untyped fields like mgo did: documents as M,
+// arrays as []interface{} and dates as time.Time.
+var Registry = newRegistry()
+
+func newRegistry() *bsoncodec.Registry {
+	r := bson.NewRegistry()
+	r.RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(M{}))
+	r.RegisterTypeMapEntry(bsontype.Array, reflect.TypeOf([]interface{}{}))
+	r.RegisterTypeMapEntry(bsontype.DateTime, reflect.TypeOf(time.Time{}))
+	return r
+}
+
+func NewObjectId() ObjectId {
+	return primitive.NewObjectID()
+}
+
+// ObjectIdHex returns the ObjectId of the hex string s, and panics if s is not
+// one.
+func ObjectIdHex(s string) ObjectId {
+	id, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		panic(fmt.Sprintf("invalid input to ObjectIdHex: %q", s))
+	}
+	return id
+}
+
+func IsObjectIdHex(s string) bool {
+	return primitive.IsValidObjectID(s)
+}
+
+func Marshal(in interface{}) ([]byte, error) {
+	return bson.Marshal(in)
+}
+
+func Unmarshal(in []byte, out interface{}) error {
+	dec, err := bson.NewDecoder(bsonrw.NewBSONDocumentReader(in))
+	if err != nil {
+		return err
+	}
+	if err := dec.SetRegistry(Registry); err != nil {
+		return err
+	}
+	dec.ZeroMaps()
+
+	return dec.Decode(out)
+}
@@ -0,0 +1,38 @@
+//go:build !mongo_driver
+
+package bson
+
+import "gopkg.in/mgo.v2/bson"
+
+// The types the documents are built from are mgo's, unless built with the
+// mongo_driver tag.
+type (
+	M			= bson.M
+	ObjectId	= bson.ObjectId
+	RegEx		= bson.RegEx
+)
+
+// NilObjectId is the zero ObjectId, which documents without an id have.
+var NilObjectId ObjectId
+
+func NewObjectId() ObjectId {
+	return bson.NewObjectId()
+}
+
+// ObjectIdHex returns the ObjectId of the hex string s, and panics if s is not
+// one.
+func ObjectIdHex(s string) ObjectId {
+	return bson.ObjectIdHex(s)
+}
+
+func IsObjectIdHex(s string) bool {
+	return bson.IsObjectIdHex(s)
+}
+
+func Marshal(in interface{}) ([]byte, error) {
+	return bson.Marshal(in)
+}
+
+func Unmarshal(in []byte, out interface{}) error {
+	return bson.Unmarshal(in, out)
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the application settings.
+type Config struct {
+	HostName				string `yaml:"hostName"`
+	MongoMaxRetries			int `yaml:"mongoMaxRetries"`
+	MongoPoolLimit			int `yaml:"mongoPoolLimit"`
+	MongoTimeout			time.Duration `yaml:"mongoTimeout"`
+	MongoSocketTimeout		time.Duration `yaml:"mongoSocketTimeout"`
+	MongoSlowQuery			time.Duration `yaml:"mongoSlowQuery"`
+	DBName					string `yaml:"dbName"`
+	CollectionName			string `yaml:"collectionName"`
+	Port					string `yaml:"port"`
+	GRPCPort				string `yaml:"grpcPort"`
+	JWTSecret				string `yaml:"jwtSecret"`
+	RateLimitRPS			float64 `yaml:"rateLimitRPS"`
+	RateLimitBurst			int `yaml:"rateLimitBurst"`
+	AutocompleteRateLimitRPS	float64 `yaml:"autocompleteRateLimitRPS"`
+	AutocompleteRateLimitBurst	int `yaml:"autocompleteRateLimitBurst"`
+	MaxBodyBytes			int64 `yaml:"maxBodyBytes"`
+	CORSAllowedOrigins		[]string `yaml:"corsAllowedOrigins"`
+	CORSAllowCredentials	bool `yaml:"corsAllowCredentials"`
+	LogLevel				string `yaml:"logLevel"`
+	OTLPEndpoint			string `yaml:"otlpEndpoint"`
+	ShutdownTimeout			time.Duration `yaml:"shutdownTimeout"`
+	TLSCertFile				string `yaml:"tlsCertFile"`
+	TLSKeyFile				string `yaml:"tlsKeyFile"`
+	MetricsEnabled			bool `yaml:"metricsEnabled"`
+	MetricsAllowedCIDR		[]string `yaml:"metricsAllowedCIDR"`
+	SlackWebhookURL			string `yaml:"slackWebhookURL"`
+	MultiTenant				bool `yaml:"multiTenant"`
+	AdminToken				string `yaml:"adminToken"`
+	RedisURL				string `yaml:"redisURL"`
+}
+
+// Defaults returns the settings used when nothing else is configured.
+func Defaults() Config {
+	return Config{
+		HostName: "localhost:27017",
+		MongoMaxRetries: 10,
+		MongoPoolLimit: 4096,
+		MongoTimeout: 10 * time.Second,
+		MongoSocketTimeout: time.Minute,
+		MongoSlowQuery: 200 * time.Millisecond,
+		DBName: "demo_todo",
+		CollectionName: "Todo",
+		Port: ":9000",
+		GRPCPort: ":9001",
+		JWTSecret: "change-me",
+		RateLimitRPS: 10,
+		RateLimitBurst: 20,
+		AutocompleteRateLimitRPS: 20,
+		AutocompleteRateLimitBurst: 40,
+		MaxBodyBytes: 1 << 20,
+		LogLevel: "info",
+		MetricsEnabled: true,
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// Load reads the configuration from environment variables, falling back to
+// the defaults for any that are unset or invalid.
+func Load() Config {
+	return applyEnv(Defaults())
+}
+
+// LoadFromFile reads the configuration from a YAML file. Settings missing from
+// the file keep their defaults, and environment variables override the file.
+func LoadFromFile(path string) (Config, error) {
+	c := Defaults()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+
+	return applyEnv(c), nil
+}
+
+// applyEnv overrides c with the environment variables that are set.
+func applyEnv(c Config) Config {
+	setString(&c.HostName, "MONGO_HOST")
+	setString(&c.DBName, "MONGO_DB")
+	setString(&c.CollectionName, "MONGO_COLLECTION")
+	setString(&c.Port, "PORT")
+	setString(&c.GRPCPort, "GRPC_PORT")
+	setString(&c.JWTSecret, "JWT_SECRET")
+	setString(&c.LogLevel, "LOG_LEVEL")
+	setString(&c.OTLPEndpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	setString(&c.TLSCertFile, "TLS_CERT_FILE")
+	setString(&c.TLSKeyFile, "TLS_KEY_FILE")
+	setString(&c.SlackWebhookURL, "SLACK_WEBHOOK_URL")
+	setString(&c.AdminToken, "ADMIN_TOKEN")
+	setString(&c.RedisURL, "REDIS_URL")
+
+	if v, err := strconv.Atoi(os.Getenv("MONGO_MAX_RETRIES")); err == nil && v >= 0 {
+		c.MongoMaxRetries = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MONGO_POOL_LIMIT")); err == nil && v > 0 {
+		c.MongoPoolLimit = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MONGO_TIMEOUT_MS")); err == nil && v > 0 {
+		c.MongoTimeout = time.Duration(v) * time.Millisecond
+	}
+	if v, err := strconv.Atoi(os.Getenv("MONGO_SOCKET_TIMEOUT_MS")); err == nil && v > 0 {
+		c.MongoSocketTimeout = time.Duration(v) * time.Millisecond
+	}
+	if v, err := strconv.Atoi(os.Getenv("MONGO_SLOW_QUERY_MS")); err == nil && v > 0 {
+		c.MongoSlowQuery = time.Duration(v) * time.Millisecond
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("RATE_LIMIT_RPS"), 64); err == nil && v > 0 {
+		c.RateLimitRPS = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST")); err == nil && v > 0 {
+		c.RateLimitBurst = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("AUTOCOMPLETE_RATE_LIMIT_RPS"), 64); err == nil && v > 0 {
+		c.AutocompleteRateLimitRPS = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("AUTOCOMPLETE_RATE_LIMIT_BURST")); err == nil && v > 0 {
+		c.AutocompleteRateLimitBurst = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("MAX_BODY_BYTES"), 10, 64); err == nil && v > 0 {
+		c.MaxBodyBytes = v
+	}
+	if v, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		c.CORSAllowedOrigins = splitList(v)
+	}
+	if v, err := strconv.ParseBool(os.Getenv("CORS_ALLOW_CREDENTIALS")); err == nil {
+		c.CORSAllowCredentials = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("METRICS_ENABLED")); err == nil {
+		c.MetricsEnabled = v
+	}
+	if v, ok := os.LookupEnv("METRICS_ALLOWED_CIDR"); ok {
+		c.MetricsAllowedCIDR = splitList(v)
+	}
+	if v, err := strconv.ParseBool(os.Getenv("MULTI_TENANT")); err == nil {
+		c.MultiTenant = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && v > 0 {
+		c.ShutdownTimeout = v
+	}
+
+	return c
+}
+
+func setString(field *string, key string) {
+	if v := os.Getenv(key); v != "" {
+		*field = v
+	}
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(v string) []string {
+	var list []string
+
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,84 @@
+package flags
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+type contextKey string
+
+const checkerKey contextKey = "flagChecker"
+
+// Flag turns a feature on for everyone, for some users, or for a percentage
+// of users.
+type Flag struct {
+	Name			string
+	EnabledForAll	bool
+	UserIDs			[]string
+	Percentage		int
+}
+
+// FlagChecker tells which flags are on for one user.
+type FlagChecker struct {
+	userID		string
+	flags		map[string]Flag
+}
+
+func NewChecker(userID string, flags []Flag) *FlagChecker {
+	c := &FlagChecker{userID: userID, flags: map[string]Flag{}}
+	for _, f := range flags {
+		c.flags[f.Name] = f
+	}
+
+	return c
+}
+
+// Enabled reports whether the flag is on for the user. Unknown flags are
+// off.
+func (c *FlagChecker) Enabled(name string) bool {
+	f, ok := c.flags[name]
+	if !ok {
+		return false
+	}
+	if f.EnabledForAll {
+		return true
+	}
+
+	for _, id := range f.UserIDs {
+		if id == c.userID {
+			return true
+		}
+	}
+
+	return InRollout(name, c.userID, f.Percentage)
+}
+
+// InRollout reports whether the user falls within the first percentage of
+// users for the flag. Each user keeps their place as the percentage grows,
+// and each flag orders users differently.
+func InRollout(name, userID string, percentage int) bool {
+	if percentage <= 0 || userID == "" {
+		return false
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + userID))
+
+	return int(h.Sum32() % 100) < percentage
+}
+
+// WithChecker returns a copy of ctx carrying the checker.
+func WithChecker(ctx context.Context, c *FlagChecker) context.Context {
+	return context.WithValue(ctx, checkerKey, c)
+}
+
+// IsEnabled reports whether the flag is on for the user of ctx. Without a
+// checker in ctx, every flag is off.
+func IsEnabled(ctx context.Context, name string) bool {
+	c, ok := ctx.Value(checkerKey).(*FlagChecker)
+	if !ok {
+		return false
+	}
+
+	return c.Enabled(name)
+}
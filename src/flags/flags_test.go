@@ -0,0 +1,143 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// userIDs returns n distinct user ids shaped like the hex ObjectIds the
+// server passes in.
+func userIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%024x", i + 1)
+	}
+
+	return ids
+}
+
+func TestInRolloutHitRate(t *testing.T) {
+	const users = 20000
+	ids := userIDs(users)
+
+	for _, percentage := range []int{1, 10, 25, 50, 75, 90} {
+		t.Run(fmt.Sprintf("%d%%", percentage), func(t *testing.T) {
+			hits := 0
+			for _, id := range ids {
+				if InRollout("new_scoring", id, percentage) {
+					hits++
+				}
+			}
+
+			rate := float64(hits) / users * 100
+			if math.Abs(rate - float64(percentage)) > 1.5 {
+				t.Errorf("%.2f%% of users in a %d%% rollout, want within 1.5 points", rate, percentage)
+			}
+		})
+	}
+}
+
+func TestInRolloutBounds(t *testing.T) {
+	for _, id := range userIDs(1000) {
+		if InRollout("new_scoring", id, 0) {
+			t.Fatalf("user %s is in a 0%% rollout", id)
+		}
+		if !InRollout("new_scoring", id, 100) {
+			t.Fatalf("user %s is not in a 100%% rollout", id)
+		}
+	}
+
+	if InRollout("new_scoring", "", 100) {
+		t.Error("an anonymous user is in a 100% rollout")
+	}
+}
+
+func TestInRolloutIsStable(t *testing.T) {
+	for _, id := range userIDs(1000) {
+		first := InRollout("new_scoring", id, 30)
+		for i := 0; i < 3; i++ {
+			if InRollout("new_scoring", id, 30) != first {
+				t.Fatalf("user %s moved in or out of the rollout between calls", id)
+			}
+		}
+	}
+}
+
+func TestInRolloutKeepsUsersAsPercentageGrows(t *testing.T) {
+	for _, id := range userIDs(5000) {
+		for percentage := 1; percentage < 100; percentage++ {
+			if InRollout("new_scoring", id, percentage) && !InRollout("new_scoring", id, percentage + 1) {
+				t.Fatalf("user %s left the rollout going from %d%% to %d%%", id, percentage, percentage + 1)
+			}
+		}
+	}
+}
+
+func TestInRolloutDiffersBetweenFlags(t *testing.T) {
+	same := 0
+	ids := userIDs(2000)
+	for _, id := range ids {
+		if InRollout("new_scoring", id, 50) == InRollout("dark_mode", id, 50) {
+			same++
+		}
+	}
+
+	// Independent 50% rollouts agree on about half the users.
+	if same == len(ids) || same == 0 {
+		t.Errorf("the two flags agree on %d of %d users, want them to pick users independently", same, len(ids))
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	const (
+		listed		= "0000000000000000000000aa"
+		other		= "0000000000000000000000bb"
+	)
+
+	list := []Flag{
+		{Name: "everyone", EnabledForAll: true},
+		{Name: "allowlist", UserIDs: []string{listed}},
+		{Name: "off"},
+		{Name: "rollout", Percentage: 100},
+	}
+
+	tests := []struct {
+		name		string
+		userID		string
+		flag		string
+		want		bool
+	}{
+		{"enabled for all", other, "everyone", true},
+		{"listed user", listed, "allowlist", true},
+		{"user not listed", other, "allowlist", false},
+		{"flag off", listed, "off", false},
+		{"full rollout", other, "rollout", true},
+		{"unknown flag", listed, "missing", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewChecker(tt.userID, list).Enabled(tt.flag); got != tt.want {
+				t.Errorf("Enabled(%q) for %s = %v, want %v", tt.flag, tt.userID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEnabled(t *testing.T) {
+	list := []Flag{{Name: "everyone", EnabledForAll: true}}
+
+	if IsEnabled(context.Background(), "everyone") {
+		t.Error("a flag is on without a checker in the context")
+	}
+
+	ctx := WithChecker(context.Background(), NewChecker("0000000000000000000000aa", list))
+	if !IsEnabled(ctx, "everyone") {
+		t.Error("a flag enabled for all is off")
+	}
+	if IsEnabled(ctx, "missing") {
+		t.Error("an unknown flag is on")
+	}
+}
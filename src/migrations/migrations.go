@@ -0,0 +1,212 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+)
+
+// CollectionName holds the versions of the migrations applied.
+const CollectionName string = "SchemaMigration"
+
+type(
+	// Migration changes the schema from the previous version to Version, and
+	// back with Down.
+	Migration interface {
+		Version() int
+		Up(db *store.Database) error
+		Down(db *store.Database) error
+	}
+
+	// Func is a Migration made of two functions.
+	Func struct {
+		V			int
+		UpFunc		func(db *store.Database) error
+		DownFunc	func(db *store.Database) error
+	}
+
+	applied struct {
+		Version		int `bson:"_id"`
+		AppliedAt	time.Time `bson:"appliedAt"`
+	}
+
+	// versionStore records the versions of the migrations applied.
+	versionStore interface {
+		versions() ([]int, error)
+		add(version int) error
+		remove(version int) error
+	}
+
+	// collectionStore is the versionStore kept in CollectionName.
+	collectionStore struct {
+		c			*store.Collection
+	}
+
+	// Runner applies and rolls back the registered migrations, recording
+	// which are applied in CollectionName.
+	Runner struct {
+		db			*store.Database
+		store		versionStore
+		migrations	[]Migration
+	}
+)
+
+func (f Func) Version() int { return f.V }
+
+func (f Func) Up(db *store.Database) error { return f.UpFunc(db) }
+
+func (f Func) Down(db *store.Database) error { return f.DownFunc(db) }
+
+func (s collectionStore) versions() ([]int, error) {
+	var records []applied
+	if err := s.c.Find(nil).All(&records); err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, len(records))
+	for i, a := range records {
+		versions[i] = a.Version
+	}
+
+	return versions, nil
+}
+
+func (s collectionStore) add(version int) error {
+	return s.c.Insert(applied{Version: version, AppliedAt: time.Now()})
+}
+
+func (s collectionStore) remove(version int) error {
+	if err := s.c.RemoveId(version); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// NewRunner returns a Runner for the given migrations, whose versions must
+// run from 1 up without gaps or duplicates.
+func NewRunner(db *store.Database, migrations ...Migration) (*Runner, error) {
+	return newRunner(db, collectionStore{c: db.C(CollectionName)}, migrations...)
+}
+
+func newRunner(db *store.Database, s versionStore, migrations ...Migration) (*Runner, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version() < sorted[j].Version() })
+
+	for i, m := range sorted {
+		if m.Version() < 1 {
+			return nil, fmt.Errorf("migration version %d is not positive", m.Version())
+		}
+		if i > 0 && sorted[i - 1].Version() == m.Version() {
+			return nil, fmt.Errorf("migration version %d is registered twice", m.Version())
+		}
+		if m.Version() != i + 1 {
+			return nil, fmt.Errorf("migration version %d is missing", i + 1)
+		}
+	}
+
+	return &Runner{db: db, store: s, migrations: sorted}, nil
+}
+
+// Applied returns the versions of the migrations applied.
+func (r *Runner) Applied() (map[int]bool, error) {
+	records, err := r.store.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[int]bool{}
+	for _, v := range records {
+		versions[v] = true
+	}
+
+	return versions, nil
+}
+
+// Up applies the pending migrations in version order.
+func (r *Runner) Up() error {
+	return r.To(r.latest())
+}
+
+// Down rolls back the most recent migration applied, if any.
+func (r *Runner) Down() error {
+	versions, err := r.Applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		if versions[r.migrations[i].Version()] {
+			return r.down(r.migrations[i])
+		}
+	}
+
+	return nil
+}
+
+// To applies the pending migrations up to version, and rolls back those
+// applied after it, the latest first. To(0) rolls back every migration.
+func (r *Runner) To(version int) error {
+	if version < 0 || version > r.latest() {
+		return fmt.Errorf("there is no migration version %d", version)
+	}
+
+	versions, err := r.Applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		if m := r.migrations[i]; m.Version() > version && versions[m.Version()] {
+			if err := r.down(m); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range r.migrations {
+		if m.Version() <= version && !versions[m.Version()] {
+			if err := r.up(m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) latest() int {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations) - 1].Version()
+}
+
+func (r *Runner) up(m Migration) error {
+	if err := m.Up(r.db); err != nil {
+		return fmt.Errorf("migration %d: %w", m.Version(), err)
+	}
+
+	if err := r.store.add(m.Version()); err != nil {
+		return fmt.Errorf("migration %d: %w", m.Version(), err)
+	}
+
+	log.Info().Int("version", m.Version()).Msg("applied migration")
+	return nil
+}
+
+func (r *Runner) down(m Migration) error {
+	if err := m.Down(r.db); err != nil {
+		return fmt.Errorf("migration %d: %w", m.Version(), err)
+	}
+
+	if err := r.store.remove(m.Version()); err != nil {
+		return fmt.Errorf("migration %d: %w", m.Version(), err)
+	}
+
+	log.Info().Int("version", m.Version()).Msg("rolled back migration")
+	return nil
+}
@@ -0,0 +1,242 @@
+package migrations
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+)
+
+// memoryStore is a versionStore kept in memory, standing in for CollectionName.
+type memoryStore struct {
+	applied		map[int]bool
+}
+
+func (s *memoryStore) versions() ([]int, error) {
+	versions := []int{}
+	for v := range s.applied {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	return versions, nil
+}
+
+func (s *memoryStore) add(version int) error {
+	s.applied[version] = true
+	return nil
+}
+
+func (s *memoryStore) remove(version int) error {
+	delete(s.applied, version)
+	return nil
+}
+
+// newTestRunner returns a runner for migrations 1 to n, which log each step
+// they run to steps, and the store it records to.
+func newTestRunner(t *testing.T, n int, steps *[]string) (*Runner, *memoryStore) {
+	t.Helper()
+
+	var list []Migration
+	for v := 1; v <= n; v++ {
+		name := strconv.Itoa(v)
+		list = append(list, Func{
+			V: v,
+			UpFunc: func(db *store.Database) error {
+				*steps = append(*steps, "up " + name)
+				return nil
+			},
+			DownFunc: func(db *store.Database) error {
+				*steps = append(*steps, "down " + name)
+				return nil
+			},
+		})
+	}
+
+	s := &memoryStore{applied: map[int]bool{}}
+	r, err := newRunner(nil, s, list...)
+	if err != nil {
+		t.Fatalf("newRunner: %v", err)
+	}
+
+	return r, s
+}
+
+func appliedVersions(t *testing.T, s *memoryStore) []int {
+	t.Helper()
+
+	versions, err := s.versions()
+	if err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+
+	return versions
+}
+
+func TestUpAppliesPendingMigrationsInOrder(t *testing.T) {
+	var steps []string
+	r, s := newTestRunner(t, 3, &steps)
+	s.applied[1] = true
+
+	if err := r.Up(); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	if want := []string{"up 2", "up 3"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+	if got, want := appliedVersions(t, s), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applied = %v, want %v", got, want)
+	}
+
+	steps = nil
+	if err := r.Up(); err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	if len(steps) != 0 {
+		t.Errorf("second Up ran %v, want nothing", steps)
+	}
+}
+
+func TestUpStopsAtFailingMigration(t *testing.T) {
+	s := &memoryStore{applied: map[int]bool{}}
+	failure := errors.New("boom")
+	noop := func(db *store.Database) error { return nil }
+
+	r, err := newRunner(nil, s,
+		Func{V: 1, UpFunc: noop, DownFunc: noop},
+		Func{V: 2, UpFunc: func(db *store.Database) error { return failure }, DownFunc: noop},
+		Func{V: 3, UpFunc: noop, DownFunc: noop},
+	)
+	if err != nil {
+		t.Fatalf("newRunner: %v", err)
+	}
+
+	if err := r.Up(); !errors.Is(err, failure) {
+		t.Fatalf("Up error = %v, want %v", err, failure)
+	}
+	if got, want := appliedVersions(t, s), []int{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applied = %v, want %v", got, want)
+	}
+}
+
+func TestDownRollsBackLatestMigration(t *testing.T) {
+	var steps []string
+	r, s := newTestRunner(t, 3, &steps)
+	s.applied[1], s.applied[2] = true, true
+
+	if err := r.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	if want := []string{"down 2"}; !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+	if got, want := appliedVersions(t, s), []int{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applied = %v, want %v", got, want)
+	}
+}
+
+func TestDownWithNothingAppliedDoesNothing(t *testing.T) {
+	var steps []string
+	r, _ := newTestRunner(t, 2, &steps)
+
+	if err := r.Down(); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(steps) != 0 {
+		t.Errorf("Down ran %v, want nothing", steps)
+	}
+}
+
+func TestToMigratesUpAndDown(t *testing.T) {
+	tests := []struct {
+		name		string
+		applied		[]int
+		version		int
+		steps		[]string
+		want		[]int
+	}{
+		{"up from nothing", nil, 2, []string{"up 1", "up 2"}, []int{1, 2}},
+		{"up from partway", []int{1}, 3, []string{"up 2", "up 3"}, []int{1, 2, 3}},
+		{"down latest first", []int{1, 2, 3}, 1, []string{"down 3", "down 2"}, []int{1}},
+		{"down to zero", []int{1, 2}, 0, []string{"down 2", "down 1"}, []int{}},
+		{"already there", []int{1, 2}, 2, nil, []int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var steps []string
+			r, s := newTestRunner(t, 3, &steps)
+			for _, v := range tt.applied {
+				s.applied[v] = true
+			}
+
+			if err := r.To(tt.version); err != nil {
+				t.Fatalf("To(%d): %v", tt.version, err)
+			}
+
+			if !reflect.DeepEqual(steps, tt.steps) {
+				t.Errorf("steps = %v, want %v", steps, tt.steps)
+			}
+			if got := appliedVersions(t, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applied = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToRejectsUnknownVersion(t *testing.T) {
+	var steps []string
+	r, _ := newTestRunner(t, 2, &steps)
+
+	for _, version := range []int{-1, 3} {
+		if err := r.To(version); err == nil {
+			t.Errorf("To(%d) succeeded, want an error", version)
+		}
+	}
+	if len(steps) != 0 {
+		t.Errorf("ran %v, want nothing", steps)
+	}
+}
+
+func TestNewRunnerChecksVersions(t *testing.T) {
+	noop := func(db *store.Database) error { return nil }
+	migration := func(v int) Migration {
+		return Func{V: v, UpFunc: noop, DownFunc: noop}
+	}
+
+	tests := []struct {
+		name		string
+		versions	[]int
+		valid		bool
+	}{
+		{"consecutive", []int{1, 2, 3}, true},
+		{"out of order", []int{3, 1, 2}, true},
+		{"none", nil, true},
+		{"duplicate", []int{1, 2, 2}, false},
+		{"gap", []int{1, 3}, false},
+		{"not starting at one", []int{2, 3}, false},
+		{"zero", []int{0, 1}, false},
+		{"negative", []int{-1, 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list []Migration
+			for _, v := range tt.versions {
+				list = append(list, migration(v))
+			}
+
+			_, err := newRunner(nil, &memoryStore{applied: map[int]bool{}}, list...)
+			if tt.valid && err != nil {
+				t.Errorf("newRunner(%v): %v", tt.versions, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("newRunner(%v) succeeded, want an error", tt.versions)
+			}
+		})
+	}
+}
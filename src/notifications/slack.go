@@ -0,0 +1,108 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const slackTimeout = 5 * time.Second
+
+// TodoEvent describes a todo worth telling the team about.
+type TodoEvent struct {
+	Type		string
+	TodoID		string
+	Title		string
+	Priority	string
+	DueDate		*time.Time
+}
+
+// SlackNotifier posts todo events to a Slack incoming webhook. A notifier
+// without a webhook URL does nothing, so callers need not check whether
+// Slack is configured.
+type SlackNotifier struct {
+	webhookURL	string
+	client		*http.Client
+}
+
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{
+		webhookURL: webhookURL,
+		client: &http.Client{Timeout: slackTimeout},
+	}
+}
+
+// Enabled reports whether a webhook URL is configured.
+func (n *SlackNotifier) Enabled() bool {
+	return n.webhookURL != ""
+}
+
+// Notify posts the event to Slack as a Block Kit message.
+func (n *SlackNotifier) Notify(event TodoEvent) error {
+	if !n.Enabled() {
+		return nil
+	}
+
+	body, err := json.Marshal(slackMessage(event))
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("slack responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type(
+	slackText struct {
+		Type		string `json:"type"`
+		Text		string `json:"text"`
+	}
+
+	slackBlock struct {
+		Type		string `json:"type"`
+		Text		*slackText `json:"text,omitempty"`
+		Fields		[]slackText `json:"fields,omitempty"`
+	}
+)
+
+// slackMessage builds the Block Kit payload for event. The top level text is
+// what Slack shows in notifications.
+func slackMessage(event TodoEvent) map[string]interface{} {
+	var heading string
+	switch event.Type {
+	case "overdue":
+		heading = "Todo overdue"
+	default:
+		heading = "New " + event.Priority + " priority todo"
+	}
+
+	fields := []slackText{
+		{Type: "mrkdwn", Text: "*Priority*\n" + event.Priority},
+	}
+	if event.DueDate != nil {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: "*Due*\n" + event.DueDate.UTC().Format("2006-01-02 15:04 MST")})
+	}
+
+	return map[string]interface{}{
+		"text": heading + ": " + event.Title,
+		"blocks": []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: heading}},
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "*" + mrkdwnEscaper.Replace(event.Title) + "*"}, Fields: fields},
+		},
+	}
+}
+
+// mrkdwnEscaper escapes the characters Slack treats as control characters.
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
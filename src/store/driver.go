@@ -0,0 +1,514 @@
+//go:build mongo_driver
+
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+// disconnectTimeout bounds closing the connections of a session.
+const disconnectTimeout time.Duration = 10 * time.Second
+
+// The store is built on the official driver, keeping the API mgo gave it.
+type(
+	// Session is a client of the driver. Its copies share the client's
+	// connection pool, and closing the session dialled disconnects it.
+	Session struct {
+		client			*mongo.Client
+		servers			*liveServers
+		timeout			time.Duration
+		copied			bool
+	}
+
+	// Database runs its operations with its context, bounded by the timeout
+	// of the session it comes from.
+	Database struct {
+		Name			string
+		db				*mongo.Database
+		ctx				context.Context
+		timeout			time.Duration
+	}
+
+	Collection struct {
+		Name			string
+		c				*mongo.Collection
+		db				*Database
+	}
+
+	// Change is the change Query.Apply makes to the first document matched.
+	Change struct {
+		Update			interface{}
+		Upsert			bool
+		Remove			bool
+		ReturnNew		bool
+	}
+
+	// ChangeInfo counts the documents changed by an operation.
+	ChangeInfo struct {
+		Updated			int
+		Removed			int
+		Matched			int
+		UpsertedId		interface{}
+	}
+
+	BuildInfo struct {
+		Version			string
+		VersionArray	[]int `bson:"versionArray"`
+		GitVersion		string `bson:"gitVersion"`
+		SysInfo			string `bson:"sysInfo"`
+		Bits			int
+		Debug			bool
+		MaxObjectSize	int `bson:"maxBsonObjectSize"`
+	}
+
+	// Stats are the use of the connection pools and the operations sent to
+	// MongoDB, while collecting them is on. The driver does not tell master
+	// from slave connections, nor count references to them, so MasterConns,
+	// SlaveConns and SocketRefs stay zero.
+	Stats struct {
+		MasterConns		int
+		SlaveConns		int
+		SentOps			int
+		ReceivedOps		int
+		SocketsAlive	int
+		SocketsInUse	int
+		SocketRefs		int
+	}
+
+	// QueryError is an error reported by the server for a command.
+	QueryError struct {
+		Code			int
+		Message			string
+	}
+
+	// liveServers are the addresses of the servers a client reaches.
+	liveServers struct {
+		mu				sync.Mutex
+		addrs			map[string]bool
+	}
+)
+
+var ErrNotFound = errors.New("not found")
+
+var (
+	statsEnabled	atomic.Bool
+	sentOps			atomic.Int64
+	receivedOps		atomic.Int64
+	socketsAlive	atomic.Int64
+	socketsInUse	atomic.Int64
+)
+
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+func IsDup(err error) bool {
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		return qe.Code == 11000 || qe.Code == 11001 || qe.Code == 12582
+	}
+	return mongo.IsDuplicateKeyError(err)
+}
+
+// SetStats turns collecting the statistics of GetStats on or off.
+func SetStats(enabled bool) {
+	statsEnabled.Store(enabled)
+}
+
+func GetStats() Stats {
+	if !statsEnabled.Load() {
+		return Stats{}
+	}
+
+	return Stats{
+		SentOps: int(sentOps.Load()),
+		ReceivedOps: int(receivedOps.Load()),
+		SocketsAlive: int(socketsAlive.Load()),
+		SocketsInUse: int(socketsInUse.Load()),
+	}
+}
+
+// wrapErr returns err as mgo reported it: ErrNotFound when no document
+// matched, and a *QueryError for the errors of commands.
+func wrapErr(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return &QueryError{Code: int(ce.Code), Message: ce.Message}
+	}
+
+	return err
+}
+
+func dial(info DialInfo) (*Session, error) {
+	ctx := context.Background()
+	if info.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, info.Timeout)
+		defer cancel()
+	}
+
+	uri := info.HostName
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		uri = "mongodb://" + uri
+	}
+
+	servers := &liveServers{addrs: map[string]bool{}}
+
+	opts := options.Client().ApplyURI(uri).
+		SetRegistry(bson.Registry).
+		// Like mgo, times are local and maps are emptied before decoding.
+		SetBSONOptions(&options.BSONOptions{UseLocalTimeZone: true, ZeroMaps: true}).
+		SetMonitor(&event.CommandMonitor{
+			Started: func(context.Context, *event.CommandStartedEvent) { sentOps.Add(1) },
+			Succeeded: func(context.Context, *event.CommandSucceededEvent) { receivedOps.Add(1) },
+			Failed: func(context.Context, *event.CommandFailedEvent) { receivedOps.Add(1) },
+		}).
+		SetPoolMonitor(&event.PoolMonitor{Event: countConnection}).
+		SetServerMonitor(&event.ServerMonitor{
+			ServerDescriptionChanged: func(e *event.ServerDescriptionChangedEvent) {
+				servers.set(e.Address.String(), e.NewDescription.Kind != description.Unknown)
+			},
+		})
+	if info.Timeout > 0 {
+		opts.SetConnectTimeout(info.Timeout).SetServerSelectionTimeout(info.Timeout)
+	}
+	if info.SocketTimeout > 0 {
+		opts.SetSocketTimeout(info.SocketTimeout)
+	}
+	if info.PoolLimit > 0 {
+		opts.SetMaxPoolSize(uint64(info.PoolLimit))
+	}
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return &Session{client: client, servers: servers}, nil
+}
+
+func countConnection(e *event.PoolEvent) {
+	switch e.Type {
+	case event.ConnectionCreated:
+		socketsAlive.Add(1)
+	case event.ConnectionClosed:
+		socketsAlive.Add(-1)
+	case event.GetSucceeded:
+		socketsInUse.Add(1)
+	case event.ConnectionReturned:
+		socketsInUse.Add(-1)
+	}
+}
+
+func (l *liveServers) set(addr string, live bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if live {
+		l.addrs[addr] = true
+	} else {
+		delete(l.addrs, addr)
+	}
+}
+
+// Copy returns a session sharing the connections of s, which closing does not
+// disconnect.
+func (s *Session) Copy() *Session {
+	c := *s
+	c.copied = true
+	return &c
+}
+
+// Close disconnects the session dialled. Closing its copies does nothing.
+func (s *Session) Close() {
+	if s.copied {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), disconnectTimeout)
+	defer cancel()
+	s.client.Disconnect(ctx)
+}
+
+// SetSyncTimeout bounds each operation of the session's databases.
+func (s *Session) SetSyncTimeout(d time.Duration) {
+	s.timeout = d
+}
+
+// SetSocketTimeout bounds each operation of the session's databases, like
+// SetSyncTimeout: the driver does not tell waiting for a server from waiting
+// for its reply.
+func (s *Session) SetSocketTimeout(d time.Duration) {
+	s.timeout = d
+}
+
+func (s *Session) DB(name string) *Database {
+	return &Database{Name: name, db: s.client.Database(name), ctx: context.Background(), timeout: s.timeout}
+}
+
+func (s *Session) Ping() error {
+	ctx, cancel := s.DB("admin").context()
+	defer cancel()
+
+	return s.client.Ping(ctx, readpref.Primary())
+}
+
+func (s *Session) BuildInfo() (BuildInfo, error) {
+	var info BuildInfo
+
+	d := s.DB("admin")
+	ctx, cancel := d.context()
+	defer cancel()
+
+	err := d.db.RunCommand(ctx, primitive.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+	return info, wrapErr(err)
+}
+
+// LiveServers returns the addresses of the servers the session reaches.
+func (s *Session) LiveServers() []string {
+	s.servers.mu.Lock()
+	defer s.servers.mu.Unlock()
+
+	addrs := make([]string, 0, len(s.servers.addrs))
+	for addr := range s.servers.addrs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	return addrs
+}
+
+// WithContext returns a copy of the database running its operations with ctx.
+func (d *Database) WithContext(ctx context.Context) *Database {
+	c := *d
+	c.ctx = ctx
+	return &c
+}
+
+// Mongo returns the driver's database, for the features the store does not
+// cover, like transactions and change streams.
+func (d *Database) Mongo() *mongo.Database {
+	return d.db
+}
+
+func (d *Database) C(name string) *Collection {
+	return &Collection{Name: name, c: d.db.Collection(name), db: d}
+}
+
+// context returns the context of an operation.
+func (d *Database) context() (context.Context, context.CancelFunc) {
+	if d.timeout > 0 {
+		return context.WithTimeout(d.ctx, d.timeout)
+	}
+	return context.WithCancel(d.ctx)
+}
+
+// filterOf returns the filter matching the documents selected, all of them for
+// a nil selector.
+func filterOf(s interface{}) interface{} {
+	if s == nil {
+		return bson.M{}
+	}
+	return s
+}
+
+// isUpdate reports whether update holds update operators, rather than being
+// the replacement of the documents matched.
+func isUpdate(update interface{}) bool {
+	switch u := update.(type) {
+	case bson.M:
+		for k := range u {
+			return strings.HasPrefix(k, "$")
+		}
+	case map[string]interface{}:
+		for k := range u {
+			return strings.HasPrefix(k, "$")
+		}
+	case primitive.D:
+		return len(u) > 0 && strings.HasPrefix(u[0].Key, "$")
+	}
+	return false
+}
+
+func (c *Collection) Find(query interface{}) *Query {
+	return &Query{c: c, filter: filterOf(query)}
+}
+
+func (c *Collection) FindId(id interface{}) *Query {
+	return c.Find(bson.M{"_id": id})
+}
+
+func (c *Collection) Count() (int, error) {
+	return c.Find(nil).Count()
+}
+
+func (c *Collection) Insert(docs ...interface{}) error {
+	ctx, cancel := c.db.context()
+	defer cancel()
+
+	var err error
+	if len(docs) == 1 {
+		_, err = c.c.InsertOne(ctx, docs[0])
+	} else {
+		_, err = c.c.InsertMany(ctx, docs)
+	}
+	return wrapErr(err)
+}
+
+func (c *Collection) updateOne(selector interface{}, update interface{}, upsert bool) (*ChangeInfo, error) {
+	ctx, cancel := c.db.context()
+	defer cancel()
+
+	var res *mongo.UpdateResult
+	var err error
+	if isUpdate(update) {
+		res, err = c.c.UpdateOne(ctx, filterOf(selector), update, options.Update().SetUpsert(upsert))
+	} else {
+		res, err = c.c.ReplaceOne(ctx, filterOf(selector), update, options.Replace().SetUpsert(upsert))
+	}
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	return &ChangeInfo{Updated: int(res.ModifiedCount), Matched: int(res.MatchedCount), UpsertedId: res.UpsertedID}, nil
+}
+
+// Update changes the first document matching selector, and returns
+// ErrNotFound if there is none.
+func (c *Collection) Update(selector interface{}, update interface{}) error {
+	info, err := c.updateOne(selector, update, false)
+	if err == nil && info.Matched == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+func (c *Collection) UpdateId(id interface{}, update interface{}) error {
+	return c.Update(bson.M{"_id": id}, update)
+}
+
+func (c *Collection) UpdateAll(selector interface{}, update interface{}) (*ChangeInfo, error) {
+	ctx, cancel := c.db.context()
+	defer cancel()
+
+	res, err := c.c.UpdateMany(ctx, filterOf(selector), update)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	return &ChangeInfo{Updated: int(res.ModifiedCount), Matched: int(res.MatchedCount)}, nil
+}
+
+// Upsert changes the first document matching selector, or inserts one if
+// there is none.
+func (c *Collection) Upsert(selector interface{}, update interface{}) (*ChangeInfo, error) {
+	return c.updateOne(selector, update, true)
+}
+
+func (c *Collection) UpsertId(id interface{}, update interface{}) (*ChangeInfo, error) {
+	return c.Upsert(bson.M{"_id": id}, update)
+}
+
+// Remove deletes the first document matching selector, and returns
+// ErrNotFound if there is none.
+func (c *Collection) Remove(selector interface{}) error {
+	ctx, cancel := c.db.context()
+	defer cancel()
+
+	res, err := c.c.DeleteOne(ctx, filterOf(selector))
+	if err != nil {
+		return wrapErr(err)
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (c *Collection) RemoveId(id interface{}) error {
+	return c.Remove(bson.M{"_id": id})
+}
+
+func (c *Collection) RemoveAll(selector interface{}) (*ChangeInfo, error) {
+	ctx, cancel := c.db.context()
+	defer cancel()
+
+	res, err := c.c.DeleteMany(ctx, filterOf(selector))
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	return &ChangeInfo{Removed: int(res.DeletedCount), Matched: int(res.DeletedCount)}, nil
+}
+
+func (c *Collection) Pipe(pipeline interface{}) *Pipe {
+	return &Pipe{c: c, pipeline: pipeline}
+}
+
+func (c *Collection) Bulk() *Bulk {
+	return &Bulk{c: c}
+}
+
+// EnsureIndex creates the index unless it exists.
+func (c *Collection) EnsureIndex(index Index) error {
+	spec, err := indexSpec(index, func(fields []indexField) interface{} {
+		doc := primitive.D{}
+		for _, f := range fields {
+			doc = append(doc, primitive.E{Key: f.name, Value: f.value})
+		}
+		return doc
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.db.context()
+	defer cancel()
+
+	return wrapErr(c.db.db.RunCommand(ctx, primitive.D{
+		{Key: "createIndexes", Value: c.Name},
+		{Key: "indexes", Value: []bson.M{spec}},
+	}).Err())
+}
+
+// DropIndex drops the index with the key, named the way EnsureIndex names it.
+func (c *Collection) DropIndex(key ...string) error {
+	_, _, name, err := parseIndexKey(key)
+	if err != nil {
+		return err
+	}
+
+	return c.DropIndexName(name)
+}
+
+func (c *Collection) DropIndexName(name string) error {
+	ctx, cancel := c.db.context()
+	defer cancel()
+
+	return wrapErr(c.db.db.RunCommand(ctx, primitive.D{
+		{Key: "dropIndexes", Value: c.Name},
+		{Key: "index", Value: name},
+	}).Err())
+}
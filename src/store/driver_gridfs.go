@@ -0,0 +1,140 @@
+//go:build mongo_driver
+
+package store
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+type(
+	// GridFS stores files in the <prefix>.files and <prefix>.chunks
+	// collections, as mgo did.
+	GridFS struct {
+		bucket			*gridfs.Bucket
+		db				*Database
+		err				error
+	}
+
+	// GridFile is a file of a GridFS, opened either to be written or read.
+	GridFile struct {
+		gfs				*GridFS
+		name			string
+		contentType		string
+		upload			*gridfs.UploadStream
+		download		*gridfs.DownloadStream
+	}
+)
+
+func (d *Database) GridFS(prefix string) *GridFS {
+	bucket, err := gridfs.NewBucket(d.db, options.GridFSBucket().SetName(prefix))
+	return &GridFS{bucket: bucket, db: d, err: err}
+}
+
+// Create returns a new file named name, to be written and closed.
+func (g *GridFS) Create(name string) (*GridFile, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &GridFile{gfs: g, name: name}, nil
+}
+
+// Open opens the latest file named name for reading, and returns ErrNotFound
+// if there is none.
+func (g *GridFS) Open(name string) (*GridFile, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	download, err := g.bucket.OpenDownloadStreamByName(name)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	return &GridFile{gfs: g, name: name, download: download}, nil
+}
+
+// Remove deletes all the files named name.
+func (g *GridFS) Remove(name string) error {
+	if g.err != nil {
+		return g.err
+	}
+
+	ctx, cancel := g.db.context()
+	defer cancel()
+
+	cursor, err := g.bucket.FindContext(ctx, bson.M{"filename": name})
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	var files []struct {
+		ID		interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return wrapErr(err)
+	}
+
+	for _, f := range files {
+		if err := g.bucket.DeleteContext(ctx, f.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return wrapErr(err)
+		}
+	}
+
+	return nil
+}
+
+// SetContentType records the type of the file being written, in its metadata.
+func (f *GridFile) SetContentType(contentType string) {
+	f.contentType = contentType
+}
+
+// open starts the upload of the file being written, once its content type is
+// known.
+func (f *GridFile) open() error {
+	if f.upload != nil {
+		return nil
+	}
+
+	opts := options.GridFSUpload()
+	if f.contentType != "" {
+		opts.SetMetadata(bson.M{"contentType": f.contentType})
+	}
+
+	upload, err := f.gfs.bucket.OpenUploadStream(f.name, opts)
+	if err != nil {
+		return wrapErr(err)
+	}
+	f.upload = upload
+
+	return nil
+}
+
+func (f *GridFile) Write(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.upload.Write(p)
+}
+
+func (f *GridFile) Read(p []byte) (int, error) {
+	return f.download.Read(p)
+}
+
+// Close finishes writing or reading the file.
+func (f *GridFile) Close() error {
+	if f.download != nil {
+		return f.download.Close()
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.upload.Close()
+}
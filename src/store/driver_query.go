@@ -0,0 +1,339 @@
+//go:build mongo_driver
+
+package store
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+type(
+	// Query selects documents of a collection. Its methods setting it up
+	// change the query and return it.
+	Query struct {
+		c				*Collection
+		filter			interface{}
+		sort			primitive.D
+		projection		interface{}
+		skip			int64
+		limit			int64
+	}
+
+	// Iter goes through the documents of a cursor, one at a time.
+	Iter struct {
+		cursor			*mongo.Cursor
+		ctx				context.Context
+		cancel			context.CancelFunc
+		err				error
+	}
+
+	// Pipe runs an aggregation pipeline on a collection.
+	Pipe struct {
+		c				*Collection
+		pipeline		interface{}
+	}
+
+	// Bulk queues writes to a collection to send them together.
+	Bulk struct {
+		c				*Collection
+		models			[]mongo.WriteModel
+	}
+
+	BulkResult struct {
+		Matched			int
+		Modified		int
+	}
+)
+
+// Sort orders the documents by the fields, in descending order when prefixed
+// with "-", and by the text search score for "$textScore:<field>".
+func (q *Query) Sort(fields ...string) *Query {
+	q.sort = primitive.D{}
+	for _, field := range fields {
+		switch {
+		case strings.HasPrefix(field, "$textScore:"):
+			q.sort = append(q.sort, primitive.E{Key: strings.TrimPrefix(field, "$textScore:"), Value: bson.M{"$meta": "textScore"}})
+		case strings.HasPrefix(field, "-"):
+			q.sort = append(q.sort, primitive.E{Key: field[1:], Value: -1})
+		default:
+			q.sort = append(q.sort, primitive.E{Key: strings.TrimPrefix(field, "+"), Value: 1})
+		}
+	}
+	return q
+}
+
+func (q *Query) Select(selector interface{}) *Query {
+	q.projection = selector
+	return q
+}
+
+func (q *Query) Skip(n int) *Query {
+	q.skip = int64(n)
+	return q
+}
+
+func (q *Query) Limit(n int) *Query {
+	q.limit = int64(n)
+	return q
+}
+
+func (q *Query) find(ctx context.Context) (*mongo.Cursor, error) {
+	opts := options.Find()
+	if q.sort != nil {
+		opts.SetSort(q.sort)
+	}
+	if q.projection != nil {
+		opts.SetProjection(q.projection)
+	}
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+	if q.limit > 0 {
+		opts.SetLimit(q.limit)
+	}
+
+	cursor, err := q.c.c.Find(ctx, q.filter, opts)
+	return cursor, wrapErr(err)
+}
+
+// One decodes the first document selected into result, and returns
+// ErrNotFound if there is none.
+func (q *Query) One(result interface{}) error {
+	ctx, cancel := q.c.db.context()
+	defer cancel()
+
+	opts := options.FindOne()
+	if q.sort != nil {
+		opts.SetSort(q.sort)
+	}
+	if q.projection != nil {
+		opts.SetProjection(q.projection)
+	}
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+
+	res := q.c.c.FindOne(ctx, q.filter, opts)
+	if result == nil {
+		return wrapErr(res.Err())
+	}
+	return wrapErr(res.Decode(result))
+}
+
+// All decodes the documents selected into the slice result points to.
+func (q *Query) All(result interface{}) error {
+	ctx, cancel := q.c.db.context()
+	defer cancel()
+
+	cursor, err := q.find(ctx)
+	if err != nil {
+		return err
+	}
+
+	return wrapErr(cursor.All(ctx, result))
+}
+
+func (q *Query) Count() (int, error) {
+	ctx, cancel := q.c.db.context()
+	defer cancel()
+
+	opts := options.Count()
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+	if q.limit > 0 {
+		opts.SetLimit(q.limit)
+	}
+
+	n, err := q.c.c.CountDocuments(ctx, q.filter, opts)
+	return int(n), wrapErr(err)
+}
+
+// Iter returns an iterator over the documents selected, which must be closed.
+func (q *Query) Iter() *Iter {
+	ctx, cancel := q.c.db.context()
+
+	cursor, err := q.find(ctx)
+	return &Iter{cursor: cursor, ctx: ctx, cancel: cancel, err: err}
+}
+
+// Apply makes the change to the first document selected and decodes it into
+// result, as it was before the change unless change.ReturnNew is set. It
+// returns ErrNotFound if no document was selected and none was upserted.
+func (q *Query) Apply(change Change, result interface{}) (*ChangeInfo, error) {
+	ctx, cancel := q.c.db.context()
+	defer cancel()
+
+	returned := options.Before
+	if change.ReturnNew {
+		returned = options.After
+	}
+
+	var res *mongo.SingleResult
+	switch {
+	case change.Remove:
+		opts := options.FindOneAndDelete()
+		if q.sort != nil {
+			opts.SetSort(q.sort)
+		}
+		if q.projection != nil {
+			opts.SetProjection(q.projection)
+		}
+		res = q.c.c.FindOneAndDelete(ctx, q.filter, opts)
+	case isUpdate(change.Update):
+		opts := options.FindOneAndUpdate().SetUpsert(change.Upsert).SetReturnDocument(returned)
+		if q.sort != nil {
+			opts.SetSort(q.sort)
+		}
+		if q.projection != nil {
+			opts.SetProjection(q.projection)
+		}
+		res = q.c.c.FindOneAndUpdate(ctx, q.filter, change.Update, opts)
+	default:
+		opts := options.FindOneAndReplace().SetUpsert(change.Upsert).SetReturnDocument(returned)
+		if q.sort != nil {
+			opts.SetSort(q.sort)
+		}
+		if q.projection != nil {
+			opts.SetProjection(q.projection)
+		}
+		res = q.c.c.FindOneAndReplace(ctx, q.filter, change.Update, opts)
+	}
+
+	err := res.Err()
+	if err == mongo.ErrNoDocuments && change.Upsert && !change.ReturnNew {
+		// The document was upserted, so there was none before.
+		return &ChangeInfo{}, nil
+	}
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	if result != nil {
+		if err := res.Decode(result); err != nil {
+			return nil, err
+		}
+	}
+
+	if change.Remove {
+		return &ChangeInfo{Removed: 1, Matched: 1}, nil
+	}
+	return &ChangeInfo{Updated: 1, Matched: 1}, nil
+}
+
+// Next decodes the next document into result, and reports whether there was
+// one. Iteration stops at the first error, which Err returns.
+func (it *Iter) Next(result interface{}) bool {
+	if it.err != nil || !it.cursor.Next(it.ctx) {
+		if it.err == nil {
+			it.err = it.cursor.Err()
+		}
+		return false
+	}
+
+	if err := it.cursor.Decode(result); err != nil {
+		it.err = err
+		return false
+	}
+	return true
+}
+
+func (it *Iter) Err() error {
+	return wrapErr(it.err)
+}
+
+// Close closes the cursor, and returns the error that stopped the iteration.
+func (it *Iter) Close() error {
+	if it.cursor != nil {
+		it.cursor.Close(context.Background())
+	}
+	it.cancel()
+
+	return it.Err()
+}
+
+func (p *Pipe) cursor(ctx context.Context) (*mongo.Cursor, error) {
+	cursor, err := p.c.c.Aggregate(ctx, p.pipeline)
+	return cursor, wrapErr(err)
+}
+
+func (p *Pipe) All(result interface{}) error {
+	ctx, cancel := p.c.db.context()
+	defer cancel()
+
+	cursor, err := p.cursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	return wrapErr(cursor.All(ctx, result))
+}
+
+// One decodes the first document the pipeline outputs into result, and returns
+// ErrNotFound if there is none.
+func (p *Pipe) One(result interface{}) error {
+	ctx, cancel := p.c.db.context()
+	defer cancel()
+
+	cursor, err := p.cursor(ctx)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return wrapErr(err)
+		}
+		return ErrNotFound
+	}
+
+	return cursor.Decode(result)
+}
+
+func (p *Pipe) Iter() *Iter {
+	ctx, cancel := p.c.db.context()
+
+	cursor, err := p.cursor(ctx)
+	return &Iter{cursor: cursor, ctx: ctx, cancel: cancel, err: err}
+}
+
+// Update queues updating the first document matching each selector of the
+// selector and update pairs.
+func (b *Bulk) Update(pairs ...interface{}) {
+	for i := 0; i + 1 < len(pairs); i += 2 {
+		if isUpdate(pairs[i + 1]) {
+			b.models = append(b.models, mongo.NewUpdateOneModel().SetFilter(filterOf(pairs[i])).SetUpdate(pairs[i + 1]))
+		} else {
+			b.models = append(b.models, mongo.NewReplaceOneModel().SetFilter(filterOf(pairs[i])).SetReplacement(pairs[i + 1]))
+		}
+	}
+}
+
+func (b *Bulk) Insert(docs ...interface{}) {
+	for _, doc := range docs {
+		b.models = append(b.models, mongo.NewInsertOneModel().SetDocument(doc))
+	}
+}
+
+// Run sends the writes queued, in order, stopping at the first failing.
+func (b *Bulk) Run() (*BulkResult, error) {
+	if len(b.models) == 0 {
+		return &BulkResult{}, nil
+	}
+
+	ctx, cancel := b.c.db.context()
+	defer cancel()
+
+	res, err := b.c.c.BulkWrite(ctx, b.models, options.BulkWrite().SetOrdered(true))
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	return &BulkResult{Matched: int(res.MatchedCount), Modified: int(res.ModifiedCount)}, nil
+}
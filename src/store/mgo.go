@@ -0,0 +1,108 @@
+//go:build !mongo_driver
+
+package store
+
+import (
+	"context"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Unless built with the mongo_driver tag, the store is mgo's, with contexts
+// ignored.
+type(
+	Query		= mgo.Query
+	Iter		= mgo.Iter
+	Pipe		= mgo.Pipe
+	Bulk		= mgo.Bulk
+	Change		= mgo.Change
+	ChangeInfo	= mgo.ChangeInfo
+	GridFS		= mgo.GridFS
+	GridFile	= mgo.GridFile
+	BuildInfo	= mgo.BuildInfo
+	Stats		= mgo.Stats
+	QueryError	= mgo.QueryError
+
+	Session struct {
+		*mgo.Session
+	}
+
+	Database struct {
+		*mgo.Database
+	}
+
+	Collection struct {
+		*mgo.Collection
+	}
+)
+
+var ErrNotFound = mgo.ErrNotFound
+
+func IsDup(err error) bool {
+	return mgo.IsDup(err)
+}
+
+// SetStats turns collecting the statistics of GetStats on or off.
+func SetStats(enabled bool) {
+	mgo.SetStats(enabled)
+}
+
+func GetStats() Stats {
+	return mgo.GetStats()
+}
+
+func dial(info DialInfo) (*Session, error) {
+	s, err := mgo.DialWithTimeout(info.HostName, info.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s.SetMode(mgo.Monotonic, true)
+	// Copies of the session share its pool and inherit the timeouts.
+	if info.PoolLimit > 0 {
+		s.SetPoolLimit(info.PoolLimit)
+	}
+	if info.SocketTimeout > 0 {
+		s.SetSocketTimeout(info.SocketTimeout)
+	}
+
+	return &Session{s}, nil
+}
+
+func (s *Session) Copy() *Session {
+	return &Session{s.Session.Copy()}
+}
+
+func (s *Session) DB(name string) *Database {
+	return &Database{s.Session.DB(name)}
+}
+
+// WithContext returns the database. mgo does not take contexts.
+func (d *Database) WithContext(ctx context.Context) *Database {
+	return d
+}
+
+func (d *Database) C(name string) *Collection {
+	return &Collection{d.Database.C(name)}
+}
+
+// EnsureIndex creates the index unless it exists. mgo.Index has no partial
+// filter, so the index is created with the createIndexes command.
+func (c *Collection) EnsureIndex(index Index) error {
+	spec, err := indexSpec(index, func(fields []indexField) interface{} {
+		doc := bson.D{}
+		for _, f := range fields {
+			doc = append(doc, bson.DocElem{Name: f.name, Value: f.value})
+		}
+		return doc
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Database.Run(bson.D{
+		{Name: "createIndexes", Value: c.Name},
+		{Name: "indexes", Value: []bson.M{spec}},
+	}, nil)
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+const (
+	dialInitialWait	time.Duration = 500 * time.Millisecond
+	dialMaxWait		time.Duration = 30 * time.Second
+)
+
+type(
+	// DialInfo describes the MongoDB servers to dial, and the settings of the
+	// session.
+	DialInfo struct {
+		HostName		string
+		// Timeout bounds each attempt to connect, and how long operations wait
+		// for a server, so that they do not wait forever for a failed primary
+		// to be replaced.
+		Timeout			time.Duration
+		// SocketTimeout bounds waiting for a server's reply, when not zero.
+		SocketTimeout	time.Duration
+		// PoolLimit caps the connections to each server, when not zero.
+		PoolLimit		int
+	}
+
+	// Index describes an index of a collection. Key lists its fields, in
+	// descending order when prefixed with "-", and in a text index when
+	// prefixed with "$text:". The index is named after its key unless Name
+	// is set.
+	Index struct {
+		Key				[]string
+		Unique			bool
+		Sparse			bool
+		Background		bool
+		ExpireAfter		time.Duration
+		// PartialFilter limits the index to the documents matching it.
+		PartialFilter	bson.M
+		Name			string
+	}
+
+	// indexField is a field of an index key, or of the weights of a text
+	// index, with its value.
+	indexField struct {
+		name			string
+		value			interface{}
+	}
+)
+
+// DialWithRetry dials MongoDB, retrying up to maxRetries times with an
+// exponential backoff, so the server can start before the database is ready.
+func DialWithRetry(info DialInfo, maxRetries int) (*Session, error) {
+	wait := dialInitialWait
+
+	for attempt := 1; ; attempt++ {
+		sess, err := dial(info)
+		if err == nil {
+			return sess, nil
+		}
+
+		if attempt > maxRetries {
+			return nil, err
+		}
+
+		log.Warn().Err(err).Int("attempt", attempt).Dur("retryIn", wait).Msg("failed to connect to MongoDB")
+		time.Sleep(wait)
+
+		if wait *= 2; wait > dialMaxWait {
+			wait = dialMaxWait
+		}
+	}
+}
+
+// parseIndexKey returns the fields of the index key, the weights of its text
+// fields and the name MongoDB gives the index, the way mgo did.
+func parseIndexKey(key []string) ([]indexField, []indexField, string, error) {
+	var fields, weights []indexField
+	var names []string
+
+	for _, field := range key {
+		switch {
+		case strings.HasPrefix(field, "$text:") && len(field) > len("$text:"):
+			field = strings.TrimPrefix(field, "$text:")
+			if weights == nil {
+				fields = append(fields, indexField{"_fts", "text"}, indexField{"_ftsx", 1})
+			}
+			weights = append(weights, indexField{field, 1})
+			names = append(names, field + "_text")
+		case strings.HasPrefix(field, "-") && len(field) > 1:
+			fields = append(fields, indexField{field[1:], -1})
+			names = append(names, field[1:] + "_-1")
+		case field != "" && field[0] != '$':
+			field = strings.TrimPrefix(field, "+")
+			fields = append(fields, indexField{field, 1})
+			names = append(names, field + "_1")
+		default:
+			return nil, nil, "", fmt.Errorf(`invalid index key: want "[$text:][-]<field name>", got %q`, field)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, nil, "", errors.New("invalid index key: no fields provided")
+	}
+
+	return fields, weights, strings.Join(names, "_"), nil
+}
+
+// indexSpec returns the specification of the index for the createIndexes
+// command, given a way to make the ordered documents of its key.
+func indexSpec(index Index, ordered func([]indexField) interface{}) (bson.M, error) {
+	fields, weights, name, err := parseIndexKey(index.Key)
+	if err != nil {
+		return nil, err
+	}
+	if index.Name != "" {
+		name = index.Name
+	}
+
+	spec := bson.M{"name": name, "key": ordered(fields)}
+	if weights != nil {
+		spec["weights"] = ordered(weights)
+	}
+	if index.Unique {
+		spec["unique"] = true
+	}
+	if index.Sparse {
+		spec["sparse"] = true
+	}
+	if index.Background {
+		spec["background"] = true
+	}
+	if index.ExpireAfter > 0 {
+		spec["expireAfterSeconds"] = int(index.ExpireAfter / time.Second)
+	}
+	if index.PartialFilter != nil {
+		spec["partialFilterExpression"] = index.PartialFilter
+	}
+
+	return spec, nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownDueDate is returned by ParseDueDate for text it does not
+// recognise.
+var ErrUnknownDueDate = errors.New("The due date must be a date or a phrase such as tomorrow, next friday or in 3 days")
+
+var dueDateOffsetPattern = regexp.MustCompile(`^in (\d+) (day|week|month)s?$`)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday,
+	"monday": time.Monday,
+	"tuesday": time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday": time.Thursday,
+	"friday": time.Friday,
+	"saturday": time.Saturday,
+}
+
+// ParseDueDate reads a due date written as an RFC 3339 time, a YYYY-MM-DD
+// date, or one of the English phrases today, tomorrow, next <weekday> and
+// in N days, weeks or months. Dates are at midnight UTC.
+func ParseDueDate(s string) (*time.Time, error) {
+	return parseDueDate(s, time.Now())
+}
+
+func parseDueDate(s string, now time.Time) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var date time.Time
+
+	switch {
+	case s == "today":
+		date = today
+	case s == "tomorrow":
+		date = today.AddDate(0, 0, 1)
+	case strings.HasPrefix(s, "next "):
+		day, ok := weekdays[strings.TrimPrefix(s, "next ")]
+		if !ok {
+			return nil, ErrUnknownDueDate
+		}
+
+		// Next names the coming weekday, a week ahead on that day itself.
+		ahead := (int(day) - int(today.Weekday()) + 7) % 7
+		if ahead == 0 {
+			ahead = 7
+		}
+		date = today.AddDate(0, 0, ahead)
+	default:
+		if m := dueDateOffsetPattern.FindStringSubmatch(s); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, ErrUnknownDueDate
+			}
+
+			switch m[2] {
+			case "day":
+				date = today.AddDate(0, 0, n)
+			case "week":
+				date = today.AddDate(0, 0, 7*n)
+			case "month":
+				date = today.AddDate(0, n, 0)
+			}
+			break
+		}
+
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, ErrUnknownDueDate
+		}
+		date = t
+	}
+
+	return &date, nil
+}
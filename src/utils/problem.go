@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the media type of Problem responses.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details error response.
+type Problem struct {
+	Type		string
+	Title		string
+	Status		int
+	Detail		string
+	Instance	string
+
+	// Extensions holds additional members, such as the allowed values of an
+	// invalid field.
+	Extensions	map[string]interface{}
+}
+
+// NewProblem creates a problem of the generic "about:blank" type. The title
+// is a short, human-readable summary; detail explains this occurrence and may
+// be empty.
+func NewProblem(status int, title, detail string) Problem {
+	return Problem{
+		Type: "about:blank",
+		Title: title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// With returns the problem with the extension member key set to value.
+func (p Problem) With(key string, value interface{}) Problem {
+	extensions := map[string]interface{}{key: value}
+	for k, v := range p.Extensions {
+		if k != key {
+			extensions[k] = v
+		}
+	}
+
+	p.Extensions = extensions
+	return p
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := map[string]interface{}{}
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+
+	return json.Marshal(members)
+}
+
+// WriteProblem writes p as the response to r. Unless already set, its
+// instance identifies the request by its path and request id.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+
+		requestID := GetRequestID(r.Context())
+		if requestID == "" {
+			// Middleware running before RequestID only sees the id on the
+			// response.
+			requestID = w.Header().Get(RequestIDHeader)
+		}
+		if requestID != "" {
+			p.Instance += "#" + requestID
+		}
+	}
+
+	body, err := json.Marshal(p)
+	CheckErr(err)
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
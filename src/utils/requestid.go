@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/google/uuid"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// RequestID stamps every request with a trace id, reusing the incoming
+// X-Request-ID header when present, and echoes it back in the response. The id
+// is stored where chi's middleware looks for it, so middleware.Logger includes
+// it in its log lines.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.New().String()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetRequestID returns the trace id of the request ctx belongs to.
+func GetRequestID(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
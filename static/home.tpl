@@ -75,7 +75,17 @@
                 <div class="todo-title">
                     Daily Todo Lists
                 </div>
-                <div class="card-body">
+                <div class="card-body" v-if="!token">
+                    <form v-on:submit.prevent>
+                        <input type="email" v-model="credentials.email" class="form-control custom-input" :class="{ 'error': showError }" placeholder="Email">
+                        <input type="password" v-model="credentials.password" v-on:keyup.enter="login" class="form-control custom-input" :class="{ 'error': showError }" placeholder="Password">
+                        <div class="btn-group d-flex" role="group">
+                            <button class="btn btn-success custom-button w-100" type="button" v-on:click="login">Log in</button>
+                            <button class="btn btn-secondary custom-button w-100" type="button" v-on:click="register">Register</button>
+                        </div>
+                    </form>
+                </div>
+                <div class="card-body" v-else>
                     <form v-on:submit.prevent>
                         <div class="input-group">
                             <input type="text" v-model="todo.title" v-on:keyup="checkForEnter($event)" class="form-control custom-input" :class="{ 'error': showError }" placeholder="Add your todo">
@@ -105,28 +115,66 @@
 <script src="https://cdnjs.cloudflare.com/ajax/libs/popper.js/1.12.3/umd/popper.min.js" integrity="sha384-vFJXuSJphROIrBnz7yo7oB41mKfc8JzQZiCq4NCceLEaO4IHwicKwpJf9c9IpFgh" crossorigin="anonymous"></script>
 <script src="https://maxcdn.bootstrapcdn.com/bootstrap/4.0.0-beta.2/js/bootstrap.min.js" integrity="sha384-alpBpkh1PFOepccYVYDB4do5UnbKysX5WZXm3XxPqe5iKTfUKjNkCk9SaVuEZflJ" crossorigin="anonymous"></script>
 <script type="text/javascript">
+    Vue.http.interceptors.push(function (request, next) {
+        var token = localStorage.getItem('token');
+        if (token) {
+            request.headers.set('Authorization', 'Bearer ' + token);
+        }
+        next(function (response) {
+            if (response.status == 401) {
+                localStorage.removeItem('token');
+                this.token = null;
+            }
+        });
+    });
+
     var Vue = new Vue({
         el: '#root',
         delimiters: ['@{', '}'],
         data: {
             showError: false,
             enableEdit: false,
+            token: localStorage.getItem('token'),
+            credentials: {email: '', password: ''},
             todo: {id: '', title: '', completed: false},
             todos: []
         },
         mounted () {
-            this.$http.get('todo').then(response => {
-                this.todos = response.body.data;
-            });
+            if (this.token) {
+                this.fetchTodos();
+            }
         },
         methods: {
+            fetchTodos(){
+                this.$http.get('todo').then(response => {
+                    this.todos = response.body.data || [];
+                });
+            },
+            login(){
+                this.$http.post('auth/login', this.credentials).then(response => {
+                    this.showError = false;
+                    this.token = response.body.token;
+                    localStorage.setItem('token', this.token);
+                    this.credentials = {email: '', password: ''};
+                    this.fetchTodos();
+                }, response => {
+                    this.showError = true;
+                });
+            },
+            register(){
+                this.$http.post('auth/register', this.credentials).then(response => {
+                    this.login();
+                }, response => {
+                    this.showError = true;
+                });
+            },
             addTodo(){
                 if (this.todo.title == ''){
                     this.showError = true;
                 }else{
                     this.showError = false;
                     if(this.enableEdit){
-                        this.$http.put('todo/'+this.todo.id, this.todo).then(response => {
+                        this.$http.patch('todo/'+this.todo.id, {title: this.todo.title}).then(response => {
                             if(response.status == 200){
                                 this.todos[this.todo.todoIndex] = this.todo;
                             }
@@ -149,15 +197,9 @@
                 }
             },
             toggleTodo(todo, todoIndex){
-                var completedToggle;
-                if (todo.completed == true) {
-                    completedToggle = false;
-                }else{
-                    completedToggle = true;
-                }
-                this.$http.put('todo/'+todo.id, {id: todo.id, title: todo.title, completed: completedToggle}).then(response => {
+                this.$http.post('todo/'+todo.id+'/toggle').then(response => {
                     if(response.status == 200){
-                        this.todos[todoIndex].completed = completedToggle;
+                        this.todos[todoIndex].completed = response.body.data.completed;
                     }
                 });
             },
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const statsCacheTTL time.Duration = 30 * time.Second
+
+type(
+	// StatsResponse summarises a user's todos.
+	StatsResponse struct {
+		Total				int `json:"total"`
+		Completed			int `json:"completed"`
+		Pending				int `json:"pending"`
+		Overdue				int `json:"overdue"`
+		ByPriority			map[string]int `json:"byPriority"`
+		CompletedThisWeek	int `json:"completedThisWeek"`
+	}
+
+	cachedStats struct {
+		stats		StatsResponse
+		cachedAt	time.Time
+	}
+
+	statsCount []struct {
+		N			int `bson:"n"`
+	}
+)
+
+var (
+	statsMu		sync.Mutex
+	statsCache	= map[bson.ObjectId]cachedStats{}
+)
+
+func (c statsCount) value() int {
+	if len(c) == 0 {
+		return 0
+	}
+	return c[0].N
+}
+
+// getTodoStats returns the user's todo counts, computed in a single
+// aggregation and cached for a short while.
+//
+// @Summary Get todo statistics
+// @Tags todos
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=StatsResponse}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/stats [get]
+func getTodoStats(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	statsMu.Lock()
+	cached, ok := statsCache[userID]
+	statsMu.Unlock()
+
+	if ok && time.Since(cached.cachedAt) < statsCacheTTL {
+		rnd.JSON(w, http.StatusOK, renderer.M{"data": cached.stats})
+		return
+	}
+
+	now := time.Now()
+
+	var result struct {
+		Total				statsCount `bson:"total"`
+		Completed			statsCount `bson:"completed"`
+		Overdue				statsCount `bson:"overdue"`
+		CompletedThisWeek	statsCount `bson:"completedThisWeek"`
+		ByPriority			[]struct {
+			Priority	string `bson:"_id"`
+			Count		int `bson:"count"`
+		} `bson:"byPriority"`
+	}
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".aggregate", func() error {
+		return dbFor(r).C(cfg.CollectionName).Pipe([]bson.M{
+			{"$match": ownedBy(r, bson.M{"archived": bson.M{"$ne": true}})},
+			{"$facet": bson.M{
+				"total": []bson.M{{"$count": "n"}},
+				"completed": []bson.M{
+					{"$match": bson.M{"completed": true}},
+					{"$count": "n"},
+				},
+				"overdue": []bson.M{
+					{"$match": bson.M{"completed": false, "dueDate": bson.M{"$lt": now}}},
+					{"$count": "n"},
+				},
+				"completedThisWeek": []bson.M{
+					{"$match": bson.M{"completedAt": bson.M{"$gte": now.AddDate(0, 0, -7)}}},
+					{"$count": "n"},
+				},
+				"byPriority": []bson.M{
+					{"$group": bson.M{"_id": "$priority", "count": bson.M{"$sum": 1}}},
+				},
+			}},
+		}).One(&result)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to compute todo stats")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch Todo stats", err.Error()))
+		return
+	}
+
+	stats := StatsResponse{
+		Total: result.Total.value(),
+		Completed: result.Completed.value(),
+		Overdue: result.Overdue.value(),
+		ByPriority: map[string]int{},
+		CompletedThisWeek: result.CompletedThisWeek.value(),
+	}
+	stats.Pending = stats.Total - stats.Completed
+
+	for _, p := range priorities {
+		stats.ByPriority[p] = 0
+	}
+	for _, p := range result.ByPriority {
+		stats.ByPriority[p.Priority] = p.Count
+	}
+
+	statsMu.Lock()
+	for id, c := range statsCache {
+		if now.Sub(c.cachedAt) >= statsCacheTTL {
+			delete(statsCache, id)
+		}
+	}
+	statsCache[userID] = cachedStats{stats: stats, cachedAt: now}
+	statsMu.Unlock()
+
+	rnd.JSON(w, http.StatusOK, renderer.M{"data": stats})
+}
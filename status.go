@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	statusBacklog		string = "backlog"
+	statusInProgress	string = "in_progress"
+	statusReview		string = "review"
+	statusDone			string = "done"
+	statusCancelled		string = "cancelled"
+)
+
+var statuses = []string{statusBacklog, statusInProgress, statusReview, statusDone, statusCancelled}
+
+// statusTransitions lists the statuses each status can move to through
+// PUT /todo/{id}/status. Work is reviewed before it is done, and reopening
+// done work sends it back to review.
+var statusTransitions = map[string][]string{
+	statusBacklog: {statusInProgress, statusCancelled},
+	statusInProgress: {statusBacklog, statusReview, statusCancelled},
+	statusReview: {statusInProgress, statusDone, statusCancelled},
+	statusDone: {statusReview},
+	statusCancelled: {statusBacklog},
+}
+
+// todoStatus returns the status of t. Todos saved before statuses were added
+// have none until cmd/migrate-status runs, so it is derived from completed.
+func todoStatus(t TodoModel) string {
+	switch {
+	case t.Status != "":
+		return t.Status
+	case t.Completed:
+		return statusDone
+	default:
+		return statusBacklog
+	}
+}
+
+func validStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+func canTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// completionStatus returns the status a todo moves to when its completed
+// flag is set directly, which bypasses the transitions.
+func completionStatus(current TodoModel, completed bool) string {
+	switch {
+	case completed == current.Completed:
+		return todoStatus(current)
+	case completed:
+		return statusDone
+	default:
+		return statusBacklog
+	}
+}
+
+// setStatus adds the fields moving current to status to set and unset. The
+// completed flag is stored alongside the status, so that the queries on it
+// keep working.
+func setStatus(set, unset bson.M, current TodoModel, status string) {
+	set["status"] = status
+	set["completed"] = status == statusDone
+	setCompletion(set, unset, current.Completed, status == statusDone)
+}
+
+// @Summary Change the status of a todo
+// @Tags todos
+// @Accept json
+// @Produce json
+// @Param id path string true "The todo id"
+// @Param body body object{status=string} true "The status"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/status [put]
+func updateTodoStatus(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Status string `json:"status" validate:"required"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, body) {
+		return
+	}
+
+	if !writeStatusProblem(w, r, todo, body.Status) {
+		return
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	setStatus(set, unset, todo, body.Status)
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	applyTodoUpdate(w, r, "status_changed", bson.M{"_id": todo.ID}, update)
+}
+
+// writeStatusProblem checks that todo can move to status. When it cannot,
+// the error response is written and false is returned.
+func writeStatusProblem(w http.ResponseWriter, r *http.Request, todo TodoModel, status string) bool {
+	if !validStatus(status) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The status is invalid", "").
+			With("allowed", statuses))
+		return false
+	}
+
+	from := todoStatus(todo)
+	if !canTransition(from, status) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "A todo cannot move from " + from + " to " + status, "").
+			With("allowed", statusTransitions[from]))
+		return false
+	}
+
+	return true
+}
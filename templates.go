@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const templateCollectionName string = "Template"
+
+type(
+	TemplateModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		Name			string `bson:"name"`
+		Todos			[]TemplateTodo `bson:"todos"`
+		CreatedAt		time.Time `bson:"createdAt"`
+	}
+
+	// TemplateTodo is a todo of a template: the fields each todo created
+	// from the template starts with.
+	TemplateTodo struct {
+		Title			string `bson:"title" json:"title" validate:"required,max=200"`
+		Description		string `bson:"description" json:"description,omitempty" validate:"max=4000"`
+		Tags			[]string `bson:"tags" json:"tags"`
+		Priority		string `bson:"priority" json:"priority"`
+		Subtasks		[]string `bson:"subtasks" json:"subtasks"`
+	}
+
+	Template struct {
+		ID				string `json:"id"`
+		Name			string `json:"name" validate:"required,max=100"`
+		Todos			[]TemplateTodo `json:"todos" validate:"required,min=1,max=500,dive"`
+		CreatedAt		time.Time `json:"createdAt"`
+	}
+)
+
+func toTemplate(t TemplateModel) Template {
+	return Template{
+		ID: t.ID.Hex(),
+		Name: t.Name,
+		Todos: t.Todos,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+// @Summary List templates
+// @Tags templates
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=[]Template}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /templates [get]
+func fetchTemplates(w http.ResponseWriter, r *http.Request) {
+	var templates []TemplateModel
+
+	if err := timedOp(r.Context(), templateCollectionName + ".find", func() error {
+		return dbFor(r).C(templateCollectionName).Find(ownedBy(r, bson.M{})).Sort("name").All(&templates)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch templates")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch templates", err.Error()))
+		return
+	}
+
+	templateList := []Template{}
+	for _, t := range templates {
+		templateList = append(templateList, toTemplate(t))
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": templateList,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Get a template
+// @Tags templates
+// @Produce json
+// @Param id path string true "The template id"
+// @Success 200 {object} DataEnvelope{data=Template}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /templates/{id} [get]
+func getTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := findTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toTemplate(template),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// decodeTemplate reads and validates the template in the request body. When
+// it is invalid, the error response is written and ok is false.
+func decodeTemplate(w http.ResponseWriter, r *http.Request) (t Template, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return t, false
+	}
+
+	t.Name = strings.TrimSpace(t.Name)
+	if !checkInput(w, r, t) {
+		return t, false
+	}
+
+	for i := range t.Todos {
+		todo := &t.Todos[i]
+
+		priority, ok := parsePriority(todo.Priority)
+		if !ok {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The priority is invalid", "").
+				With("index", i).
+				With("allowed", priorities))
+			return t, false
+		}
+		todo.Priority = priority
+		todo.Tags = normalizeTags(todo.Tags)
+
+		var subtasks []string
+		for _, st := range todo.Subtasks {
+			if st = strings.TrimSpace(st); st != "" {
+				subtasks = append(subtasks, st)
+			}
+		}
+		todo.Subtasks = subtasks
+	}
+
+	return t, true
+}
+
+// @Summary Create a template
+// @Tags templates
+// @Accept json
+// @Produce json
+// @Param body body Template true "The template"
+// @Success 201 {object} DataEnvelope{data=Template}
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /templates [post]
+func createTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := decodeTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template := TemplateModel{
+		ID: bson.NewObjectId(),
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		Name: t.Name,
+		Todos: t.Todos,
+		CreatedAt: time.Now(),
+	}
+
+	if err := timedOp(r.Context(), templateCollectionName + ".insert", func() error {
+		return dbFor(r).C(templateCollectionName).Insert(&template)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save template")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save template", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": toTemplate(template),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Replace a template
+// @Tags templates
+// @Accept json
+// @Produce json
+// @Param id path string true "The template id"
+// @Param body body Template true "The template"
+// @Success 200 {object} DataEnvelope{data=Template}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /templates/{id} [put]
+func updateTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := findTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	t, ok := decodeTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	template.Name, template.Todos = t.Name, t.Todos
+
+	if err := timedOp(r.Context(), templateCollectionName + ".update", func() error {
+		return dbFor(r).C(templateCollectionName).UpdateId(template.ID, bson.M{
+			"$set": bson.M{"name": template.Name, "todos": template.Todos},
+		})
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to update template")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update template", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toTemplate(template),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// deleteTemplate removes the template. Todos created from it are kept.
+//
+// @Summary Delete a template
+// @Tags templates
+// @Produce json
+// @Param id path string true "The template id"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /templates/{id} [delete]
+func deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := findTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := timedOp(r.Context(), templateCollectionName + ".remove", func() error {
+		return dbFor(r).C(templateCollectionName).RemoveId(template.ID)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to delete template")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete template", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Template deleted successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// instantiateTemplate creates the template's todos, in one insert, at the
+// end of the user's todos.
+//
+// @Summary Create the todos of a template
+// @Tags templates
+// @Produce json
+// @Param id path string true "The template id"
+// @Success 201 {object} object{message=string,data=[]string} "The ids of the todos created"
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /templates/{id}/instantiate [post]
+func instantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := findTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	position, err := nextPosition(r, nil)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save todos")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save todos", ""))
+		return
+	}
+
+	now := time.Now()
+	docs := make([]TodoModel, len(template.Todos))
+	ids := make([]string, len(template.Todos))
+
+	for i, t := range template.Todos {
+		docs[i] = TodoModel{
+			ID: bson.NewObjectId(),
+			UserID: currentUserID(r),
+			TenantID: currentTenantID(r),
+			Title: t.Title,
+			Status: statusBacklog,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Tags: normalizeTags(t.Tags),
+			Priority: t.Priority,
+			PriorityOrder: priorityOrder[t.Priority],
+			Description: t.Description,
+			Position: position,
+			FromTemplate: &template.ID,
+		}
+		for _, st := range t.Subtasks {
+			docs[i].Subtasks = append(docs[i].Subtasks, SubtaskModel{ID: bson.NewObjectId(), Title: st})
+		}
+
+		position += positionSpacing
+		ids[i] = docs[i].ID.Hex()
+	}
+
+	span := startMongoSpan(r, "mongo.insertMany", nil)
+	failed, err := insertTodos(r.Context(), docs)
+	endSpan(span, err)
+	if err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save todos")
+		p := utils.NewProblem(http.StatusProcessing, "Failed to save todos", "")
+		if failed >= 0 {
+			p = p.With("index", failed)
+		}
+		utils.WriteProblem(w, r, p)
+		return
+	}
+
+	invalidateTodoCache(r)
+	recordCreated(r, docs)
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"message": strconv.Itoa(len(ids)) + " todos created successfully",
+		"data": ids,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// findTemplate loads the user's template identified by the {id} URL
+// parameter. When the id is invalid or no template matches, the error
+// response is written and ok is false.
+func findTemplate(w http.ResponseWriter, r *http.Request) (template TemplateModel, ok bool) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id is invalid", ""))
+		return template, false
+	}
+
+	if err := timedOp(r.Context(), templateCollectionName + ".find", func() error {
+		return dbFor(r).C(templateCollectionName).Find(ownedBy(r, bson.M{"_id": bson.ObjectIdHex(id)})).One(&template)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Template not found", ""))
+			return template, false
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch template")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch template", err.Error()))
+		return template, false
+	}
+
+	return template, true
+}
+
+func templateHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(authMiddleware)
+	rg.Use(jsonAPIMiddleware("templates"))
+
+	rg.Group(func(r chi.Router) {
+		r.Get("/", fetchTemplates)
+		r.Post("/", createTemplate)
+		r.Get("/{id}", getTemplate)
+		r.Put("/{id}", updateTemplate)
+		r.Delete("/{id}", deleteTemplate)
+		r.Post("/{id}/instantiate", instantiateTemplate)
+	})
+
+	return rg
+}
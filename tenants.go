@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const tenantCollectionName string = "Tenant"
+
+const tenantIDKey contextKey = "tenantID"
+
+// tenantSlugPattern matches the slugs that are valid subdomains.
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+type(
+	// TenantModel is an organization whose users and data are isolated from
+	// those of every other tenant.
+	TenantModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		Name			string `bson:"name"`
+		Slug			string `bson:"slug"`
+		CreatedAt		time.Time `bson:"createdAt"`
+	}
+
+	Tenant struct {
+		ID				string `json:"id"`
+		Name			string `json:"name" validate:"required,max=100"`
+		Slug			string `json:"slug" validate:"required,max=63"`
+		CreatedAt		time.Time `json:"createdAt"`
+	}
+)
+
+func toTenant(t TenantModel) Tenant {
+	return Tenant{
+		ID: t.ID.Hex(),
+		Name: t.Name,
+		Slug: t.Slug,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+// tenantMiddleware stores the tenant the request is for in the request
+// context, identified by the X-Tenant-ID header or else by the subdomain
+// matching its slug. Requests for unknown tenants are rejected. It does
+// nothing unless multi-tenancy is enabled.
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.MultiTenant {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var filter bson.M
+
+		if id := strings.TrimSpace(r.Header.Get("X-Tenant-ID")); id != "" {
+			if !bson.IsObjectIdHex(id) {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The X-Tenant-ID header is invalid", ""))
+				return
+			}
+			filter = bson.M{"_id": bson.ObjectIdHex(id)}
+		} else if slug := subdomain(r.Host); slug != "" {
+			filter = bson.M{"slug": slug}
+		} else {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The X-Tenant-ID header is required", ""))
+			return
+		}
+
+		var tenant TenantModel
+
+		if err := timedOp(r.Context(), tenantCollectionName + ".find", func() error {
+			return dbFor(r).C(tenantCollectionName).Find(filter).Select(bson.M{"_id": 1}).One(&tenant)
+		}); err != nil {
+			if err == store.ErrNotFound {
+				utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Tenant not found", ""))
+				return
+			}
+
+			logFor(r).Error().Err(err).Msg("failed to fetch tenant")
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch tenant", ""))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantIDKey, tenant.ID)))
+	})
+}
+
+// subdomain returns the leftmost label of host when it has one beyond the
+// domain name, such as acme in acme.todo.example.com.
+func subdomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+
+	labels := strings.Split(strings.ToLower(host), ".")
+	if len(labels) < 3 {
+		return ""
+	}
+
+	return labels[0]
+}
+
+// currentTenantID returns the id of the tenant the request is for, empty when
+// multi-tenancy is disabled.
+func currentTenantID(r *http.Request) bson.ObjectId {
+	id, _ := r.Context().Value(tenantIDKey).(bson.ObjectId)
+	return id
+}
+
+// inTenant restricts filter to documents of the request's tenant.
+func inTenant(r *http.Request, filter bson.M) bson.M {
+	if id := currentTenantID(r); id != bson.NilObjectId {
+		filter["tenantID"] = id
+	}
+	return filter
+}
+
+// adminTokenMiddleware only lets through requests sending the configured
+// admin token in the X-Admin-Token header. Without an admin token every
+// request is rejected.
+func adminTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Admin-Token")
+
+		if cfg.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusForbidden, "Admin access is required", ""))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary Create a tenant
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param X-Admin-Token header string true "The admin token"
+// @Param body body Tenant true "The tenant"
+// @Success 201 {object} DataEnvelope{data=Tenant}
+// @Failure 403 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Router /tenants [post]
+func createTenant(w http.ResponseWriter, r *http.Request) {
+	var t Tenant
+
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	t.Name = strings.TrimSpace(t.Name)
+	t.Slug = strings.ToLower(strings.TrimSpace(t.Slug))
+	if !checkInput(w, r, t) {
+		return
+	}
+
+	if !tenantSlugPattern.MatchString(t.Slug) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The slug must only contain letters, digits and hyphens", ""))
+		return
+	}
+
+	tenant := TenantModel{
+		ID: bson.NewObjectId(),
+		Name: t.Name,
+		Slug: t.Slug,
+		CreatedAt: time.Now(),
+	}
+
+	if err := timedOp(r.Context(), tenantCollectionName + ".insert", func() error {
+		return dbFor(r).C(tenantCollectionName).Insert(&tenant)
+	}); err != nil {
+		if store.IsDup(err) {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The slug is already taken", ""))
+			return
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to save tenant")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save tenant", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": toTenant(tenant),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+func tenantHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(adminTokenMiddleware)
+
+	rg.Group(func(r chi.Router) {
+		r.Post("/", createTenant)
+	})
+
+	return rg
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// startTimer starts timing the work on the todo identified by {id}.
+//
+// @Summary Start tracking time on a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/time/start [post]
+func startTimer(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	if todo.TimerStartedAt != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The timer is already running", "").
+			With("timerStartedAt", todo.TimerStartedAt))
+		return
+	}
+
+	applyTodoUpdate(w, r, "timer_started", bson.M{
+		"_id": todo.ID, "timerStartedAt": bson.M{"$exists": false},
+	}, bson.M{
+		"$set": bson.M{"timerStartedAt": time.Now()},
+	})
+}
+
+// stopTimer stops the todo's timer and adds the minutes it ran, rounded to
+// the nearest minute, to the todo's actual minutes.
+//
+// @Summary Stop tracking time on a todo
+// @Tags todos
+// @Produce json
+// @Param id path string true "The todo id"
+// @Success 200 {object} DataEnvelope{data=Todo}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 409 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /todo/{id}/time/stop [post]
+func stopTimer(w http.ResponseWriter, r *http.Request) {
+	todo, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	if todo.TimerStartedAt == nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusConflict, "The timer is not running", ""))
+		return
+	}
+
+	elapsed := int(math.Round(time.Since(*todo.TimerStartedAt).Minutes()))
+
+	// Matching on the start time keeps a concurrent stop from counting the
+	// same time twice.
+	applyTodoUpdate(w, r, "timer_stopped", bson.M{
+		"_id": todo.ID, "timerStartedAt": todo.TimerStartedAt,
+	}, bson.M{
+		"$inc": bson.M{"actualMinutes": elapsed},
+		"$unset": bson.M{"timerStartedAt": ""},
+	})
+}
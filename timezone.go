@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// userLocation returns the time zone of the user's preferences, UTC when
+// they set none.
+func userLocation(r *http.Request) *time.Location {
+	tz := currentPreferences(r).Timezone
+	if tz == "" {
+		return time.UTC
+	}
+
+	// Time zones are checked when saved, but may have been dropped from the
+	// zone database since.
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// localTime formats t in the user's time zone.
+func localTime(r *http.Request, t time.Time) string {
+	return t.In(userLocation(r)).Format(time.RFC3339)
+}
+
+// toLocalTodo is toTodo with the todo's times also given in the user's time
+// zone. Times are still stored in UTC.
+func toLocalTodo(r *http.Request, t TodoModel) Todo {
+	todo := toTodo(t)
+	todo.CreatedAtLocal = localTime(r, todo.CreatedAt)
+	return todo
+}
+
+// setLocalTimes gives the times of list in the user's time zone.
+func setLocalTimes(r *http.Request, list []Todo) {
+	for i := range list {
+		list[i].CreatedAtLocal = localTime(r, list[i].CreatedAt)
+	}
+}
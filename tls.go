@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	certReloadInterval	time.Duration = 10 * time.Minute
+	certExpiryWarning	time.Duration = 30 * 24 * time.Hour
+)
+
+// certManager serves a TLS certificate loaded from disk, reloading it when the
+// files change so certificates can be rotated without a restart.
+type certManager struct {
+	certFile	string
+	keyFile		string
+
+	mu			sync.RWMutex
+	cert		*tls.Certificate
+	modTime		time.Time
+}
+
+// newCertManager loads the certificate and key and starts checking them for
+// changes in the background.
+func newCertManager(certFile, keyFile string) (*certManager, error) {
+	m := &certManager{certFile: certFile, keyFile: keyFile}
+
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for range time.Tick(certReloadInterval) {
+			if err := m.reload(); err != nil {
+				log.Error().Err(err).Msg("failed to reload the TLS certificate")
+			}
+		}
+	}()
+
+	return m, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *certManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cert, nil
+}
+
+// reload loads the certificate again if either file has been modified since
+// the last load, and warns when it is close to expiring.
+func (m *certManager) reload() error {
+	modTime, err := latestModTime(m.certFile, m.keyFile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	cert, unchanged := m.cert, m.cert != nil && !modTime.After(m.modTime)
+	m.mu.RUnlock()
+
+	if !unchanged {
+		loaded, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		m.cert, m.modTime = &loaded, modTime
+		m.mu.Unlock()
+
+		cert = &loaded
+		log.Info().Str("certFile", m.certFile).Msg("loaded the TLS certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	if remaining := time.Until(leaf.NotAfter); remaining < certExpiryWarning {
+		log.Warn().Time("notAfter", leaf.NotAfter).Msg("the TLS certificate expires in less than 30 days")
+	}
+
+	return nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
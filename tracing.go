@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const tracerName = "github.com/nkpremices/go-chi-mongodb-simple-todo"
+
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing exports spans over OTLP/HTTP to the given endpoint. With no
+// endpoint the global no-op tracer provider is left in place, so spans cost
+// nothing. The returned function flushes and stops the exporter.
+func setupTracing(endpoint string) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	utils.CheckErr(err)
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown
+}
+
+// startMongoSpan starts a child span of the request's span for a MongoDB
+// operation such as "mongo.find", recording the collection and filter.
+func startMongoSpan(r *http.Request, op string, filter interface{}) trace.Span {
+	_, span := tracer.Start(r.Context(), op, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.collection", cfg.CollectionName),
+		attribute.String("db.query.filter", fmt.Sprintf("%v", filter)),
+	)
+
+	return span
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+// ValidationError describes a field of a request body that failed one of its
+// validate rules.
+type ValidationError struct {
+	Field		string `json:"field"`
+	Tag			string `json:"tag"`
+	Value		string `json:"value"`
+}
+
+var validate = newValidator()
+
+// newValidator creates a validator reporting fields by their JSON names.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	return v
+}
+
+// validateInput checks v against its validate struct tags, returning one
+// ValidationError per failing rule. Given fields, only those are checked.
+func validateInput(v interface{}, fields ...string) []ValidationError {
+	var err error
+	if len(fields) > 0 {
+		err = validate.StructPartial(v, fields...)
+	} else {
+		err = validate.Struct(v)
+	}
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		utils.CheckErr(err)
+	}
+
+	var errs []ValidationError
+	for _, fe := range fieldErrs {
+		errs = append(errs, ValidationError{Field: fe.Field(), Tag: fe.Tag(), Value: fe.Param()})
+	}
+
+	return errs
+}
+
+// checkInput validates v, or only the fields given. When it is invalid, the
+// 422 response listing the failing fields is written and false is returned.
+func checkInput(w http.ResponseWriter, r *http.Request, v interface{}, fields ...string) bool {
+	errs := validateInput(v, fields...)
+	if len(errs) == 0 {
+		return true
+	}
+
+	utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The body is invalid", "").
+		With("errors", errs))
+	return false
+}
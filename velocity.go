@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	defaultVelocityWeeks	int = 8
+	maxVelocityWeeks		int = 52
+)
+
+// VelocityWeek counts the todos of a list completed in an ISO week, such as
+// 2024-W01.
+type VelocityWeek struct {
+	Week			string `json:"week"`
+	Completed		int `json:"completed"`
+}
+
+func isoWeekLabel(year, week int) string {
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// fetchVelocity returns the todos of the list completed in each of the last
+// weeks, the current one included, in UTC. Weeks without completions are
+// listed but left out of averagePerWeek, as they are usually holidays or
+// breaks between sprints.
+//
+// @Summary Get the weekly velocity of a list
+// @Tags lists
+// @Produce json
+// @Param listId path string true "The list id"
+// @Param weeks query int false "The weeks to cover, 8 by default and at most 52"
+// @Success 200 {object} object{data=[]VelocityWeek,averagePerWeek=number}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /lists/{listId}/velocity [get]
+func fetchVelocity(w http.ResponseWriter, r *http.Request) {
+	list, ok := findList(w, r, chi.URLParam(r, "listId"))
+	if !ok {
+		return
+	}
+
+	weeks := defaultVelocityWeeks
+	if v := r.URL.Query().Get("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The weeks must be a positive integer", ""))
+			return
+		}
+		if n > maxVelocityWeeks {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The weeks must not exceed 52", ""))
+			return
+		}
+		weeks = n
+	}
+
+	// The range starts on the Monday of the first week.
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monday := today.AddDate(0, 0, -(int(today.Weekday()) + 6) % 7)
+	start := monday.AddDate(0, 0, -7 * (weeks - 1))
+
+	var groups []struct {
+		ID struct {
+			Year		int `bson:"year"`
+			Week		int `bson:"week"`
+		} `bson:"_id"`
+		Count			int `bson:"count"`
+	}
+
+	if err := timedOp(r.Context(), cfg.CollectionName + ".aggregate", func() error {
+		return dbFor(r).C(cfg.CollectionName).Pipe([]bson.M{
+			{"$match": ownedBy(r, bson.M{
+				"listID": list.ID,
+				"archived": bson.M{"$ne": true},
+				"completed": true,
+				"completedAt": bson.M{"$gte": start},
+			})},
+			{"$group": bson.M{
+				"_id": bson.M{
+					"year": bson.M{"$isoWeekYear": "$completedAt"},
+					"week": bson.M{"$isoWeek": "$completedAt"},
+				},
+				"count": bson.M{"$sum": 1},
+			}},
+		}).All(&groups)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to compute velocity")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch velocity", err.Error()))
+		return
+	}
+
+	completed := map[string]int{}
+	for _, g := range groups {
+		completed[isoWeekLabel(g.ID.Year, g.ID.Week)] = g.Count
+	}
+
+	velocity := []VelocityWeek{}
+	total, active := 0, 0
+	for i := 0; i < weeks; i++ {
+		label := isoWeekLabel(start.AddDate(0, 0, 7 * i).ISOWeek())
+		velocity = append(velocity, VelocityWeek{Week: label, Completed: completed[label]})
+
+		if completed[label] > 0 {
+			total += completed[label]
+			active++
+		}
+	}
+
+	average := 0.0
+	if active > 0 {
+		average = float64(total) / float64(active)
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": velocity,
+		"averagePerWeek": average,
+	})
+
+	utils.CheckErr(jsonErr)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const apiVersionKey contextKey = "apiVersion"
+
+// v1Fields are the keys of a todo in version 1 of the API, which predates
+// lists, tags, priorities, subtasks and statuses.
+var v1Fields = []string{"id", "title", "completed", "createdAt"}
+
+// apiVersion returns the version of the API the request is for, 2 unless it
+// went through the version 1 router.
+func apiVersion(r *http.Request) int {
+	if v, ok := r.Context().Value(apiVersionKey).(int); ok {
+		return v
+	}
+	return 2
+}
+
+// deprecatedMiddleware marks the responses of version 1 as deprecated, and
+// points clients to version 2.
+func deprecatedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecated", "true")
+		w.Header().Set("Link", `</v2/todo>; rel="successor-version"`)
+
+		ctx := context.WithValue(r.Context(), apiVersionKey, 1)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// versionedHandler serves /todo with v2, or with v1 for the clients passing
+// version=1 because they cannot move to the /v1 prefix.
+func versionedHandler(v1, v2 http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("version") {
+		case "", "2":
+			v2.ServeHTTP(w, r)
+		case "1":
+			v1.ServeHTTP(w, r)
+		default:
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The version must be 1 or 2", ""))
+		}
+	})
+}
+
+// todoHandlersV1 serves the routes of version 2 as version 1, with the same
+// business logic. Todos only have the v1Fields, and updates leave the others
+// alone.
+func todoHandlersV1() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(deprecatedMiddleware)
+	rg.Mount("/", todoRouter(updateTodoV1))
+
+	return rg
+}
+
+// unversionedPath returns the path without its /v1 or /v2 prefix, so that
+// the paths of every version match those of /todo.
+func unversionedPath(path string) string {
+	for _, prefix := range []string{"/v1/", "/v2/"} {
+		if strings.HasPrefix(path, prefix) {
+			return path[len(prefix) - 1:]
+		}
+	}
+
+	return path
+}
+
+// updateTodoV1 sets the title and completion of the todo, as version 1 had
+// no other fields to replace.
+//
+// @Summary Set the title and completion of a todo
+// @Tags v1
+// @Accept json
+// @Produce json
+// @Description Version 1 todos only have id, title, completed and createdAt. Its responses carry a Deprecated header.
+// @Param id path string true "The todo id"
+// @Param body body object{title=string,completed=boolean} true "The title and completion"
+// @Success 200 {object} DataEnvelope{data=object{id=string,title=string,completed=boolean,createdAt=string}}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 403 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /v1/todo/{id} [put]
+func updateTodoV1(w http.ResponseWriter, r *http.Request) {
+	current, ok := findAccessibleTodo(w, r, true)
+	if !ok {
+		return
+	}
+
+	var t struct {
+		Title			string `json:"title" validate:"required,max=200"`
+		Completed		bool `json:"completed"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return
+	}
+
+	if !checkInput(w, r, t) {
+		return
+	}
+
+	set := bson.M{"title": t.Title}
+	unset := bson.M{}
+	setStatus(set, unset, current, completionStatus(current, t.Completed))
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	applyTodoUpdate(w, r, "updated", bson.M{"_id": current.ID}, update)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+)
+
+func TestVersion1EndpointsReturnOnlyV1Fields(t *testing.T) {
+	tests := []struct {
+		name		string
+		method		string
+		pattern		string
+		suffix		string
+		body		string
+		handler		http.HandlerFunc
+		archived	bool
+	}{
+		{"replace", http.MethodPut, "/{id}", "", `{"title": "Write the final report", "version": 3}`, updateTodo, false},
+		{"toggle", http.MethodPost, "/{id}/toggle", "/toggle", "", toggleTodo, false},
+		{"restore", http.MethodPost, "/{id}/restore", "/restore", "", restoreTodo, true},
+	}
+
+	want := append([]string{}, v1Fields...)
+	sort.Strings(want)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeMongo(t)
+			userID := bson.NewObjectId()
+			todo := storedTodo(f, t, userID)
+			if tt.archived {
+				f.set(t, cfg.CollectionName, todo.ID, bson.M{"archived": true, "archivedAt": time.Now()})
+			}
+
+			handler := deprecatedMiddleware(tt.handler).ServeHTTP
+			w := serveTodoRoute(tt.method, tt.pattern, "/" + todo.ID.Hex() + tt.suffix, tt.body, handler, userID)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			var body struct {
+				Data	map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("the body is not JSON: %v", err)
+			}
+
+			if got := renderedKeys(t, body.Data); !reflect.DeepEqual(got, want) {
+				t.Errorf("keys = %v, want only %v", got, want)
+			}
+			for _, field := range []string{"tags", "priority", "subtasks", "status", "version"} {
+				if _, ok := body.Data[field]; ok {
+					t.Errorf("the v2 field %s is in the version 1 response", field)
+				}
+			}
+		})
+	}
+}
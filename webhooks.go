@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	"github.com/thedevsaddam/renderer"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/broadcast"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/bson"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/store"
+	"github.com/nkpremices/go-chi-mongodb-simple-todo/src/utils"
+)
+
+const (
+	webhookCollectionName	string = "Webhook"
+	webhookTimeout			time.Duration = 5 * time.Second
+	signatureHeader			string = "X-Signature-256"
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+type(
+	WebhookModel struct {
+		ID				bson.ObjectId `bson:"_id,omitempty"`
+		UserID			bson.ObjectId `bson:"userID"`
+		TenantID		bson.ObjectId `bson:"tenantID,omitempty"`
+		URL				string `bson:"url"`
+		Events			[]string `bson:"events"`
+		Secret			string `bson:"secret"`
+		Active			bool `bson:"active"`
+		CreatedAt		time.Time `bson:"createdAt"`
+	}
+
+	// Webhook is a webhook as sent to and received from clients. The secret
+	// is only returned when the webhook is created.
+	Webhook struct {
+		ID				string `json:"id"`
+		URL				string `json:"url" validate:"required,url,max=2048"`
+		Events			[]string `json:"events" validate:"dive,oneof=created updated deleted"`
+		Secret			string `json:"secret,omitempty"`
+		Active			*bool `json:"active,omitempty"`
+		CreatedAt		time.Time `json:"createdAt"`
+	}
+
+	// webhookPayload is the body POSTed to webhooks.
+	webhookPayload struct {
+		Event			string `json:"event"`
+		TodoID			string `json:"todoId"`
+		Payload			interface{} `json:"payload,omitempty"`
+		Timestamp		time.Time `json:"timestamp"`
+	}
+)
+
+func toWebhook(h WebhookModel) Webhook {
+	return Webhook{
+		ID: h.ID.Hex(),
+		URL: h.URL,
+		Events: h.Events,
+		Active: &h.Active,
+		CreatedAt: h.CreatedAt,
+	}
+}
+
+// @Summary List webhooks
+// @Tags webhooks
+// @Produce json
+// @Success 200 {object} DataEnvelope{data=[]Webhook}
+// @Failure 401 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /webhooks [get]
+func fetchWebhooks(w http.ResponseWriter, r *http.Request) {
+	var hooks []WebhookModel
+
+	if err := timedOp(r.Context(), webhookCollectionName + ".find", func() error {
+		return dbFor(r).C(webhookCollectionName).Find(ownedBy(r, bson.M{})).Sort("createdAt").All(&hooks)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to fetch webhooks")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch webhooks", err.Error()))
+		return
+	}
+
+	hookList := []Webhook{}
+	for _, h := range hooks {
+		hookList = append(hookList, toWebhook(h))
+	}
+
+	rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": hookList,
+	})
+}
+
+// @Summary Get a webhook
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "The webhook id"
+// @Success 200 {object} DataEnvelope{data=Webhook}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /webhooks/{id} [get]
+func getWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toWebhook(hook),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// decodeWebhook reads and validates the webhook in the request body. When it
+// is invalid, the error response is written and ok is false.
+func decodeWebhook(w http.ResponseWriter, r *http.Request) (h Webhook, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "The body is invalid", err.Error()))
+		return h, false
+	}
+
+	h.URL = strings.TrimSpace(h.URL)
+	if !checkInput(w, r, h) {
+		return h, false
+	}
+
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusUnprocessableEntity, "The url must be an http or https URL", ""))
+		return h, false
+	}
+
+	return h, true
+}
+
+// @Summary Create a webhook
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param body body Webhook true "The webhook"
+// @Success 201 {object} DataEnvelope{data=Webhook}
+// @Failure 401 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /webhooks [post]
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	h, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	hook := WebhookModel{
+		ID: bson.NewObjectId(),
+		UserID: currentUserID(r),
+		TenantID: currentTenantID(r),
+		URL: h.URL,
+		Events: h.Events,
+		Secret: randomToken(),
+		Active: h.Active == nil || *h.Active,
+		CreatedAt: time.Now(),
+	}
+
+	if err := timedOp(r.Context(), webhookCollectionName + ".insert", func() error {
+		return dbFor(r).C(webhookCollectionName).Insert(&hook)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to save webhook")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to save webhook", ""))
+		return
+	}
+
+	created := toWebhook(hook)
+	created.Secret = hook.Secret
+
+	jsonErr := rnd.JSON(w, http.StatusCreated, renderer.M{
+		"data": created,
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Replace a webhook
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path string true "The webhook id"
+// @Param body body Webhook true "The webhook"
+// @Success 200 {object} DataEnvelope{data=Webhook}
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Failure 422 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /webhooks/{id} [put]
+func updateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	h, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	hook.URL, hook.Events = h.URL, h.Events
+	if h.Active != nil {
+		hook.Active = *h.Active
+	}
+
+	if err := timedOp(r.Context(), webhookCollectionName + ".update", func() error {
+		return dbFor(r).C(webhookCollectionName).UpdateId(hook.ID, bson.M{
+			"$set": bson.M{"url": hook.URL, "events": hook.Events, "active": hook.Active},
+		})
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to update webhook")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to update webhook", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"data": toWebhook(hook),
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// @Summary Delete a webhook
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "The webhook id"
+// @Success 200 {object} MessageResponse
+// @Failure 400 {object} ProblemDetails
+// @Failure 401 {object} ProblemDetails
+// @Failure 404 {object} ProblemDetails
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /webhooks/{id} [delete]
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := timedOp(r.Context(), webhookCollectionName + ".remove", func() error {
+		return dbFor(r).C(webhookCollectionName).RemoveId(hook.ID)
+	}); err != nil {
+		logFor(r).Error().Err(err).Msg("failed to delete webhook")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to delete webhook", ""))
+		return
+	}
+
+	jsonErr := rnd.JSON(w, http.StatusOK, renderer.M{
+		"message": "Webhook deleted successfully",
+	})
+
+	utils.CheckErr(jsonErr)
+}
+
+// findWebhook loads the user's webhook identified by the {id} URL parameter.
+// When the id is invalid or no webhook matches, the error response is written
+// and ok is false.
+func findWebhook(w http.ResponseWriter, r *http.Request) (hook WebhookModel, ok bool) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+
+	if !bson.IsObjectIdHex(id) {
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusBadRequest, "The id is invalid", ""))
+		return hook, false
+	}
+
+	if err := timedOp(r.Context(), webhookCollectionName + ".find", func() error {
+		return dbFor(r).C(webhookCollectionName).Find(ownedBy(r, bson.M{"_id": bson.ObjectIdHex(id)})).One(&hook)
+	}); err != nil {
+		if err == store.ErrNotFound {
+			utils.WriteProblem(w, r, utils.NewProblem(http.StatusNotFound, "Webhook not found", ""))
+			return hook, false
+		}
+
+		logFor(r).Error().Err(err).Msg("failed to fetch webhook")
+		utils.WriteProblem(w, r, utils.NewProblem(http.StatusProcessing, "Failed to fetch webhook", err.Error()))
+		return hook, false
+	}
+
+	return hook, true
+}
+
+// notifyWebhooks queues a delivery of the event to each of the user's active
+// webhooks subscribed to it, in the background so the request is not held
+// up. The delivery worker sends them and retries failures.
+func notifyWebhooks(logger *zerolog.Logger, userID bson.ObjectId, event broadcast.Event) {
+	go func() {
+		s := sess.Copy()
+		defer s.Close()
+
+		var hooks []WebhookModel
+
+		if err := s.DB(cfg.DBName).C(webhookCollectionName).Find(bson.M{
+			"userID": userID,
+			"active": true,
+			"$or": []bson.M{
+				{"events": event.Type},
+				{"events": bson.M{"$size": 0}},
+				{"events": nil},
+			},
+		}).All(&hooks); err != nil {
+			logger.Error().Err(err).Msg("failed to fetch webhooks")
+			return
+		}
+
+		if len(hooks) == 0 {
+			return
+		}
+
+		body, err := json.Marshal(webhookPayload{
+			Event: event.Type,
+			TodoID: event.TodoID,
+			Payload: event.Payload,
+			Timestamp: time.Now(),
+		})
+		utils.CheckErr(err)
+
+		now := time.Now()
+		deliveries := make([]interface{}, len(hooks))
+		for i, hook := range hooks {
+			deliveries[i] = &WebhookDeliveryModel{
+				ID: bson.NewObjectId(),
+				WebhookID: hook.ID,
+				UserID: userID,
+				TenantID: hook.TenantID,
+				Event: event.Type,
+				Payload: string(body),
+				Status: deliveryPending,
+				NextRetryAt: now,
+				CreatedAt: now,
+			}
+		}
+
+		if err := s.DB(cfg.DBName).C(deliveryCollectionName).Insert(deliveries...); err != nil {
+			logger.Error().Err(err).Msg("failed to queue webhook deliveries")
+			return
+		}
+
+		wakeDeliveryWorker()
+	}()
+}
+
+// deliverWebhook POSTs body to the webhook, signed with its secret.
+func deliverWebhook(hook WebhookModel, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, "sha256="+signPayload(hook.Secret, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{status: resp.StatusCode}
+	}
+
+	return nil
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed by secret.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var errWebhookInactive = errors.New("the webhook is inactive")
+
+type webhookStatusError struct {
+	status		int
+}
+
+func (e *webhookStatusError) Error() string {
+	return "the webhook responded with status " + http.StatusText(e.status)
+}
+
+func webhookHandlers() http.Handler {
+	rg := chi.NewRouter()
+	rg.Use(authMiddleware)
+
+	rg.Group(func(r chi.Router) {
+		r.Get("/", fetchWebhooks)
+		r.Post("/", createWebhook)
+		r.Get("/{id}", getWebhook)
+		r.Put("/{id}", updateWebhook)
+		r.Delete("/{id}", deleteWebhook)
+		r.Get("/{id}/deliveries", fetchWebhookDeliveries)
+	})
+
+	return rg
+}